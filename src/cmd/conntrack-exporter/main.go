@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	"conntrack-exporter/internal/app"
 	"conntrack-exporter/internal/config"
@@ -13,13 +15,51 @@ var (
 	version = "dev"
 )
 
+// commands are the subcommands, all taking the flags of the exporter; serve
+// is run when none is given.
+var commands = []struct{ name, help string }{
+	{"serve", "Serve metrics over HTTP (default)."},
+	{"once", "Collect once, print the metrics in the text exposition format and exit."},
+	{"dump", "Print the conntrack entries read from the table as JSON, one per line, and exit."},
+	{"check-config", "Check the flags and the configuration file, and exit."},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-14s%s\n", c.name, c.help)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
 func main() {
-	cfg := config.ParseFlags()
+	flag.Usage = usage
+
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve", "once", "dump":
+	case "check-config":
+		os.Exit(app.CheckConfig(args))
+	case "help":
+		config.ParseFlags(command, nil)
+		flag.CommandLine.SetOutput(os.Stdout)
+		flag.Usage()
+		os.Exit(app.ExitOK)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, see %s --help\n", command, os.Args[0])
+		os.Exit(app.ExitConfig)
+	}
+
+	cfg := config.ParseFlags(command, args)
 	if cfg.ShowHelp {
 		flag.Usage()
-		os.Exit(0)
+		os.Exit(app.ExitOK)
 	}
 
 	os.Exit(app.Run(cfg, version))
 }
-
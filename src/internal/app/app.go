@@ -2,8 +2,14 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -11,66 +17,426 @@ import (
 
 	"conntrack-exporter/internal/collector"
 	"conntrack-exporter/internal/config"
+	"conntrack-exporter/internal/ctnetlink"
+	"conntrack-exporter/internal/docker"
+	"conntrack-exporter/internal/fwset"
+	"conntrack-exporter/internal/geoip"
+	"conntrack-exporter/internal/kube"
 	"conntrack-exporter/internal/logging"
+	"conntrack-exporter/internal/ports"
 	"conntrack-exporter/internal/procfs"
+	"conntrack-exporter/internal/rdns"
+	"conntrack-exporter/internal/route"
 	"conntrack-exporter/internal/sysctl"
+	"conntrack-exporter/internal/systemd"
+	"conntrack-exporter/internal/tracing"
 	"conntrack-exporter/internal/web"
 )
 
-// Run wires the application together and blocks until termination.
+// logComponents are the components with a level of their own in
+// --log.level (see logging.Logger.Component).
+var logComponents = []string{"collector", "docker", "fwset", "kube", "ports", "route", "tracing", "web"}
+
+// Run wires the application together and runs cfg.Command: serve blocks
+// until termination, once and dump collect a single time (see once and
+// dump). It returns the exit code of the process (see ExitOK).
 func Run(cfg config.Config, version string) int {
-	level, err := logging.ParseLevel(cfg.LogLevel)
-	if err != nil {
-		level = logging.Info
-	}
 	format, err := logging.ParseFormat(cfg.LogFormat)
 	if err != nil {
 		format = logging.Logfmt
 	}
-	log := logging.New(os.Stderr, level, format)
+	levels, err := logging.ParseLevels(cfg.LogLevel, logComponents...)
+	if err != nil {
+		logging.New(os.Stderr, logging.Info, format).Error("invalid log level", "err", err)
+		return ExitConfig
+	}
+	level := levels.Default
+	target, err := logging.ParseTarget(cfg.LogTarget)
+	if err != nil {
+		logging.New(os.Stderr, level, format).Error("invalid log target", "err", err)
+		return ExitConfig
+	}
+	log, err := logging.NewTarget(target, "conntrack-exporter", level, format)
+	if err != nil {
+		// Keep logging, to stderr, rather than failing when the daemon is
+		// unavailable.
+		log = logging.New(os.Stderr, level, format)
+		log.Warn("failed to open log target, logging to stderr", "target", target, "err", err)
+	}
+	log.SetLevels(levels)
+	log.SetRepeatEvery(cfg.LogRepeatEvery)
 
 	if cfg.ShowHelp {
 		// We delegate help rendering to the flag package in main.
-		return 0
+		return ExitOK
 	}
 	if cfg.ShowVersion {
 		log.Info("version", "version", version)
-		return 0
+		return ExitOK
+	}
+
+	// The settings are valid past this point: only the files they refer to
+	// can still fail to load.
+	if errs := validate(cfg); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error("invalid configuration", "err", err)
+		}
+		return ExitConfig
+	}
+
+	// In scrape mode collectors refresh on each scrape instead of every
+	// --collector.interval (see collector.Options.Interval).
+	interval := cfg.CollectorInterval
+	if cfg.CollectorMode == "scrape" {
+		interval = 0
+	}
+	// Commands other than serve, and dry runs, collect a single time.
+	oneShot := cfg.Command != "serve" || cfg.DryRun
+	if oneShot {
+		interval = 0
+	}
+
+	sportMode, _ := collector.ParseSPortMode(cfg.SPortMode)
+	labels := []string(cfg.CollectorLabels)
+	if cfg.Aggregation != "" {
+		labels, _ = collector.AggregationLabels(cfg.Aggregation)
+	}
+	cidr, _ := collector.ParseCIDRAggregation(cfg.AggregateCIDR)
+
+	// Filters, relabeling rules and connlabel names can be reloaded.
+	rules, err := loadRules(cfg, log)
+	if err != nil {
+		log.Error("invalid configuration", "err", err)
+		return exitCode(err, ExitConfig)
+	}
+
+	// A dry run previews the metrics without changing the host: like
+	// --no-write, and without loading modules or applying --configure.*.
+	readOnly := cfg.NoWrite || cfg.DryRun
+	pfs := procfs.FS{Root: cfg.ProcfsPath, ReadOnly: readOnly}
+
+	// Only one instance may serve with the same PID file or textfile
+	// directory: the locks are taken before changing anything.
+	if cfg.PIDFile != "" && !oneShot {
+		pid, err := createPIDFile(cfg.PIDFile)
+		if err != nil {
+			log.Error("failed to create PID file", "err", err)
+			return exitCode(err, ExitRuntime)
+		}
+		defer pid.Remove()
+	}
+	if cfg.OutputTextfileDir != "" && !oneShot {
+		if fi, err := os.Stat(cfg.OutputTextfileDir); err != nil || !fi.IsDir() {
+			log.Error("invalid textfile directory", "dir", cfg.OutputTextfileDir)
+			return ExitConfig
+		}
+		dir, err := lockDir(cfg.OutputTextfileDir)
+		if err != nil {
+			log.Error("failed to lock textfile directory", "err", err)
+			return exitCode(err, ExitRuntime)
+		}
+		defer dir.Close()
 	}
 
-	pfs := procfs.FS{Root: cfg.ProcfsPath}
+	// The conntrack sysctls only exist once the module is loaded. When
+	// loading fails, it is retried in the background once serving.
+	var modules []string
+	if cfg.ConfigureModprobe && !readOnly {
+		if modules = conntrackModules(cfg, pfs); modules != nil {
+			if err := modprobe(context.Background(), modules); err != nil {
+				log.Warn("failed to load kernel modules", "modules", strings.Join(modules, ","), "err", err)
+			} else {
+				log.Info("loaded kernel modules", "modules", strings.Join(modules, ","))
+				modules = nil
+			}
+		}
+	}
 
-	// sysctl check/configure.
-	if cfg.ConfigureAcct {
+	// sysctl check/configure. The values found are restored on exit with
+	// --configure.restore-on-exit, when they were changed.
+	if cfg.ConfigureAcct && !readOnly {
+		prev, prevErr := sysctl.ReadNfConntrackAcct(pfs)
 		if err := sysctl.ConfigureNfConntrackAcct(pfs); err != nil {
 			log.Warn("failed to configure nf_conntrack_acct", "err", err)
 		} else {
 			log.Info("configured nf_conntrack_acct", "value", 1)
+			if cfg.ConfigureRestore && prevErr == nil && prev != 1 {
+				defer func() {
+					if err := sysctl.WriteNfConntrackAcct(pfs, prev); err != nil {
+						log.Warn("failed to restore nf_conntrack_acct", "err", err)
+					} else {
+						log.Info("restored nf_conntrack_acct", "value", prev)
+					}
+				}()
+			}
 		}
 	}
 
-	acct, err := sysctl.ReadNfConntrackAcct(pfs)
-	if err != nil {
-		log.Warn("failed to read nf_conntrack_acct", "err", err)
-	} else if acct == 0 {
-		log.Warn("nf_conntrack_acct is disabled; packets/bytes may be missing in nf_conntrack")
+	if cfg.ConfigureTstamp && !readOnly {
+		prev, prevErr := sysctl.ReadNfConntrackTimestamp(pfs)
+		if err := sysctl.ConfigureNfConntrackTimestamp(pfs); err != nil {
+			log.Warn("failed to configure nf_conntrack_timestamp", "err", err)
+		} else {
+			log.Info("configured nf_conntrack_timestamp", "value", 1)
+			if cfg.ConfigureRestore && prevErr == nil && prev != 1 {
+				defer func() {
+					if err := sysctl.WriteNfConntrackTimestamp(pfs, prev); err != nil {
+						log.Warn("failed to restore nf_conntrack_timestamp", "err", err)
+					} else {
+						log.Info("restored nf_conntrack_timestamp", "value", prev)
+					}
+				}()
+			}
+		}
 	}
 
-	// Prometheus registry and exporter metrics control.
-	reg := prometheus.NewRegistry()
-	if !cfg.WebDisableExporterMetrics {
+	constLabels, _ := parseConstLabels(cfg.ConstLabels)
+	if cfg.HostnameLabel {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Error("failed to get hostname", "err", err)
+			return ExitRuntime
+		}
+		constLabels["hostname"] = hostname
+	}
+
+	// Prometheus registry and exporter metrics control. Metrics are
+	// registered through reg, which adds the constant labels.
+	registry := prometheus.NewRegistry()
+	reg := prometheus.WrapRegistererWith(constLabels, registry)
+	// Only served: the runtime metrics of a single collection are of no use.
+	if !cfg.WebDisableExporterMetrics && !oneShot {
 		reg.MustRegister(
 			prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
 			prometheus.NewGoCollector(),
 		)
 	}
 
-	ctCollector := collector.NewConntrackCollector(pfs, cfg.CollectorInterval)
-	ctCollector.MustRegister(reg)
+	// The exporter's own metrics are registered through creg, which adds the
+	// metric prefix (see collector.DefaultPrefix).
+	wrap := func(r prometheus.Registerer) prometheus.Registerer {
+		r = prometheus.WrapRegistererWith(constLabels, r)
+		if cfg.MetricsPrefix != "" {
+			r = prometheus.WrapRegistererWithPrefix(cfg.MetricsPrefix+"_", r)
+		}
+		return r
+	}
+	creg := wrap(registry)
+	reportPrerequisites(checkPrerequisites(cfg, pfs), creg, log)
+
+	// Collectors register into registries of their own, which scrapes can
+	// select (see web.Server.Collectors).
+	collectors := map[string]prometheus.Gatherer{}
+	collectorReg := func(name string) prometheus.Registerer {
+		r := collector.NewRegistry()
+		collectors[name] = r
+		return r.Registerer(wrap)
+	}
+
+	// In netns mode the table is read per thread (see collector.NetnsSource).
+	sourceFS := pfs
+	if cfg.CollectorNetns {
+		sourceFS = procfs.FS{Root: pfs.Path("thread-self"), ReadOnly: pfs.ReadOnly}
+	}
+
+	collectorLog := log.Component("collector")
+	parseStats := collector.NewParseStats(collectorLog)
+	parseStats.MustRegister(creg)
+	health := collector.NewHealth(collectorLog, cfg.CollectorTimeout)
+	health.MustRegister(creg)
+
+	var source collector.Source
+	switch cfg.CollectorBackend {
+	case "netlink":
+		source = ctnetlink.Source{}
+	case "procfs":
+		source = collector.ProcfsSource{FS: sourceFS, Stats: parseStats}
+	}
+	if cfg.CollectorNetns {
+		source = collector.NetnsSource{
+			Inner:     source,
+			FS:        pfs,
+			RunDir:    cfg.NetnsRunDir,
+			ScanProcs: cfg.NetnsScanProcs,
+		}
+	}
+	if cfg.Command == "dump" {
+		if err := dump(context.Background(), source, os.Stdout); err != nil {
+			log.Error("failed to read conntrack entries", "err", err)
+			return exitCode(err, ExitRuntime)
+		}
+		return ExitOK
+	}
+
+	opts := collector.Options{
+		Interval:   interval,
+		Stats:      parseStats,
+		Health:     health,
+		Labels:     labels,
+		Metrics:    cfg.CollectorMetrics,
+		CIDR:       cidr,
+		LabelState: cfg.LabelState,
+		Timeouts:   cfg.CollectorTimeouts,
+		Counters:   cfg.CollectorCounters,
+		Churn:      cfg.CollectorChurn,
+		Durations:  cfg.CollectorDuration,
+		ConnBytes:  cfg.ConnBytes,
+		TopN:       cfg.CollectorTopN,
+		MaxSeries:  cfg.MaxSeries,
+		Shrinkable: cfg.MemoryLimit > 0,
+		Unprefixed: cfg.MetricsPrefix == "",
+		SeriesTTL:  cfg.SeriesTTL,
+		LabelMark:  cfg.LabelMark,
+		MarkMask:   uint32(cfg.MarkMask),
+		LabelZone:  cfg.LabelZone,
+		Zones:      cfg.CollectorZones,
+		LabelNAT:   cfg.LabelNAT,
+		LabelICMP:  cfg.LabelICMP,
+
+		SrcFilter:   rules.SrcFilter,
+		DstFilter:   rules.DstFilter,
+		L4Protocols: rules.L4Protocols,
+		DPortFilter: rules.DPortFilter,
+		Relabel:     rules.Relabel,
+		MinBytes:    cfg.FilterMinBytes,
+		MinPackets:  cfg.FilterMinPackets,
+
+		ReplyTuple:       cfg.CollectorTuple == "reply",
+		SPort:            sportMode,
+		LabelReply:       cfg.LabelReply,
+		LabelConnlabels:  cfg.LabelConnlabels,
+		ConnlabelNames:   rules.ConnlabelNames,
+		LabelNetns:       cfg.CollectorNetns,
+		ExcludeOffloaded: cfg.ExcludeOffloaded,
+
+		LabelScope: cfg.LabelScope,
+		LabelRDNS:  cfg.LabelRDNS,
+		// Also used when src_name/dst_name are selected with --collector.labels.
+		RDNS: &rdns.Resolver{
+			TTL:         cfg.RDNSTTL,
+			NegativeTTL: cfg.RDNSNegativeTTL,
+			Timeout:     cfg.RDNSTimeout,
+			Concurrency: cfg.RDNSConcurrency,
+			CacheSize:   cfg.RDNSCacheSize,
+		},
+	}
+	portTable := &ports.Table{}
+	if cfg.ServicesFile != "" {
+		names, err := ports.LoadServices(cfg.ServicesFile)
+		if err != nil {
+			// l7protocol falls back to the built-in list.
+			log.Warn("failed to load services file", "file", cfg.ServicesFile, "err", err)
+		}
+		portTable.Services = names
+	}
+	if cfg.PortsMappingFile != "" {
+		m, err := ports.LoadMapping(cfg.PortsMappingFile)
+		if err != nil {
+			log.Error("failed to load port mapping file", "err", err)
+			return exitCode(err, ExitConfig)
+		}
+		portTable.SetMapping(m)
+	} else if cfg.PortsMapping != nil {
+		portTable.SetMapping(cfg.PortsMapping)
+	}
+	opts.Ports = portTable
+	if len(cfg.GeoIPDBs) > 0 {
+		db, err := geoip.Open(cfg.GeoIPDBs)
+		if err != nil {
+			log.Error("failed to open GeoIP database", "err", err)
+			return exitCode(err, ExitConfig)
+		}
+		defer db.Close()
+		opts.LabelGeoIP, opts.GeoIP = true, db
+	}
+	var services *kube.Services
+	if cfg.KubeServices {
+		client := &kube.Client{URL: cfg.KubeAPIServer}
+		if cfg.KubeAPIServer == "" {
+			if client, err = kube.InCluster(); err != nil {
+				log.Error("failed to configure kubernetes client", "err", err)
+				return exitCode(err, ExitConfig)
+			}
+		}
+		services = &kube.Services{Client: client, Interval: cfg.KubeInterval, Logger: log.Component("kube")}
+		opts.LabelService, opts.Services = true, services
+	}
+	var containers *docker.Containers
+	if cfg.DockerContainers {
+		containers = &docker.Containers{Socket: cfg.DockerSocket, Interval: cfg.DockerInterval, Logger: log.Component("docker")}
+		opts.LabelContainer, opts.Containers = true, containers
+	}
+	var sets *fwset.Sets
+	if len(cfg.Sets) > 0 {
+		sets = &fwset.Sets{Interval: cfg.SetsInterval, Logger: log.Component("fwset")}
+		for _, spec := range cfg.Sets {
+			set, _ := fwset.ParseSet(spec)
+			sets.Sets = append(sets.Sets, set)
+		}
+		opts.LabelSet, opts.Sets = true, sets
+	}
+	var routes *route.Routes
+	if cfg.LabelInterface || slices.Contains(labels, "interface") {
+		routes = &route.Routes{FS: pfs, Interval: cfg.CollectorInterval, Logger: log.Component("route")}
+		opts.LabelInterface, opts.Routes = true, routes
+	}
+	if cfg.CollectorEvents {
+		opts.Events = ctnetlink.EventSource{}
+	}
+
+	ctCollector := collector.NewConntrackCollector(source, opts)
+	ctCollector.MustRegister(collectorReg("conntrack"))
+	var memory *memoryGuard
+	if cfg.MemoryLimit > 0 {
+		memory = newMemoryGuard(cfg.MemoryLimit, ctCollector, creg, log)
+	}
+
+	// The per-CPU statistics also feed the table pressure, even when they
+	// are not exported (--collector.stat).
+	var statSource collector.StatSource = collector.ProcfsStatSource{FS: pfs}
+	if cfg.CollectorBackend == "netlink" {
+		statSource = ctnetlink.StatSource{}
+	}
+
+	tableCollector := collector.NewTableCollector(pfs, statSource, interval, health)
+	tableCollector.MustRegister(collectorReg("table"))
+	sysctlCollector := collector.NewSysctlCollector(pfs, interval, health)
+	sysctlCollector.MustRegister(collectorReg("sysctl"))
+
+	var expectCollector *collector.ExpectCollector
+	if cfg.CollectorExpect {
+		expectCollector = collector.NewExpectCollector(pfs, interval, health)
+		expectCollector.MustRegister(collectorReg("expect"))
+	}
+	var statCollector *collector.StatCollector
+	if cfg.CollectorStat {
+		statCollector = collector.NewStatCollector(statSource, interval, health)
+		statCollector.MustRegister(collectorReg("stat"))
+	}
+	var listCollector *collector.ListCollector
+	if cfg.CollectorLists {
+		listCollector = collector.NewListCollector(ctnetlink.ListSource{}, interval, health)
+		listCollector.MustRegister(collectorReg("lists"))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if cfg.TracingEndpoint != "" {
+		shutdownTracing, err := tracing.Setup(ctx, cfg.TracingEndpoint, cfg.TracingSampleRatio, version, log.Component("tracing"))
+		if err != nil {
+			log.Error("failed to set up tracing", "err", err)
+			return exitCode(err, ExitConfig)
+		}
+		defer func() {
+			// Flush the pending spans, without blocking exit on an
+			// unreachable collector.
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = shutdownTracing(shutdownCtx)
+		}()
+	}
+
 	// Stop on SIGINT/SIGTERM.
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -79,29 +445,231 @@ func Run(cfg config.Config, version string) int {
 		cancel()
 	}()
 
+	// Reload on SIGHUP (and /-/reload, see web.Server.Reload).
+	var reloadMu sync.Mutex
+	reloadConfig := func() error {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		newCfg, err := config.Parse(cfg.Command, cfg.Args)
+		if err == nil {
+			err = reload(newCfg, log, ctCollector, portTable)
+		}
+		if err != nil {
+			log.Error("failed to reload configuration", "err", err)
+			return err
+		}
+		log.Info("reloaded configuration")
+		return nil
+	}
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupCh:
+			}
+			_ = reloadConfig()
+		}
+	}()
+
+	if cfg.PortsMappingFile != "" {
+		go portTable.WatchMapping(ctx, cfg.PortsMappingFile, cfg.CollectorInterval, log.Component("ports"))
+	}
+	if services != nil {
+		// Map services before the first snapshot.
+		if err := services.Refresh(ctx); err != nil {
+			log.Warn("failed to list kubernetes services", "err", err)
+		}
+		go services.Run(ctx)
+	}
+	if containers != nil {
+		if err := containers.Refresh(ctx); err != nil {
+			log.Warn("failed to list docker containers", "err", err)
+		}
+		go containers.Run(ctx)
+	}
+	if sets != nil {
+		if err := sets.Refresh(ctx); err != nil {
+			log.Warn("failed to list firewall sets", "err", err)
+		}
+		go sets.Run(ctx)
+	}
+	if routes != nil {
+		if err := routes.Refresh(); err != nil {
+			log.Warn("failed to read routes", "err", err)
+		}
+		go routes.Run(ctx)
+	}
+	if cfg.DryRun {
+		return dryRun(registry, collectors, ctCollector, os.Stdout, log)
+	}
+	if cfg.Command == "once" {
+		return once(registry, collectors, ctCollector, cfg.OutputFile, os.Stdout, log)
+	}
+	if modules != nil {
+		go retryModprobe(ctx, modules, log)
+	}
+	if memory != nil {
+		go memory.run(ctx, interval)
+	}
+	var textfileDone chan struct{}
+	if cfg.OutputTextfileDir != "" {
+		textfileDone = make(chan struct{})
+		go func() {
+			defer close(textfileDone)
+			writeTextfiles(ctx, cfg.OutputTextfileDir, cfg.CollectorInterval, gatherAll(ctx, registry, collectors), log)
+		}()
+	}
 	ctCollector.Start(ctx)
+	tableCollector.Start(ctx)
+	sysctlCollector.Start(ctx)
+	if expectCollector != nil {
+		expectCollector.Start(ctx)
+	}
+	if statCollector != nil {
+		statCollector.Start(ctx)
+	}
+	if listCollector != nil {
+		listCollector.Start(ctx)
+	}
+	listening := make(chan struct{})
+	go notifySystemd(ctx, health, interval, listening, func() {
+		_, _ = collector.ContextGatherer(ctx, collectors["conntrack"]).Gather()
+	}, log)
+
+	var webConfig *web.Config
+	if cfg.WebConfigFile != "" {
+		webConfig, err = web.LoadConfig(cfg.WebConfigFile)
+		if err != nil {
+			log.Error("invalid web config file", "err", err)
+			return exitCode(err, ExitConfig)
+		}
+	}
+	if cfg.WebBasicAuthUser != "" {
+		if webConfig == nil {
+			webConfig = &web.Config{}
+		}
+		if err := webConfig.AddBasicAuthUser(cfg.WebBasicAuthUser, cfg.WebBasicAuthPasswordFile); err != nil {
+			log.Error("invalid basic auth password file", "err", err)
+			return exitCode(err, ExitConfig)
+		}
+	}
+
+	// Any client could otherwise reload or stop the exporter.
+	if cfg.WebEnableLifecycle && !webConfig.BasicAuth() && len(cfg.WebAdminListenAddresses) == 0 {
+		log.Error("--web.enable-lifecycle requires basic auth or --web.admin-listen-address")
+		return ExitConfig
+	}
+	// Profiles expose the command line and internals of the process.
+	if cfg.WebEnablePprof && !webConfig.BasicAuth() && len(cfg.WebAdminListenAddresses) == 0 {
+		log.Error("--web.enable-pprof requires basic auth or --web.admin-listen-address")
+		return ExitConfig
+	}
+
+	var allowList *web.AllowList
+	if len(cfg.WebAllowCIDRs) > 0 {
+		allowList, _ = web.NewAllowList(cfg.WebAllowCIDRs)
+		allowList.MustRegister(creg)
+	}
+
+	socketMode, _ := strconv.ParseUint(cfg.WebSocketMode, 8, 32)
 
 	srv := &web.Server{
-		Logger:          log,
-		Registry:        reg,
-		TelemetryPath:   cfg.WebTelemetryPath,
-		ListenAddrs:     cfg.WebListenAddresses,
-		MaxRequests:     cfg.WebMaxRequests,
-		DisableExpMetrics: cfg.WebDisableExporterMetrics,
+		Logger:             log.Component("web"),
+		LogComponents:      logComponents,
+		Registry:           registry,
+		Registerer:         reg,
+		Collectors:         collectors,
+		Flows:              ctCollector.Flows,
+		ExporterRegisterer: creg,
+		TelemetryPath:      cfg.WebTelemetryPath,
+		CollectorsPath:     cfg.WebCollectorsPath,
+		ListenAddrs:        cfg.WebListenAddresses,
+		AdminListenAddrs:   cfg.WebAdminListenAddresses,
+		MaxRequests:        cfg.WebMaxRequests,
+		RateLimit:          cfg.WebRateLimit,
+		RateLimitBurst:     cfg.WebRateLimitBurst,
+		DisableExpMetrics:  cfg.WebDisableExporterMetrics,
+		OpenMetrics:        cfg.WebOpenMetrics,
+		DisableCompression: cfg.WebDisableCompression,
+		WebConfig:          webConfig,
+		AllowList:          allowList,
+		CORSOrigins:        cfg.WebCORSOrigins,
+		EnablePprof:        cfg.WebEnablePprof,
+		Tracing:            cfg.TracingEndpoint != "",
+		Ready:              ctCollector.Err,
+		SocketMode:         os.FileMode(socketMode),
+		SocketGroup:        cfg.WebSocketGroup,
+		ReadHeaderTimeout:  cfg.WebReadHeaderTimeout,
+		ReadTimeout:        cfg.WebReadTimeout,
+		WriteTimeout:       cfg.WebWriteTimeout,
+		IdleTimeout:        cfg.WebIdleTimeout,
+		ShutdownTimeout:    cfg.WebShutdownTimeout,
+
+		TolerateListenErrors: cfg.WebListenPolicy == "any",
+		Listening:            func() { close(listening) },
+	}
+	if cfg.WebFailOnError {
+		srv.Check = ctCollector.Err
+	}
+	if cfg.WebEnableLifecycle {
+		srv.Reload, srv.Quit = reloadConfig, cancel
 	}
 
 	// Run HTTP server (blocks). When it returns, stop collector.
 	err = srv.Start(ctx)
+	_ = systemd.Notify("STOPPING=1")
 	ctCollector.Stop()
+	tableCollector.Stop()
+	sysctlCollector.Stop()
+	if expectCollector != nil {
+		expectCollector.Stop()
+	}
+	if statCollector != nil {
+		statCollector.Stop()
+	}
+	if listCollector != nil {
+		listCollector.Stop()
+	}
+	if textfileDone != nil {
+		// Checkpoint the last collection, now that none is in progress.
+		<-textfileDone
+		writeTextfile(cfg.OutputTextfileDir, gatherAll(context.Background(), registry, collectors), log)
+	}
 
 	if err != nil {
 		log.Error("http server error", "err", err)
-		// Non-zero to indicate runtime error.
-		return 1
+		return exitCode(err, ExitRuntime)
 	}
 
-	// Give background goroutines a tiny moment to flush logs (best-effort).
-	time.Sleep(10 * time.Millisecond)
-	return 0
+	log.Info("shut down")
+	return ExitOK
+}
+
+// parseConstLabels parses `name=value` constant labels.
+func parseConstLabels(items []string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("%q: want name=value with a valid label name", item)
+		}
+		// Clashes with the labels of the exported metrics.
+		if collector.CheckLabels([]string{name}) == nil || slices.Contains(reservedLabels, name) {
+			return nil, fmt.Errorf("%q: %s is a label of the exported metrics", item, name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
 }
 
+var (
+	labelNameRE    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	metricPrefixRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+)
+
+// reservedLabels are the labels of the exported metrics other than the
+// per-connection ones.
+var reservedLabels = []string{"cpu", "helper", "kind", "type", "code", "le", "quantile"}
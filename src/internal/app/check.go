@@ -0,0 +1,219 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"conntrack-exporter/internal/collector"
+	"conntrack-exporter/internal/config"
+	"conntrack-exporter/internal/fwset"
+	"conntrack-exporter/internal/geoip"
+	"conntrack-exporter/internal/logging"
+	"conntrack-exporter/internal/ports"
+	"conntrack-exporter/internal/relabel"
+	"conntrack-exporter/internal/web"
+)
+
+// CheckConfig implements the check-config command: it parses args like the
+// exporter, checks the settings and the files they refer to, and reports
+// the errors, with the line of the configuration file at fault when known.
+// It returns ExitConfig when the configuration is invalid.
+func CheckConfig(args []string) int {
+	cfg, err := config.Parse("check-config", args)
+	if err != nil {
+		report(cfg.ConfigFile, err)
+		return ExitConfig
+	}
+	errs := Check(cfg)
+	for _, err := range errs {
+		report(cfg.ConfigFile, err)
+	}
+	if len(errs) > 0 {
+		return ExitConfig
+	}
+	if cfg.ConfigFile != "" {
+		fmt.Printf("%s: configuration is valid\n", cfg.ConfigFile)
+	} else {
+		fmt.Println("configuration is valid")
+	}
+	return ExitOK
+}
+
+// report prints err, and the line of the configuration file it refers to.
+func report(path string, err error) {
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+	n, line := config.Locate(path, err)
+	if n == 0 {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: %v\n  %s:%d: %s\n", err, path, n, line)
+}
+
+// Check validates the settings of cfg rejected by Run (see validate), and
+// the files they refer to, without side effects. Each error is a
+// config.SettingError naming the setting at fault.
+func Check(cfg config.Config) []error {
+	errs := validate(cfg)
+	add := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, &config.SettingError{Name: name, Err: err})
+		}
+	}
+
+	rules, name := cfg.Relabel, "relabel_configs"
+	if cfg.RelabelConfig != "" {
+		var err error
+		name = "collector.relabel-config"
+		rules, err = relabel.Load(cfg.RelabelConfig)
+		add(name, err)
+	}
+	for i, rule := range rules {
+		if err := collector.CheckLabels(rule.Names()); err != nil {
+			add(name, fmt.Errorf("rule %d: %w", i+1, err))
+		}
+	}
+	if cfg.PortsMappingFile != "" {
+		_, err := ports.LoadMapping(cfg.PortsMappingFile)
+		add("ports.mapping-file", err)
+	}
+	if len(cfg.GeoIPDBs) > 0 {
+		db, err := geoip.Open(cfg.GeoIPDBs)
+		add("enrich.geoip-db", err)
+		if err == nil {
+			db.Close()
+		}
+	}
+
+	webConfig := &web.Config{}
+	if cfg.WebConfigFile != "" {
+		var err error
+		webConfig, err = web.LoadConfig(cfg.WebConfigFile)
+		add("web.config.file", err)
+	}
+	if cfg.WebBasicAuthUser != "" && cfg.WebBasicAuthPasswordFile != "" && webConfig != nil {
+		add("web.basic-auth-password-file", webConfig.AddBasicAuthUser(cfg.WebBasicAuthUser, cfg.WebBasicAuthPasswordFile))
+	}
+	if cfg.WebEnableLifecycle && !webConfig.BasicAuth() && len(cfg.WebAdminListenAddresses) == 0 {
+		add("web.enable-lifecycle", errors.New("requires basic auth or web.admin-listen-address"))
+	}
+	if cfg.WebEnablePprof && !webConfig.BasicAuth() && len(cfg.WebAdminListenAddresses) == 0 {
+		add("web.enable-pprof", errors.New("requires basic auth or web.admin-listen-address"))
+	}
+	if cfg.OutputTextfileDir != "" {
+		if fi, err := os.Stat(cfg.OutputTextfileDir); err != nil {
+			add("output.textfile.directory", err)
+		} else if !fi.IsDir() {
+			add("output.textfile.directory", fmt.Errorf("%s is not a directory", cfg.OutputTextfileDir))
+		}
+	}
+	return errs
+}
+
+// validate checks the settings of cfg alone, without reading the files they
+// refer to; Run refuses to start when it fails. Each error is a
+// config.SettingError naming the setting at fault.
+func validate(cfg config.Config) []error {
+	var errs []error
+	add := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, &config.SettingError{Name: name, Err: err})
+		}
+	}
+
+	_, err := logging.ParseLevels(cfg.LogLevel, logComponents...)
+	add("log.level", err)
+	_, err = logging.ParseFormat(cfg.LogFormat)
+	add("log.format", err)
+	_, err = logging.ParseTarget(cfg.LogTarget)
+	add("log.target", err)
+
+	if cfg.MarkMask > 0xffffffff {
+		add("collector.mark-mask", errors.New("must fit in 32 bits"))
+	}
+	if cfg.CollectorTuple != "original" && cfg.CollectorTuple != "reply" {
+		add("collector.tuple", fmt.Errorf("unknown tuple %q", cfg.CollectorTuple))
+	}
+	// A zero interval would write the textfile in a busy loop.
+	if cfg.CollectorInterval <= 0 {
+		add("collector.interval", errors.New("must be positive"))
+	}
+	if cfg.CollectorMode != "periodic" && cfg.CollectorMode != "scrape" {
+		add("collector.mode", fmt.Errorf("unknown mode %q", cfg.CollectorMode))
+	}
+	if cfg.CollectorBackend != "procfs" && cfg.CollectorBackend != "netlink" {
+		add("collector.backend", fmt.Errorf("unknown backend %q", cfg.CollectorBackend))
+	}
+	if _, ok := collector.ParseSPortMode(cfg.SPortMode); !ok {
+		add("collector.sport-mode", fmt.Errorf("unknown source port mode %q", cfg.SPortMode))
+	}
+	add("collector.labels", collector.CheckLabels(cfg.CollectorLabels))
+	add("collector.metrics", collector.CheckMetrics(cfg.CollectorMetrics))
+	if cfg.CollectorCounters && cfg.WebOpenMetrics {
+		add("collector.counters", collector.CheckOpenMetrics(cfg.CollectorMetrics))
+	}
+	if cfg.Aggregation != "" {
+		if len(cfg.CollectorLabels) > 0 {
+			add("collector.aggregation", errors.New("mutually exclusive with collector.labels"))
+		}
+		_, err := collector.AggregationLabels(cfg.Aggregation)
+		add("collector.aggregation", err)
+	}
+	_, err = collector.ParseCIDRAggregation(cfg.AggregateCIDR)
+	add("collector.aggregate-cidr", err)
+
+	if cfg.NoWrite && (cfg.ConfigureAcct || cfg.ConfigureTstamp || cfg.ConfigureModprobe) {
+		add("no-write", errors.New("mutually exclusive with configure.nf_conntrack_acct, configure.nf_conntrack_timestamp and configure.modprobe"))
+	}
+
+	_, err = collector.ParseCIDRFilter(cfg.FilterSrcCIDR)
+	add("filter.src-cidr", err)
+	_, err = collector.ParseCIDRFilter(cfg.FilterDstCIDR)
+	add("filter.dst-cidr", err)
+	_, err = collector.ParsePortFilter(cfg.FilterDPort)
+	add("filter.dport", err)
+
+	// The refresh loops tick every interval (see time.NewTicker).
+	if cfg.KubeServices && cfg.KubeInterval <= 0 {
+		add("kube.refresh-interval", errors.New("must be positive"))
+	}
+	if cfg.DockerContainers && cfg.DockerInterval <= 0 {
+		add("docker.refresh-interval", errors.New("must be positive"))
+	}
+	for _, spec := range cfg.Sets {
+		_, err := fwset.ParseSet(spec)
+		add("enrich.set", err)
+	}
+	if len(cfg.Sets) > 0 && cfg.SetsInterval <= 0 {
+		add("enrich.set-refresh-interval", errors.New("must be positive"))
+	}
+
+	_, err = parseConstLabels(cfg.ConstLabels)
+	add("metrics.const-label", err)
+	if cfg.MetricsPrefix != "" && !metricPrefixRE.MatchString(cfg.MetricsPrefix) {
+		add("metrics.prefix", fmt.Errorf("invalid prefix %q", cfg.MetricsPrefix))
+	}
+
+	if (cfg.WebBasicAuthUser == "") != (cfg.WebBasicAuthPasswordFile == "") {
+		add("web.basic-auth-user", errors.New("must be set with web.basic-auth-password-file"))
+	}
+	if len(cfg.WebAllowCIDRs) > 0 {
+		_, err := web.NewAllowList(cfg.WebAllowCIDRs)
+		add("web.allow-cidr", err)
+	}
+	if mode, err := strconv.ParseUint(cfg.WebSocketMode, 8, 32); err != nil || mode > 0o777 {
+		add("web.socket-mode", fmt.Errorf("invalid socket mode %q", cfg.WebSocketMode))
+	}
+	if cfg.WebListenPolicy != "all" && cfg.WebListenPolicy != "any" {
+		add("web.listen-policy", fmt.Errorf("unknown listen policy %q", cfg.WebListenPolicy))
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		add("tracing.sample-ratio", errors.New("must be between 0 and 1"))
+	}
+	return errs
+}
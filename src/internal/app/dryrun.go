@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/collector"
+	"conntrack-exporter/internal/logging"
+)
+
+// dryRunTop is the number of label values listed by dryRun.
+const dryRunTop = 20
+
+// count is a line of the report of dryRun.
+type count struct {
+	name   string
+	series int
+}
+
+// writeCounts writes a table of counts, in aligned columns.
+func writeCounts(w io.Writer, header string, cs []count) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+	for _, c := range cs {
+		fmt.Fprintf(tw, "%s\t%d\n", c.name, c.series)
+	}
+	return tw.Flush()
+}
+
+// dryRun implements --dry-run: it collects a single time, like once, and
+// writes to w a report of the series each metric family would have, of the
+// number of values of each label, and of the label values with the most
+// series, to tune filters and aggregation before they reach Prometheus.
+func dryRun(registry *prometheus.Registry, collectors map[string]prometheus.Gatherer, ct *collector.ConntrackCollector, w io.Writer, log *logging.Logger) int {
+	mfs, err := gatherAll(context.Background(), registry, collectors).Gather()
+	if err != nil {
+		log.Error("failed to gather metrics", "err", err)
+		return exitCode(err, ExitRuntime)
+	}
+	if err := ct.Err(); err != nil {
+		log.Error("failed to read conntrack table", "err", err)
+		return exitCode(err, ExitRuntime)
+	}
+
+	var families []count
+	total := 0
+	values := map[string]map[string]int{} // series by value, by label
+	for _, mf := range mfs {
+		families = append(families, count{mf.GetName(), len(mf.GetMetric())})
+		total += len(mf.GetMetric())
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if values[lp.GetName()] == nil {
+					values[lp.GetName()] = map[string]int{}
+				}
+				values[lp.GetName()][lp.GetValue()]++
+			}
+		}
+	}
+	var labels, top []count
+	for name, vs := range values {
+		labels = append(labels, count{name, len(vs)})
+		for v, n := range vs {
+			top = append(top, count{name + "=" + fmt.Sprintf("%q", v), n})
+		}
+	}
+	byCount := func(cs []count) {
+		sort.Slice(cs, func(i, j int) bool {
+			if cs[i].series != cs[j].series {
+				return cs[i].series > cs[j].series
+			}
+			return cs[i].name < cs[j].name
+		})
+	}
+	byCount(families)
+	byCount(labels)
+	byCount(top)
+	if len(top) > dryRunTop {
+		top = top[:dryRunTop]
+	}
+
+	families = append(families, count{"total", total})
+	err = writeCounts(w, "FAMILY\tSERIES", families)
+	if err == nil {
+		err = writeCounts(w, "\nLABEL\tVALUES", labels)
+	}
+	if err == nil {
+		err = writeCounts(w, "\nLABEL VALUE\tSERIES", top)
+	}
+	if err != nil {
+		log.Error("failed to write report", "err", err)
+		return exitCode(err, ExitRuntime)
+	}
+	return ExitOK
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"errors"
+	"io/fs"
+
+	"conntrack-exporter/internal/web"
+)
+
+// Exit codes of Run and of the commands, so that supervisors and scripts can
+// tell a configuration to fix from a failure worth a restart.
+const (
+	ExitOK = 0
+	// ExitRuntime is a failure while running, e.g. the conntrack table
+	// could not be read by once, or a server failed after startup.
+	ExitRuntime = 1
+	// ExitConfig is an invalid flag, setting or file, like the flag package
+	// exits with on invalid flags.
+	ExitConfig = 2
+	// ExitPermission is a file or resource the exporter is not allowed to
+	// access (EPERM, EACCES).
+	ExitPermission = 3
+	// ExitBind is a listen address that could not be bound.
+	ExitBind = 4
+)
+
+// exitCode returns the exit code for err: ExitBind or ExitPermission when it
+// is a listen or permission error, fallback otherwise.
+func exitCode(err error, fallback int) int {
+	var le *web.ListenError
+	switch {
+	case errors.As(err, &le):
+		return ExitBind
+	case errors.Is(err, fs.ErrPermission):
+		return ExitPermission
+	}
+	return fallback
+}
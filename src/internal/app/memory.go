@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/collector"
+	"conntrack-exporter/internal/logging"
+)
+
+// Fractions of --runtime.memory-limit above which the series are shrunk, and
+// below which they are restored.
+const (
+	memoryHigh = 0.9
+	memoryLow  = 0.6
+)
+
+// memoryCheckInterval is how often memory is checked in scrape mode.
+const memoryCheckInterval = 10 * time.Second
+
+// memoryInUse returns the memory the Go runtime holds from the OS, as
+// accounted against the memory limit.
+func memoryInUse() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// memoryGuard keeps the exporter within --runtime.memory-limit: it sets the
+// soft memory limit of the runtime, and degrades the collection while the
+// memory in use stays above memoryHigh of it: the first check coarsens the
+// aggregation of the conntrack collector, blanking its address labels (see
+// ConntrackCollector.CoarsenSeries), and each next one halves its number of
+// series (see ConntrackCollector.ShrinkSeries). The series are restored once
+// the memory in use falls below memoryLow of the limit.
+type memoryGuard struct {
+	limit    int64
+	ct       *collector.ConntrackCollector
+	degraded prometheus.Gauge
+	log      *logging.Logger
+}
+
+func newMemoryGuard(limit int64, ct *collector.ConntrackCollector, reg prometheus.Registerer, log *logging.Logger) *memoryGuard {
+	debug.SetMemoryLimit(limit)
+	g := &memoryGuard{
+		limit: limit,
+		ct:    ct,
+		degraded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "exporter_memory_degraded",
+			Help: "1 while coarser or fewer series are exported to stay within --runtime.memory-limit, 0 otherwise.",
+		}),
+		log: log,
+	}
+	reg.MustRegister(g.degraded)
+	return g
+}
+
+// run checks the memory in use every interval until ctx is done.
+func (g *memoryGuard) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = memoryCheckInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	shrunk := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		inUse := memoryInUse()
+		switch {
+		case float64(inUse) > memoryHigh*float64(g.limit):
+			if g.ct.CoarsenSeries() {
+				g.log.Warn("memory limit approached, exporting series without address labels", "in_use", inUse, "limit", g.limit)
+				shrunk = true
+				g.degraded.Set(1)
+				continue
+			}
+			budget := g.ct.ShrinkSeries()
+			if !shrunk {
+				g.log.Warn("memory limit approached, exporting fewer series", "in_use", inUse, "limit", g.limit, "max_series", budget)
+			} else {
+				g.log.Debug("memory limit still approached, exporting fewer series", "in_use", inUse, "limit", g.limit, "max_series", budget)
+			}
+			shrunk = true
+			g.degraded.Set(1)
+		case shrunk && float64(inUse) < memoryLow*float64(g.limit):
+			g.ct.RestoreSeries()
+			g.log.Info("memory back under the limit, exporting all series", "in_use", inUse, "limit", g.limit)
+			shrunk = false
+			g.degraded.Set(0)
+		}
+	}
+}
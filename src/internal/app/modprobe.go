@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"conntrack-exporter/internal/config"
+	"conntrack-exporter/internal/logging"
+	"conntrack-exporter/internal/procfs"
+)
+
+// Backoff between two attempts to load the conntrack modules.
+const (
+	modprobeMinBackoff = time.Second
+	modprobeMaxBackoff = 5 * time.Minute
+)
+
+// sysModuleDir lists the loaded kernel modules, and built-in ones with
+// parameters.
+const sysModuleDir = "/sys/module"
+
+// conntrackModules returns the kernel modules the collectors enabled by cfg
+// need and that are not loaded yet, nil when there is none.
+func conntrackModules(cfg config.Config, pfs procfs.FS) []string {
+	modules := []string{"nf_conntrack"}
+	if cfg.CollectorBackend == "netlink" || cfg.CollectorLists || cfg.CollectorEvents {
+		modules = append(modules, "nf_conntrack_netlink")
+	}
+	var missing []string
+	for _, m := range modules {
+		if !moduleLoaded(m, pfs) {
+			missing = append(missing, m)
+		}
+	}
+	return missing
+}
+
+// moduleLoaded reports whether the kernel module name is loaded, from
+// sysfs; the conntrack table tells for nf_conntrack when sysfs is not
+// mounted, e.g. in a container.
+func moduleLoaded(name string, pfs procfs.FS) bool {
+	if _, err := os.Stat(filepath.Join(sysModuleDir, name)); err == nil {
+		return true
+	}
+	if name == "nf_conntrack" {
+		_, err := os.Stat(pfs.Path("net/nf_conntrack"))
+		return err == nil
+	}
+	return false
+}
+
+// modprobe loads modules with modprobe(8).
+func modprobe(ctx context.Context, modules []string) error {
+	args := append([]string{"-a"}, modules...)
+	out, err := exec.CommandContext(ctx, "modprobe", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// retryModprobe tries to load modules until it succeeds or ctx is done,
+// doubling the wait between two attempts up to modprobeMaxBackoff. The
+// collectors pick the table up on their next refresh.
+func retryModprobe(ctx context.Context, modules []string, log *logging.Logger) {
+	backoff := modprobeMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		err := modprobe(ctx, modules)
+		if err == nil {
+			log.Info("loaded kernel modules", "modules", strings.Join(modules, ","))
+			return
+		}
+		backoff = min(2*backoff, modprobeMaxBackoff)
+		log.Warn("failed to load kernel modules", "modules", strings.Join(modules, ","), "err", err, "retry_in", backoff)
+	}
+}
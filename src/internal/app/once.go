@@ -0,0 +1,86 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"conntrack-exporter/internal/collector"
+	"conntrack-exporter/internal/conntrack"
+	"conntrack-exporter/internal/logging"
+)
+
+// once implements the once command: it collects a single time (collectors
+// are in scrape mode) and writes all metrics in the text exposition format,
+// to w or, atomically, to the file at path when set (e.g. a .prom file of the
+// textfile collector of node_exporter). It returns 1 when the conntrack table
+// could not be read, after writing the metrics of the other collectors.
+func once(registry *prometheus.Registry, collectors map[string]prometheus.Gatherer, ct *collector.ConntrackCollector, path string, w io.Writer, log *logging.Logger) int {
+	gatherers := gatherAll(context.Background(), registry, collectors)
+	var err error
+	if path != "" {
+		// The file is left as is when gathering fails.
+		err = prometheus.WriteToTextfile(path, gatherers)
+	} else {
+		err = writeText(w, gatherers)
+	}
+	if err != nil {
+		log.Error("failed to write metrics", "err", err)
+		return exitCode(err, ExitRuntime)
+	}
+	if err := ct.Err(); err != nil {
+		log.Error("failed to read conntrack table", "err", err)
+		return exitCode(err, ExitRuntime)
+	}
+	return ExitOK
+}
+
+// gatherAll gathers the collectors, then registry: in scrape mode, the
+// exporter metrics of registry (e.g. collection durations) then describe
+// the collection of the same gathering, which runs with ctx.
+func gatherAll(ctx context.Context, registry *prometheus.Registry, collectors map[string]prometheus.Gatherer) prometheus.Gatherers {
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var gatherers prometheus.Gatherers
+	for _, name := range names {
+		gatherers = append(gatherers, collector.ContextGatherer(ctx, collectors[name]))
+	}
+	return append(gatherers, registry)
+}
+
+// writeText writes the metrics of g to w in the text exposition format,
+// including the ones gathered without errors when others fail.
+func writeText(w io.Writer, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	bw := bufio.NewWriter(w)
+	enc := expfmt.NewEncoder(bw, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range mfs {
+		if werr := enc.Encode(mf); werr != nil {
+			return werr
+		}
+	}
+	return errors.Join(err, bw.Flush())
+}
+
+// dump implements the dump command: it writes the entries of source to w
+// as JSON, one per line.
+func dump(ctx context.Context, source collector.Source, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var werr error
+	err := source.Entries(ctx, func(e conntrack.Entry) {
+		if werr == nil {
+			werr = enc.Encode(e)
+		}
+	})
+	return errors.Join(err, werr, bw.Flush())
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock takes an exclusive flock(2) on f, failing at once when another
+// process holds it. The lock goes away with the process, even when killed.
+func lock(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errors.New("locked by another instance")
+	}
+	return err
+}
+
+// pidFile is a locked file holding the PID of the exporter (--pid-file).
+type pidFile struct {
+	f *os.File
+}
+
+// createPIDFile writes the PID of the process to path, and locks it, so that
+// a second instance with the same file fails to start.
+func createPIDFile(path string) (*pidFile, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lock(f); err != nil {
+			data, _ := os.ReadFile(path)
+			f.Close()
+			if pid := strings.TrimSpace(string(data)); pid != "" {
+				return nil, fmt.Errorf("%s: %w (pid %s)", path, err, pid)
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		// The instance holding the lock may have removed the file between
+		// the open and the lock (see Remove): the lock is then on a file
+		// another instance can create again, so start over.
+		if !sameFile(f, path) {
+			f.Close()
+			continue
+		}
+		if err := f.Truncate(0); err == nil {
+			_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return &pidFile{f: f}, nil
+	}
+}
+
+// sameFile reports whether path is still the file f was opened from.
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, pi)
+}
+
+// Remove deletes the file, then releases the lock: an instance that opened
+// the file before then starts over (see createPIDFile).
+func (p *pidFile) Remove() {
+	_ = os.Remove(p.f.Name())
+	p.f.Close()
+}
+
+// lockDir locks directory dir for the life of the process, so that two
+// instances do not write the same textfile.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return f, nil
+}
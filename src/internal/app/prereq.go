@@ -0,0 +1,99 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+
+	"conntrack-exporter/internal/config"
+	"conntrack-exporter/internal/logging"
+	"conntrack-exporter/internal/procfs"
+	"conntrack-exporter/internal/sysctl"
+)
+
+// prerequisite is a startup check: a condition the configured collectors
+// depend on, and how to fix it when it is not met.
+type prerequisite struct {
+	name string
+	err  error
+	hint string
+}
+
+// checkPrerequisites checks what the collectors enabled by cfg need: the
+// conntrack table, packets/bytes accounting and capabilities. Problems
+// otherwise only show up later, as opaque collection errors.
+func checkPrerequisites(cfg config.Config, pfs procfs.FS) []prerequisite {
+	var checks []prerequisite
+	add := func(name string, err error, hint string) {
+		checks = append(checks, prerequisite{name: name, err: err, hint: hint})
+	}
+
+	if cfg.CollectorBackend == "procfs" {
+		table := pfs.Path("net/nf_conntrack")
+		_, err := os.Stat(table)
+		add("conntrack_table", err, "load the nf_conntrack module (modprobe nf_conntrack, or --configure.modprobe), or check --path.procfs")
+		if err == nil {
+			f, err := os.Open(table)
+			if err == nil {
+				f.Close()
+			}
+			add("conntrack_table_readable", err, "run as root, or with CAP_NET_ADMIN in the initial network namespace")
+		}
+	}
+
+	acct, err := sysctl.ReadNfConntrackAcct(pfs)
+	if err == nil && acct != 1 {
+		err = errors.New("nf_conntrack_acct is disabled; packets/bytes may be missing in nf_conntrack")
+	}
+	add("nf_conntrack_acct", err, "set net.netfilter.nf_conntrack_acct=1, or use --configure.nf_conntrack_acct")
+
+	if cfg.CollectorBackend == "netlink" || cfg.CollectorLists || cfg.CollectorEvents {
+		add("cap_net_admin", hasCapability(unix.CAP_NET_ADMIN), "grant CAP_NET_ADMIN (e.g. AmbientCapabilities=CAP_NET_ADMIN, or --cap-add=NET_ADMIN)")
+	}
+	if cfg.CollectorNetns {
+		add("cap_sys_admin", hasCapability(unix.CAP_SYS_ADMIN), "grant CAP_SYS_ADMIN, needed to enter network namespaces")
+	}
+	return checks
+}
+
+// hasCapability returns an error unless capability c is in the effective set
+// of the process.
+func hasCapability(c int) error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capget: %w", err)
+	}
+	if data[c/32].Effective&(1<<(uint(c)%32)) == 0 {
+		return errors.New("capability not in the effective set")
+	}
+	return nil
+}
+
+// reportPrerequisites logs the checks that failed, with a hint, and exports
+// them as exporter_capability{name} (1 when met, 0 otherwise).
+func reportPrerequisites(checks []prerequisite, reg prometheus.Registerer, log *logging.Logger) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "exporter_capability",
+		Help: "Whether a prerequisite checked at startup is met (1) or not (0), see the startup log.",
+	}, []string{"name"})
+	reg.MustRegister(gauge)
+
+	failed := 0
+	for _, c := range checks {
+		if c.err != nil {
+			failed++
+			log.Warn("prerequisite not met", "name", c.name, "err", c.err, "hint", c.hint)
+			gauge.WithLabelValues(c.name).Set(0)
+			continue
+		}
+		log.Debug("prerequisite met", "name", c.name)
+		gauge.WithLabelValues(c.name).Set(1)
+	}
+	if failed == 0 {
+		log.Info("all prerequisites met", "checked", len(checks))
+	}
+}
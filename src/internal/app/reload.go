@@ -0,0 +1,83 @@
+package app
+
+import (
+	"fmt"
+
+	"conntrack-exporter/internal/collector"
+	"conntrack-exporter/internal/config"
+	"conntrack-exporter/internal/connlabel"
+	"conntrack-exporter/internal/logging"
+	"conntrack-exporter/internal/ports"
+	"conntrack-exporter/internal/relabel"
+)
+
+// loadRules builds the collector rules of cfg: filters, relabeling rules and
+// connlabel names.
+func loadRules(cfg config.Config, log *logging.Logger) (collector.Rules, error) {
+	var r collector.Rules
+	var err error
+	if r.SrcFilter, err = collector.ParseCIDRFilter(cfg.FilterSrcCIDR); err != nil {
+		return r, fmt.Errorf("invalid source filter: %w", err)
+	}
+	if r.DstFilter, err = collector.ParseCIDRFilter(cfg.FilterDstCIDR); err != nil {
+		return r, fmt.Errorf("invalid destination filter: %w", err)
+	}
+	if r.DPortFilter, err = collector.ParsePortFilter(cfg.FilterDPort); err != nil {
+		return r, fmt.Errorf("invalid destination port filter: %w", err)
+	}
+	r.L4Protocols = cfg.FilterL4Proto
+
+	// The relabel config file takes precedence over the relabel_configs of
+	// the configuration file.
+	r.Relabel = cfg.Relabel
+	source := "relabel_configs of " + cfg.ConfigFile
+	if cfg.RelabelConfig != "" {
+		if r.Relabel, err = relabel.Load(cfg.RelabelConfig); err != nil {
+			return r, fmt.Errorf("failed to load relabel config: %w", err)
+		}
+		source = cfg.RelabelConfig
+	}
+	for i, rule := range r.Relabel {
+		if err := collector.CheckLabels(rule.Names()); err != nil {
+			return r, fmt.Errorf("invalid relabel config %s: rule %d: %w", source, i+1, err)
+		}
+	}
+	if cfg.LabelConnlabels {
+		names, err := connlabel.Load(cfg.ConnlabelFile)
+		if err != nil {
+			// Labels are still exported, as bit numbers (bit0, bit1, ...).
+			log.Warn("failed to load connlabel names", "file", cfg.ConnlabelFile, "err", err)
+		}
+		r.ConnlabelNames = names
+	}
+	return r, nil
+}
+
+// reload applies the settings of cfg that can change at runtime, on SIGHUP:
+// log levels (replacing those set through /-/loglevel), collector rules (see
+// loadRules) and port mapping. Nothing is applied when one of them fails to
+// load.
+//
+// cfg is parsed again on each reload (see config.Parse), so that these
+// settings can change in the configuration file.
+func reload(cfg config.Config, log *logging.Logger, ct *collector.ConntrackCollector, portTable *ports.Table) error {
+	levels, err := logging.ParseLevels(cfg.LogLevel, logComponents...)
+	if err != nil {
+		return err
+	}
+	rules, err := loadRules(cfg, log)
+	if err != nil {
+		return err
+	}
+	mapping := cfg.PortsMapping
+	if cfg.PortsMappingFile != "" {
+		if mapping, err = ports.LoadMapping(cfg.PortsMappingFile); err != nil {
+			return fmt.Errorf("failed to load port mapping file: %w", err)
+		}
+	}
+
+	log.SetLevels(levels)
+	ct.SetRules(rules)
+	portTable.SetMapping(mapping)
+	return nil
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"conntrack-exporter/internal/collector"
+	"conntrack-exporter/internal/logging"
+	"conntrack-exporter/internal/systemd"
+)
+
+// readyPoll is how often the first successful collection is waited for,
+// and readyCollect how often it is attempted in scrape mode.
+const (
+	readyPoll    = 200 * time.Millisecond
+	readyCollect = 5 * time.Second
+)
+
+// notifySystemd reports readiness to systemd once listening is closed (the
+// listeners are bound) and the conntrack table was collected successfully,
+// then pings the watchdog (WatchdogSec=) as long as collections keep
+// succeeding: a wedged collection stops the pings, and systemd restarts the
+// exporter. In scrape mode (interval 0), collections only run on scrapes:
+// collect runs one for readiness, and the watchdog is then pinged
+// unconditionally.
+func notifySystemd(ctx context.Context, health *collector.Health, interval time.Duration, listening <-chan struct{}, collect func(), log *logging.Logger) {
+	if !systemd.Enabled() {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-listening:
+	}
+	poll := readyPoll
+	if interval <= 0 {
+		poll = readyCollect
+	}
+	ready := time.NewTicker(poll)
+	for {
+		if interval <= 0 {
+			collect()
+		}
+		if !health.LastSuccess("conntrack").IsZero() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			ready.Stop()
+			return
+		case <-ready.C:
+		}
+	}
+	ready.Stop()
+	if err := systemd.Notify("READY=1"); err != nil {
+		log.Warn("failed to notify systemd", "err", err)
+		return
+	}
+
+	wd := systemd.WatchdogInterval()
+	if wd <= 0 {
+		return
+	}
+	// Same staleness as the alert on exporter_last_collect_timestamp_seconds.
+	stale := 3 * interval
+	t := time.NewTicker(wd / 2)
+	defer t.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if interval > 0 {
+			last := health.LastSuccess("conntrack")
+			if ok := time.Since(last) < stale; ok != healthy {
+				healthy = ok
+				if !healthy {
+					log.Warn("conntrack collection is stale, stopped pinging the systemd watchdog", "last_success", last)
+				} else {
+					log.Info("conntrack collection recovered, pinging the systemd watchdog again")
+				}
+			}
+			if !healthy {
+				continue
+			}
+		}
+		if err := systemd.Notify("WATCHDOG=1"); err != nil {
+			log.Warn("failed to ping the systemd watchdog", "err", err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// textfileName is the file written in --output.textfile.directory.
+const textfileName = "conntrack-exporter.prom"
+
+// runtimePrefixes are the metric families left out of textfiles: the
+// runtime and HTTP handler metrics of node_exporter, which reads them, have
+// the same names.
+var runtimePrefixes = []string{"go_", "process_", "promhttp_"}
+
+// withoutRuntime gathers the metrics of g, except the runtime ones.
+type withoutRuntime struct {
+	g prometheus.Gatherer
+}
+
+func (w withoutRuntime) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := w.g.Gather()
+	kept := mfs[:0]
+	for _, mf := range mfs {
+		if !hasAnyPrefix(mf.GetName(), runtimePrefixes) {
+			kept = append(kept, mf)
+		}
+	}
+	return kept, err
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTextfiles writes the metrics of g to the textfile of dir every
+// interval, atomically, until ctx is done. Writes happen halfway between
+// two collections, so that they see complete snapshots.
+func writeTextfiles(ctx context.Context, dir string, interval time.Duration, g prometheus.Gatherer, log *logging.Logger) {
+	timer := time.NewTimer(interval / 2)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		writeTextfile(dir, g, log)
+		timer.Reset(interval)
+	}
+}
+
+// writeTextfile writes the metrics of g to the textfile of dir, atomically.
+func writeTextfile(dir string, g prometheus.Gatherer, log *logging.Logger) {
+	path := filepath.Join(dir, textfileName)
+	if err := prometheus.WriteToTextfile(path, withoutRuntime{g}); err != nil {
+		log.Warn("failed to write textfile", "file", path, "err", err)
+	}
+}
@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// churnMetrics count opened and closed connections by protocol (see
+// Options.Churn), the fixed low-cardinality key of the protocol rollups.
+type churnMetrics struct {
+	opened *prometheus.CounterVec
+	closed *prometheus.CounterVec
+}
+
+func newChurnMetrics() *churnMetrics {
+	return &churnMetrics{
+		opened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "connections_opened_total",
+			Help: "Number of connections opened, by L4 and L7 protocol (from NEW events, or new entries between snapshots).",
+		}, []string{"l4protocol", "l7protocol"}),
+		closed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "connections_closed_total",
+			Help: "Number of connections closed, by L4 and L7 protocol (from DESTROY events, or entries gone between snapshots).",
+		}, []string{"l4protocol", "l7protocol"}),
+	}
+}
+
+func (m *churnMetrics) families() []family {
+	return []family{
+		{"connections_opened_total", m.opened},
+		{"connections_closed_total", m.closed},
+	}
+}
+
+// newDurationHistogram returns the histogram of connection lifetimes at close
+// time (see Options.Durations). Unlike the snapshot histograms, it
+// accumulates: every connection is observed once.
+func newDurationHistogram() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "connection_duration_seconds",
+		Help:    "Lifetime of closed connections, by L4 protocol.",
+		Buckets: ageBuckets,
+	}, []string{"l4protocol"})
+}
+
+// connBytesBuckets range from a few packets to bulk transfers (64B to 1GiB).
+var connBytesBuckets = prometheus.ExponentialBuckets(64, 4, 13)
+
+// newConnBytesHistogram returns the histogram of the bytes transferred by
+// closed connections (see Options.ConnBytes).
+func newConnBytesHistogram() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "connection_bytes",
+		Help:    "Bytes transferred (both directions) by closed connections, by L4 and L7 protocol.",
+		Buckets: connBytesBuckets,
+	}, []string{"l4protocol", "l7protocol"})
+}
+
+// seenConn is a connection tracked by churnTracker.
+type seenConn struct {
+	proto protoKey
+	// bytes is the traffic of both directions in the last snapshot.
+	bytes uint64
+	// start is the creation time of the connection: from its timestamp, or
+	// the first snapshot it was seen in; zero when unknown (connections of
+	// the first snapshot without timestamp).
+	start time.Time
+}
+
+// churnTracker finds the connections opened and closed between two
+// snapshots, when events are not available. Connections opened and closed
+// between two snapshots are not seen.
+type churnTracker struct {
+	prev, cur map[connID]seenConn
+	// prevTime is the time of the previous snapshot, zero before the first
+	// one: the connections of the first snapshot are not counted as opened.
+	prevTime time.Time
+}
+
+func newChurnTracker() *churnTracker {
+	return &churnTracker{
+		prev: map[connID]seenConn{},
+		cur:  map[connID]seenConn{},
+	}
+}
+
+// observe records a connection of the pending snapshot, taken at now, with
+// its bytes (both directions).
+func (t *churnTracker) observe(id connID, k protoKey, e conntrack.Entry, bytes uint64, now time.Time) {
+	seen, ok := t.prev[id]
+	switch {
+	case e.HasAge:
+		seen.start = now.Add(-time.Duration(e.Age) * time.Second)
+	case !ok && !t.prevTime.IsZero():
+		seen.start = now
+	}
+	seen.proto = k
+	seen.bytes = bytes
+	t.cur[id] = seen
+}
+
+// commit calls opened and closed for the connections opened and closed since
+// the previous snapshot, and makes the pending snapshot, taken at now, the
+// reference for the next one. closed gets the connection as seen in the
+// previous snapshot, and the time of that snapshot.
+func (t *churnTracker) commit(now time.Time, opened func(seenConn), closed func(seenConn, time.Time)) {
+	if !t.prevTime.IsZero() {
+		for id, seen := range t.cur {
+			if _, ok := t.prev[id]; !ok {
+				opened(seen)
+			}
+		}
+		for id, seen := range t.prev {
+			if _, ok := t.cur[id]; !ok {
+				closed(seen, t.prevTime)
+			}
+		}
+	}
+	t.prevTime = now
+	t.prev = t.cur
+	t.cur = make(map[connID]seenConn, len(t.prev))
+}
+
+// discard drops the pending snapshot (e.g. after a failed read).
+func (t *churnTracker) discard() {
+	t.cur = make(map[connID]seenConn, len(t.prev))
+}
+
+// commitChurn commits the pending snapshot of the churn tracker into the
+// churn, duration and bytes metrics.
+func (c *ConntrackCollector) commitChurn(now time.Time) {
+	c.churn.commit(now, func(seen seenConn) {
+		if c.churnMetrics != nil {
+			c.churnMetrics.opened.WithLabelValues(seen.proto.L4, seen.proto.L7).Inc()
+		}
+	}, func(seen seenConn, last time.Time) {
+		if c.churnMetrics != nil {
+			c.churnMetrics.closed.WithLabelValues(seen.proto.L4, seen.proto.L7).Inc()
+		}
+		// The connection closed after the last snapshot it was seen in:
+		// its lifetime and bytes are rounded down to it.
+		if c.durations != nil && !seen.start.IsZero() {
+			c.durations.WithLabelValues(seen.proto.L4).Observe(max(last.Sub(seen.start).Seconds(), 0))
+		}
+		if c.connBytes != nil {
+			c.connBytes.WithLabelValues(seen.proto.L4, seen.proto.L7).Observe(float64(seen.bytes))
+		}
+	})
+}
+
+// handleChurnEvent counts a NEW or DESTROY event, and observes the lifetime
+// (with a timestamp) and bytes of destroyed connections. The entry of the
+// event passed the filters (see handleEvent).
+func (c *ConntrackCollector) handleChurnEvent(ev conntrack.Event) {
+	k := c.protoKeyOf(ev.Entry)
+	switch ev.Type {
+	case conntrack.EventNew:
+		if c.churnMetrics != nil {
+			c.churnMetrics.opened.WithLabelValues(k.L4, k.L7).Inc()
+		}
+	case conntrack.EventDestroy:
+		if c.churnMetrics != nil {
+			c.churnMetrics.closed.WithLabelValues(k.L4, k.L7).Inc()
+		}
+		if c.durations != nil && ev.Entry.HasAge {
+			c.durations.WithLabelValues(k.L4).Observe(float64(ev.Entry.Age))
+		}
+		if c.connBytes != nil {
+			orig, reply := c.stats(ev.Entry)
+			c.connBytes.WithLabelValues(k.L4, k.L7).Observe(float64(orig.Bytes + reply.Bytes))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// CIDRAggregation truncates src/dst addresses to prefixes before they are
+// used as labels. A zero length keeps the full address.
+type CIDRAggregation struct {
+	Src4, Dst4 int
+	Src6, Dst6 int
+}
+
+// ParseCIDRAggregation parses a comma-separated list of `<side>:/<bits>`
+// items, where side is src or dst (IPv4) or src6 or dst6 (IPv6), e.g.
+// "src:/24,dst:/16,src6:/64".
+func ParseCIDRAggregation(s string) (CIDRAggregation, error) {
+	var a CIDRAggregation
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		side, bitsStr, ok := strings.Cut(item, ":/")
+		if !ok {
+			return a, fmt.Errorf("invalid item %q, want <side>:/<bits>", item)
+		}
+		bits, err := strconv.Atoi(bitsStr)
+		if err != nil {
+			return a, fmt.Errorf("invalid prefix length in %q", item)
+		}
+		var (
+			dst   *int
+			limit int
+		)
+		switch side {
+		case "src":
+			dst, limit = &a.Src4, 32
+		case "dst":
+			dst, limit = &a.Dst4, 32
+		case "src6":
+			dst, limit = &a.Src6, 128
+		case "dst6":
+			dst, limit = &a.Dst6, 128
+		default:
+			return a, fmt.Errorf("unknown side %q in %q, want src, dst, src6 or dst6", side, item)
+		}
+		if bits < 0 || bits > limit {
+			return a, fmt.Errorf("prefix length out of range in %q", item)
+		}
+		*dst = bits
+	}
+	return a, nil
+}
+
+// enabled reports whether any prefix is configured.
+func (a CIDRAggregation) enabled() bool {
+	return a != CIDRAggregation{}
+}
+
+// truncate turns an address into its prefix of the given length, e.g.
+// 10.1.2.3 -> 10.1.2.0/24. Unparsable addresses are kept as is.
+func truncate(addr string, bits4, bits6 int) string {
+	if bits4 == 0 && bits6 == 0 {
+		return addr
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return addr
+	}
+	bits := bits6
+	if ip.Is4() {
+		bits = bits4
+	}
+	if bits == 0 {
+		return addr
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return addr
+	}
+	return p.String()
+}
+
+// addrOf parses an address label value: an address, or a prefix (see
+// CIDRAggregation) standing for its first address.
+func addrOf(label string) (netip.Addr, bool) {
+	if p, err := netip.ParsePrefix(label); err == nil {
+		return p.Addr(), true
+	}
+	ip, err := netip.ParseAddr(label)
+	return ip, err == nil
+}
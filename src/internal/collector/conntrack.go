@@ -1,37 +1,100 @@
 package collector
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"errors"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 
+	"conntrack-exporter/internal/connlabel"
 	"conntrack-exporter/internal/conntrack"
+	"conntrack-exporter/internal/docker"
+	"conntrack-exporter/internal/fwset"
+	"conntrack-exporter/internal/geoip"
+	"conntrack-exporter/internal/kube"
 	"conntrack-exporter/internal/ports"
-	"conntrack-exporter/internal/procfs"
+	"conntrack-exporter/internal/rdns"
+	"conntrack-exporter/internal/relabel"
+	"conntrack-exporter/internal/route"
 )
 
-// ConntrackCollector periodically reads `/proc/net/nf_conntrack` and maintains
-// a cached set of Prometheus metrics.
+// ConntrackCollector periodically reads the conntrack table (from
+// `/proc/net/nf_conntrack` or netlink, see Source) and maintains a cached set
+// of Prometheus metrics.
 //
 // Design notes:
-// - Per-connection metrics are Gauges, because conntrack provides snapshots.
-// - On each refresh we RESET the GaugeVecs, effectively deleting old label pairs.
-// - Total metrics are single Gauges without labels; they are recomputed from
-//   the per-connection snapshot on each refresh.
+//   - Per-connection metrics are Gauges, because conntrack provides snapshots.
+//   - On each refresh we RESET the GaugeVecs, effectively deleting old label pairs.
+//   - Total metrics are single Gauges without labels; they are recomputed from
+//     the per-connection snapshot on each refresh.
 //
-// Label set is fixed for all per-connection metrics:
-//   src, dst, l3protocol, l4protocol, l7protocol, dport
+// With Options.Events set, connections closing between snapshots are accounted
+// from DESTROY events into per-key counters (see events.go).
 //
-// For protocols without ports (icmp, etc) we use:
-//   dport="0", l7protocol="na"
+// With Options.Counters set, per-connection increments between snapshots are
+// accumulated into per-key counters (see counters.go).
+//
+// Default label set for all per-connection metrics:
+//
+//	src, dst, l3protocol, l4protocol, l7protocol, dport
+//
+// Optional labels (see Options) are appended after these.
+//
+// For protocols without ports (icmp, gre, etc) we use:
+//
+//	dport="0", l7protocol="na"
+//
+// The sport label (Options.SPort) follows dport: sport="0" for protocols
+// without ports, sport="ephemeral" for bucketed ephemeral ports.
+//
+// For protocols without state (udp, icmp, etc) we use:
+//
+//	state="na"
+//
+// For non-ICMP protocols with ICMP labels enabled we use:
+//
+//	icmp_type="na", icmp_code="na"
+//
+// Marks are rendered in hex after masking (e.g. mark="0x100").
+//
+// With Options.ReplyTuple, src/dst/dport describe the connection as seen
+// after NAT: src=reply dst, dst=reply src, dport=reply sport.
+//
+// Connlabels are rendered as a comma-separated list of names in bit order
+// (e.g. connlabels="eth0-in,vip"), connlabels="none" when no label is set.
+//
+// Names (src_name/dst_name) are resolved in the background: a new address
+// gets an empty name for the first snapshot(s).
+//
+// GeoIP labels (dst_country/dst_asn) are empty for private and other
+// non-public destinations.
+//
+// Service labels (service/service_namespace) are empty for destinations that
+// are neither a Service IP nor an endpoint of a Service; container labels
+// (src_container/dst_container) for addresses outside of containers.
+//
+// The interface label is the interface of the route to dst, empty when no
+// route matches.
+//
+// The set label lists the firewall sets containing src or dst, comma-separated
+// in configuration order (e.g. set="internal,cdn"), empty when none does.
 type ConntrackCollector struct {
-	procfsFS procfs.FS
-	interval time.Duration
+	source Source
+	opts   Options
+	labels []labelDef
+	// omitted labels are blanked in the key, see Options.Labels.
+	omitted []labelDef
+	// coarse labels are blanked in the key while coarsened is set (see
+	// CoarsenSeries).
+	coarse    []labelDef
+	coarsened atomic.Bool
 
 	// Per-connection snapshot metrics (GaugeVec) - reset on each update.
 	sentPackets  *prometheus.GaugeVec
@@ -39,6 +102,17 @@ type ConntrackCollector struct {
 	replyPackets *prometheus.GaugeVec
 	replyBytes   *prometheus.GaugeVec
 
+	// rules are the runtime-replaceable options (see SetRules).
+	rules atomic.Pointer[Rules]
+
+	// lastFlows is the last applied snapshot (see Flows).
+	lastFlows atomic.Pointer[flowSnapshot]
+
+	// up is 1 when the last refresh succeeded; lastErr is its error.
+	up      prometheus.Gauge
+	mu      sync.Mutex
+	lastErr error
+
 	// Totals (Gauge) - single instance, recomputed from snapshot.
 	totalConnections  prometheus.Gauge
 	totalSentPackets  prometheus.Gauge
@@ -46,17 +120,357 @@ type ConntrackCollector struct {
 	totalReplyPackets prometheus.Gauge
 	totalReplyBytes   prometheus.Gauge
 
-	stopCh chan struct{}
-	doneCh chan struct{}
+	// Per-connection timeout metrics (Options.Timeouts) - nil when disabled.
+	timeoutMin *prometheus.GaugeVec
+	timeoutAvg *prometheus.GaugeVec
+
+	// Low-cardinality breakdowns, recomputed from snapshot.
+	connectionsByState *prometheus.GaugeVec
+	assured            *prometheus.GaugeVec
+	unreplied          *prometheus.GaugeVec
+	offloaded          *prometheus.GaugeVec
+	natConnections     *prometheus.GaugeVec
+	natSentBytes       *prometheus.GaugeVec
+	natReplyBytes      *prometheus.GaugeVec
+	zoneConnections    *prometheus.GaugeVec
+	zoneSentPackets    *prometheus.GaugeVec
+	zoneSentBytes      *prometheus.GaugeVec
+	zoneReplyPackets   *prometheus.GaugeVec
+	zoneReplyBytes     *prometheus.GaugeVec
+	icmpConnections    *prometheus.GaugeVec
+	icmpSentPackets    *prometheus.GaugeVec
+	protoConnections   *prometheus.GaugeVec
+	protoSentBytes     *prometheus.GaugeVec
+	protoReplyBytes    *prometheus.GaugeVec
+	timeoutHistogram   *snapshotHistogram
+	ageHistogram       *snapshotHistogram
+	longestConnection  *prometheus.GaugeVec
+
+	// Event mode only (nil otherwise).
+	eventMetrics *eventMetrics
+
+	// Counter mode only (Options.Counters, nil otherwise).
+	deltas         *deltaTracker
+	counterMetrics *counterMetrics
+
+	// Churn, durations and bytes per connection only (Options.Churn,
+	// Options.Durations, Options.ConnBytes, nil otherwise); churn is nil with
+	// events.
+	churn        *churnTracker
+	churnMetrics *churnMetrics
+	durations    *prometheus.HistogramVec
+	connBytes    *prometheus.HistogramVec
+
+	// Series cap only (Options.MaxSeries or Options.Shrinkable, nil
+	// otherwise).
+	seriesDropped *prometheus.CounterVec
+	prevKeys      map[key]struct{}
+	counterLimit  *seriesLimit
+
+	// seriesBudget caps the keys of snapshots while memory is short (see
+	// ShrinkSeries); zero when not capped.
+	seriesBudget atomic.Int64
+
+	// Series expiry only (Options.SeriesTTL with cumulative counters, nil
+	// otherwise).
+	expiry        *seriesExpiry
+	seriesExpired prometheus.Counter
+
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+	eventsWG sync.WaitGroup
 }
 
-var labelNames = []string{"src", "dst", "l3protocol", "l4protocol", "l7protocol", "dport"}
+// Options configures a ConntrackCollector.
+type Options struct {
+	// Interval between two snapshots of the conntrack table. Zero selects
+	// scrape mode: the table is read on each scrape instead.
+	Interval time.Duration
+
+	// Events enables event accounting when non-nil.
+	Events EventSource
+
+	// Stats accounts for entries skipped by filters (optional).
+	Stats *ParseStats
+	// Health accounts for refreshes (optional).
+	Health *Health
+
+	// Labels selects the labels of the per-connection metrics (see
+	// CheckLabels for valid names); src, dst, l3protocol, l4protocol,
+	// l7protocol and dport when empty. Optional labels enabled by
+	// their own option below are always included. Entries differing only in
+	// left-out labels are aggregated.
+	Labels []string
+
+	// Metrics selects the metric families to export, by name without the
+	// metric prefix: glob patterns (path.Match syntax, e.g. total_*), or
+	// exclusions prefixed with "-" (e.g. -timeout_*). All families when
+	// empty, all but the excluded ones when there are only exclusions.
+	Metrics []string
+
+	// CIDR truncates src/dst addresses to prefixes (full addresses when zero).
+	CIDR CIDRAggregation
+
+	// Ports names destination ports for the l7protocol label (built-in list
+	// only when nil).
+	Ports *ports.Table
+
+	// SPort adds the source port as a `sport` label (see SPortMode; no
+	// label when empty).
+	SPort SPortMode
+
+	// LabelState adds the protocol state as a `state` label.
+	LabelState bool
+
+	// Timeouts exports min/avg remaining entry timeout per aggregated key.
+	Timeouts bool
+
+	// Unprefixed tells that metric names get no prefix (see DefaultPrefix):
+	// the up gauge is then named conntrack_up, as a bare up would clash with
+	// the series Prometheus adds to each target.
+	Unprefixed bool
+
+	// Relabel rules are applied to the labels of each entry, before
+	// unselected labels are dropped (see Options.Labels); entries dropped
+	// by a rule are counted as skipped.
+	Relabel []*relabel.Config
+
+	// MinBytes and MinPackets fold the aggregated keys with fewer bytes or
+	// packets (both directions) into the "other" key of TopN (disabled when
+	// zero).
+	MinBytes, MinPackets uint64
+
+	// TopN keeps the N aggregated keys with the most bytes in per-connection
+	// metrics and folds the others into one key with all labels set to
+	// "other" (no limit when zero).
+	TopN int
+
+	// MaxSeries caps the number of keys of per-connection metrics: keys
+	// above the cap collapse into one key with all labels set to "overflow"
+	// and are counted in conntrack_exporter_series_dropped_total (no cap
+	// when zero).
+	MaxSeries int
+
+	// Shrinkable enables ShrinkSeries, used to stay within a memory budget.
+	Shrinkable bool
+
+	// SeriesTTL deletes the series of the cumulative per-key counters whose
+	// key was not updated for this long: no live connection in the snapshots
+	// for Counters, no DESTROY event for the closed connection counters of
+	// Events (never when zero).
+	SeriesTTL time.Duration
+
+	// Churn exports connections opened/closed counters by protocol, from
+	// events with Events, or else from entries appearing and disappearing
+	// between snapshots.
+	Churn bool
+
+	// Durations exports a histogram of connection lifetimes at close time,
+	// from the age of DESTROY events with Events (timestamped connections
+	// only), or else from the timestamp or first snapshot of connections
+	// disappearing between snapshots.
+	Durations bool
+
+	// ConnBytes exports a histogram of the bytes (both directions) of
+	// connections at close time, from DESTROY events with Events, or else
+	// from the last snapshot of connections disappearing between snapshots.
+	ConnBytes bool
+
+	// Counters exports conntrack_*_total counters accumulated from
+	// per-connection deltas between snapshots.
+	Counters bool
+
+	// LabelMark adds the connection mark, ANDed with MarkMask, as a `mark` label.
+	LabelMark bool
+	MarkMask  uint32
+
+	// LabelZone adds the conntrack zone as a `zone` label.
+	LabelZone bool
+	// Zones restricts collection to the listed zones (all zones when empty).
+	Zones []uint16
+
+	// SrcFilter and DstFilter restrict collection to entries whose src/dst
+	// pass them (see ReplyTuple for which addresses are used).
+	SrcFilter, DstFilter CIDRFilter
+
+	// L4Protocols restricts collection to the listed transport protocols
+	// (all when empty).
+	L4Protocols []string
+	// DPortFilter restricts collection to entries whose destination port
+	// passes it; entries without ports (e.g. icmp) are then skipped.
+	DPortFilter PortFilter
+
+	// LabelNAT adds the detected NAT kind (none|snat|dnat|both) as a `nat` label.
+	LabelNAT bool
+
+	// LabelICMP adds `icmp_type` and `icmp_code` labels (na for non-ICMP).
+	LabelICMP bool
+
+	// ReplyTuple takes src, dst and dport from the (inverted) reply tuple
+	// instead of the original tuple, i.e. the addresses after NAT.
+	ReplyTuple bool
+	// LabelReply adds the reply tuple source/destination as `reply_src` and
+	// `reply_dst` labels.
+	LabelReply bool
+
+	// LabelConnlabels adds the connlabels of the entry, mapped to names with
+	// ConnlabelNames, as a `connlabels` label.
+	LabelConnlabels bool
+	ConnlabelNames  connlabel.Names
+
+	// LabelNetns adds the network namespace of the entry as a `netns` label
+	// (multi-namespace mode, see NetnsSource).
+	LabelNetns bool
+
+	// LabelScope adds the address scope of src and dst (rfc1918, public,
+	// ...) as `src_scope` and `dst_scope` labels.
+	LabelScope bool
+
+	// LabelRDNS adds the names of src and dst, resolved with RDNS, as
+	// `src_name` and `dst_name` labels ("" until resolved, or when src/dst
+	// are prefixes, see CIDR).
+	LabelRDNS bool
+	RDNS      *rdns.Resolver
+
+	// LabelGeoIP adds the country and AS number of public destinations,
+	// looked up in GeoIP, as `dst_country` and `dst_asn` labels.
+	LabelGeoIP bool
+	GeoIP      *geoip.DB
+
+	// LabelService adds the Kubernetes Service of dst, looked up in Services,
+	// as `service` and `service_namespace` labels.
+	LabelService bool
+	Services     *kube.Services
+
+	// LabelContainer adds the names of the containers owning src and dst,
+	// looked up in Containers, as `src_container` and `dst_container` labels.
+	LabelContainer bool
+	Containers     *docker.Containers
+
+	// LabelInterface adds the interface of the route to dst, looked up in
+	// Routes, as an `interface` label.
+	LabelInterface bool
+	Routes         *route.Routes
+
+	// LabelSet adds the firewall sets (ipsets, nftables sets) in Sets
+	// containing src or dst as a `set` label.
+	LabelSet bool
+	Sets     *fwset.Sets
+
+	// ExcludeOffloaded keeps packets/bytes of offloaded entries out of the
+	// traffic metrics: their software counters are frozen while offloaded.
+	ExcludeOffloaded bool
+}
+
+// Rules are the filters, relabeling rules and connlabel names of Options,
+// which SetRules can replace at runtime (e.g. on a configuration reload).
+type Rules struct {
+	SrcFilter, DstFilter CIDRFilter
+	L4Protocols          []string
+	DPortFilter          PortFilter
+	Relabel              []*relabel.Config
+	ConnlabelNames       connlabel.Names
+}
 
 type key struct {
 	Src, Dst string
 	L3, L4   string
 	DPort    string
 	L7       string
+
+	// Optional labels; left empty when disabled so that entries aggregate.
+	SPort string
+	State string
+	Mark  string
+	Zone  string
+	NAT   string
+
+	ICMPType, ICMPCode string
+
+	ReplySrc, ReplyDst string
+
+	Connlabels string
+	Netns      string
+
+	SrcScope, DstScope string
+
+	SrcName, DstName string
+
+	DstCountry, DstASN string
+
+	Service, ServiceNamespace string
+
+	SrcContainer, DstContainer string
+
+	Interface string
+	Set       string
+}
+
+// stateKey is the key of the connections-by-state breakdown.
+type stateKey struct {
+	L4, State string
+}
+
+// protoKey is the key of the protocol rollups.
+type protoKey struct {
+	L4, L7 string
+}
+
+// offloadKey is the key of the offloaded connections breakdown.
+type offloadKey struct {
+	L4, Type string
+}
+
+// snapshot is the result of aggregating one read of the conntrack table.
+type snapshot struct {
+	flows   map[key]aggValues
+	byState map[stateKey]uint64
+
+	// keys is the number of aggregated keys before Options.TopN folding.
+	keys int
+
+	// Per-key increments since the previous snapshot (Options.Counters).
+	deltas map[key]aggValues
+
+	// Flagged entries by L4 protocol.
+	assured   map[string]uint64
+	unreplied map[string]uint64
+	offloaded map[offloadKey]uint64
+
+	// Entries and bytes by NAT kind.
+	nat map[string]aggValues
+
+	// Entries, packets and bytes by conntrack zone.
+	byZone map[uint16]aggValues
+
+	// ICMP entries and sent packets by L4 protocol and ICMP type.
+	icmp map[icmpKey]aggValues
+
+	// Entries and bytes by L4 and L7 protocol, whatever the label set.
+	byProto map[protoKey]aggValues
+
+	// Largest age by L4 protocol (timestamped entries only).
+	longest map[string]uint64
+
+	// time is when the snapshot was taken.
+	time time.Time
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		flows:     map[key]aggValues{},
+		byState:   map[stateKey]uint64{},
+		deltas:    map[key]aggValues{},
+		assured:   map[string]uint64{},
+		unreplied: map[string]uint64{},
+		offloaded: map[offloadKey]uint64{},
+		nat:       map[string]aggValues{},
+		byZone:    map[uint16]aggValues{},
+		icmp:      map[icmpKey]aggValues{},
+		byProto:   map[protoKey]aggValues{},
+		longest:   map[string]uint64{},
+		time:      time.Now(),
+	}
 }
 
 type aggValues struct {
@@ -64,177 +478,704 @@ type aggValues struct {
 	SentBytes    uint64
 	ReplyPackets uint64
 	ReplyBytes   uint64
+
+	// Entries is the number of conntrack entries folded into the key.
+	Entries    uint64
+	TimeoutMin uint64
+	TimeoutSum uint64
 }
 
-func NewConntrackCollector(procfsFS procfs.FS, interval time.Duration) *ConntrackCollector {
+// timeoutBuckets cover the default nf_conntrack timeouts, from UDP (30s)
+// and TIME_WAIT (120s) up to established TCP (5 days).
+var timeoutBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 7200, 21600, 86400, 432000}
+
+// ageBuckets range from short request/response flows to long-lived tunnels.
+var ageBuckets = []float64{1, 10, 30, 60, 300, 900, 3600, 14400, 86400, 604800}
+
+func NewConntrackCollector(source Source, opts Options) *ConntrackCollector {
+	labels, omitted := newLabelSet(opts)
+	enableLabels(&opts, labels)
 	c := &ConntrackCollector{
-		procfsFS: procfsFS,
-		interval: interval,
-		stopCh:   make(chan struct{}),
-		doneCh:   make(chan struct{}),
+		source:  source,
+		opts:    opts,
+		labels:  labels,
+		omitted: omitted,
+		coarse:  coarseLabelSet(labels),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
 	}
+	c.rules.Store(&Rules{
+		SrcFilter:      opts.SrcFilter,
+		DstFilter:      opts.DstFilter,
+		L4Protocols:    opts.L4Protocols,
+		DPortFilter:    opts.DPortFilter,
+		Relabel:        opts.Relabel,
+		ConnlabelNames: opts.ConnlabelNames,
+	})
+	labelNames := labelNamesOf(c.labels)
 
 	c.sentPackets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "conntrack_sent_packets",
+		Name: "sent_packets",
 		Help: "Number of packets sent (original direction) for the aggregated conntrack key.",
 	}, labelNames)
 	c.sentBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "conntrack_sent_bytes",
+		Name: "sent_bytes",
 		Help: "Number of bytes sent (original direction) for the aggregated conntrack key.",
 	}, labelNames)
 	c.replyPackets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "conntrack_reply_packets",
+		Name: "reply_packets",
 		Help: "Number of packets received (reply direction) for the aggregated conntrack key.",
 	}, labelNames)
 	c.replyBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "conntrack_reply_bytes",
+		Name: "reply_bytes",
 		Help: "Number of bytes received (reply direction) for the aggregated conntrack key.",
 	}, labelNames)
 
+	upName := "up"
+	if opts.Unprefixed {
+		upName = DefaultPrefix + "_up"
+	}
+	c.up = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: upName,
+		Help: "Whether the last read of the conntrack table succeeded (1) or failed (0); other metrics keep their last values on failure.",
+	})
 	c.totalConnections = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "conntrack_total_connections",
+		Name: "total_connections",
 		Help: "Total number of aggregated conntrack keys in the last snapshot.",
 	})
 	c.totalSentPackets = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "conntrack_total_sent_packets",
+		Name: "total_sent_packets",
 		Help: "Total sent packets (original direction) aggregated from the last snapshot.",
 	})
 	c.totalSentBytes = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "conntrack_total_sent_bytes",
+		Name: "total_sent_bytes",
 		Help: "Total sent bytes (original direction) aggregated from the last snapshot.",
 	})
 	c.totalReplyPackets = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "conntrack_total_reply_packets",
+		Name: "total_reply_packets",
 		Help: "Total reply packets (reply direction) aggregated from the last snapshot.",
 	})
 	c.totalReplyBytes = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "conntrack_total_reply_bytes",
+		Name: "total_reply_bytes",
 		Help: "Total reply bytes (reply direction) aggregated from the last snapshot.",
 	})
 
+	c.connectionsByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "connections_by_state",
+		Help: "Number of conntrack entries by L4 protocol and protocol state in the last snapshot.",
+	}, []string{"l4protocol", "state"})
+	c.assured = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "connections_assured",
+		Help: "Number of conntrack entries marked [ASSURED] in the last snapshot, by L4 protocol.",
+	}, []string{"l4protocol"})
+	c.unreplied = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "connections_unreplied",
+		Help: "Number of conntrack entries marked [UNREPLIED] in the last snapshot, by L4 protocol.",
+	}, []string{"l4protocol"})
+	c.offloaded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "connections_offloaded",
+		Help: "Number of conntrack entries offloaded to a flowtable in the last snapshot, by L4 protocol and offload type (software, hardware).",
+	}, []string{"l4protocol", "type"})
+	c.natConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nat_connections",
+		Help: "Number of conntrack entries in the last snapshot, by detected NAT kind (none, snat, dnat, both).",
+	}, []string{"nat"})
+	c.natSentBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nat_sent_bytes",
+		Help: "Bytes sent (original direction) in the last snapshot, by detected NAT kind.",
+	}, []string{"nat"})
+	c.natReplyBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nat_reply_bytes",
+		Help: "Bytes received (reply direction) in the last snapshot, by detected NAT kind.",
+	}, []string{"nat"})
+	c.zoneConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zone_connections",
+		Help: "Number of conntrack entries in the last snapshot, by conntrack zone.",
+	}, []string{"zone"})
+	c.zoneSentPackets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zone_sent_packets",
+		Help: "Packets sent (original direction) in the last snapshot, by conntrack zone.",
+	}, []string{"zone"})
+	c.zoneSentBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zone_sent_bytes",
+		Help: "Bytes sent (original direction) in the last snapshot, by conntrack zone.",
+	}, []string{"zone"})
+	c.zoneReplyPackets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zone_reply_packets",
+		Help: "Packets received (reply direction) in the last snapshot, by conntrack zone.",
+	}, []string{"zone"})
+	c.zoneReplyBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zone_reply_bytes",
+		Help: "Bytes received (reply direction) in the last snapshot, by conntrack zone.",
+	}, []string{"zone"})
+	c.icmpConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "icmp_connections",
+		Help: "Number of ICMP and ICMPv6 conntrack entries in the last snapshot, by L4 protocol and ICMP type (echo, dest-unreachable, ...).",
+	}, []string{"l4protocol", "type"})
+	c.icmpSentPackets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "icmp_sent_packets",
+		Help: "Packets sent (original direction) by ICMP and ICMPv6 conntrack entries in the last snapshot, by L4 protocol and ICMP type.",
+	}, []string{"l4protocol", "type"})
+	c.protoConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "connections_total_by_proto",
+		Help: "Number of conntrack entries in the last snapshot, by L4 protocol.",
+	}, []string{"l4protocol"})
+	c.protoSentBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sent_bytes_by_proto",
+		Help: "Bytes sent (original direction) in the last snapshot, by L4 and L7 protocol.",
+	}, []string{"l4protocol", "l7protocol"})
+	c.protoReplyBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "reply_bytes_by_proto",
+		Help: "Bytes received (reply direction) in the last snapshot, by L4 and L7 protocol.",
+	}, []string{"l4protocol", "l7protocol"})
+	c.timeoutHistogram = newSnapshotHistogram(
+		"timeout_seconds",
+		"Distribution of remaining conntrack entry timeouts in the last snapshot, by L4 protocol.",
+		[]string{"l4protocol"},
+		timeoutBuckets,
+	)
+
+	c.ageHistogram = newSnapshotHistogram(
+		"connection_age_seconds",
+		"Distribution of conntrack entry ages in the last snapshot, by L4 protocol (requires nf_conntrack_timestamp).",
+		[]string{"l4protocol"},
+		ageBuckets,
+	)
+	c.longestConnection = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "longest_connection_seconds",
+		Help: "Age of the oldest conntrack entry in the last snapshot, by L4 protocol (requires nf_conntrack_timestamp).",
+	}, []string{"l4protocol"})
+
+	if opts.Timeouts {
+		c.timeoutMin = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "timeout_min_seconds",
+			Help: "Smallest remaining timeout among the entries of the aggregated conntrack key.",
+		}, labelNames)
+		c.timeoutAvg = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "timeout_avg_seconds",
+			Help: "Average remaining timeout of the entries of the aggregated conntrack key.",
+		}, labelNames)
+	}
+
+	if opts.Events != nil {
+		c.eventMetrics = newEventMetrics(labelNames)
+	}
+
+	if opts.Counters {
+		c.deltas = newDeltaTracker()
+		c.counterMetrics = newCounterMetrics(labelNames)
+	}
+
+	if opts.Churn {
+		c.churnMetrics = newChurnMetrics()
+	}
+	if opts.Durations {
+		c.durations = newDurationHistogram()
+	}
+	if opts.ConnBytes {
+		c.connBytes = newConnBytesHistogram()
+	}
+	if (opts.Churn || opts.Durations || opts.ConnBytes) && opts.Events == nil {
+		c.churn = newChurnTracker()
+	}
+
+	if opts.MaxSeries > 0 || opts.Shrinkable {
+		c.seriesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "exporter_series_dropped_total",
+			Help: "Number of aggregated keys collapsed into the overflow key by the series cap, by kind (snapshot: per snapshot, counter: new keys of cumulative counters, memory: per snapshot while memory is short).",
+		}, []string{"kind"})
+		c.prevKeys = map[key]struct{}{}
+	}
+	if opts.Shrinkable {
+		c.seriesDropped.WithLabelValues("memory")
+	}
+	if opts.MaxSeries > 0 {
+		c.seriesDropped.WithLabelValues("snapshot")
+		c.counterLimit = &seriesLimit{
+			max:      opts.MaxSeries,
+			overflow: c.keyWith(overflowValue),
+			dropped:  c.seriesDropped.WithLabelValues("counter"),
+			seen:     map[key]struct{}{},
+		}
+	}
+
+	if opts.SeriesTTL > 0 && (opts.Counters || opts.Events != nil) {
+		c.expiry = newSeriesExpiry(opts.SeriesTTL)
+		c.seriesExpired = prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_series_expired_total",
+			Help: "Number of aggregated keys whose cumulative counter series were deleted after the series TTL.",
+		})
+	}
+
 	return c
 }
 
 // MustRegister registers all metrics into the provided registry.
 func (c *ConntrackCollector) MustRegister(reg prometheus.Registerer) {
-	reg.MustRegister(
-		c.sentPackets,
-		c.sentBytes,
-		c.replyPackets,
-		c.replyBytes,
-		c.totalConnections,
-		c.totalSentPackets,
-		c.totalSentBytes,
-		c.totalReplyPackets,
-		c.totalReplyBytes,
-	)
+	fs := []family{
+		{"up", c.up},
+		{"sent_packets", c.sentPackets},
+		{"sent_bytes", c.sentBytes},
+		{"reply_packets", c.replyPackets},
+		{"reply_bytes", c.replyBytes},
+		{"total_connections", c.totalConnections},
+		{"total_sent_packets", c.totalSentPackets},
+		{"total_sent_bytes", c.totalSentBytes},
+		{"total_reply_packets", c.totalReplyPackets},
+		{"total_reply_bytes", c.totalReplyBytes},
+		{"connections_by_state", c.connectionsByState},
+		{"connections_assured", c.assured},
+		{"connections_unreplied", c.unreplied},
+		{"connections_offloaded", c.offloaded},
+		{"nat_connections", c.natConnections},
+		{"nat_sent_bytes", c.natSentBytes},
+		{"nat_reply_bytes", c.natReplyBytes},
+		{"zone_connections", c.zoneConnections},
+		{"zone_sent_packets", c.zoneSentPackets},
+		{"zone_sent_bytes", c.zoneSentBytes},
+		{"zone_reply_packets", c.zoneReplyPackets},
+		{"zone_reply_bytes", c.zoneReplyBytes},
+		{"icmp_connections", c.icmpConnections},
+		{"icmp_sent_packets", c.icmpSentPackets},
+		{"connections_total_by_proto", c.protoConnections},
+		{"sent_bytes_by_proto", c.protoSentBytes},
+		{"reply_bytes_by_proto", c.protoReplyBytes},
+		{"timeout_seconds", c.timeoutHistogram},
+		{"connection_age_seconds", c.ageHistogram},
+		{"longest_connection_seconds", c.longestConnection},
+	}
+	if c.timeoutMin != nil {
+		fs = append(fs, family{"timeout_min_seconds", c.timeoutMin}, family{"timeout_avg_seconds", c.timeoutAvg})
+	}
+	if c.eventMetrics != nil {
+		fs = append(fs, c.eventMetrics.families()...)
+	}
+	if c.counterMetrics != nil {
+		fs = append(fs, c.counterMetrics.families()...)
+	}
+	if c.churnMetrics != nil {
+		fs = append(fs, c.churnMetrics.families()...)
+	}
+	if c.durations != nil {
+		fs = append(fs, family{"connection_duration_seconds", c.durations})
+	}
+	if c.connBytes != nil {
+		fs = append(fs, family{"connection_bytes", c.connBytes})
+	}
+	if c.seriesDropped != nil {
+		fs = append(fs, family{"exporter_series_dropped_total", c.seriesDropped})
+	}
+	if c.seriesExpired != nil {
+		fs = append(fs, family{"exporter_series_expired_total", c.seriesExpired})
+	}
+	cs := selectFamilies(c.opts.Metrics, fs)
+	register(reg, c.opts.Interval, func(ctx context.Context) { _ = c.update(ctx) }, cs...)
 }
 
 // Start begins periodic collection in a background goroutine.
-// It performs an initial update immediately.
+// It performs an initial update immediately (none in scrape mode, see
+// Options.Interval).
 func (c *ConntrackCollector) Start(ctx context.Context) {
-	go func() {
-		defer close(c.doneCh)
-
-		// Initial update.
-		_ = c.UpdateOnce(ctx)
+	ctx, cancel := context.WithCancel(ctx)
 
-		t := time.NewTicker(c.interval)
-		defer t.Stop()
+	if c.opts.Events != nil {
+		c.eventsWG.Add(1)
+		go func() {
+			defer c.eventsWG.Done()
+			c.runEvents(ctx)
+		}()
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-c.stopCh:
-				return
-			case <-t.C:
-				_ = c.UpdateOnce(ctx)
-			}
-		}
+	go func() {
+		defer close(c.doneCh)
+		defer cancel()
+		runPeriodic(ctx, c.opts.Interval, c.stopCh, func(ctx context.Context) { _ = c.update(ctx) })
 	}()
 }
 
 func (c *ConntrackCollector) Stop() {
 	close(c.stopCh)
 	<-c.doneCh
+	c.eventsWG.Wait()
 }
 
-// UpdateOnce reads conntrack file and updates metrics.
-func (c *ConntrackCollector) UpdateOnce(ctx context.Context) error {
-	_ = ctx // reserved for future (e.g. timeouts around file reads)
-
-	raw, err := c.procfsFS.ReadFile("net/nf_conntrack")
+// update is a refresh of the background loop, accounted in Options.Health
+// and the up metric.
+func (c *ConntrackCollector) update(ctx context.Context) error {
+	err := c.opts.Health.collect(ctx, "conntrack", c.UpdateOnce)
 	if err != nil {
-		return err
+		c.up.Set(0)
+	} else {
+		c.up.Set(1)
 	}
+	c.mu.Lock()
+	c.lastErr = err
+	c.mu.Unlock()
+	return err
+}
+
+// Err returns the error of the last background refresh, nil when it
+// succeeded.
+func (c *ConntrackCollector) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// SetRules replaces the filters, relabeling rules and connlabel names, from
+// the next entry on. The series of keys that are no longer collected go
+// away with the next snapshot (cumulative counters: see Options.SeriesTTL).
+func (c *ConntrackCollector) SetRules(r Rules) {
+	c.rules.Store(&r)
+}
 
-	snapshot, err := parseAndAggregate(raw)
+// UpdateOnce reads the conntrack table and updates metrics.
+func (c *ConntrackCollector) UpdateOnce(ctx context.Context) error {
+	snap := newSnapshot()
+	parsed := 0
+	readCtx, span := tracer.Start(ctx, "read")
+	err := c.source.Entries(readCtx, func(e conntrack.Entry) {
+		parsed++
+		r := c.rules.Load()
+		if c.accept(e, r) {
+			c.aggregate(snap, e, r)
+		}
+	})
+	span.SetAttributes(attribute.Int("entries", parsed), attribute.Int("keys", len(snap.flows)))
+	span.End()
 	if err != nil {
+		c.timeoutHistogram.discard()
+		c.ageHistogram.discard()
+		if c.deltas != nil {
+			c.deltas.discard()
+		}
+		if c.churn != nil {
+			c.churn.discard()
+		}
 		return err
 	}
 
-	c.applySnapshot(snapshot)
+	_, span = tracer.Start(ctx, "apply")
+	defer span.End()
+	c.opts.Health.parsed(parsed)
+	snap.keys = len(snap.flows)
+	if c.opts.MinBytes > 0 || c.opts.MinPackets > 0 {
+		c.foldSmall(snap, c.opts.MinBytes, c.opts.MinPackets)
+	}
+	if c.opts.TopN > 0 {
+		c.foldTopN(snap, c.opts.TopN)
+	}
+	limit, kind := c.opts.MaxSeries, "snapshot"
+	if budget := int(c.seriesBudget.Load()); budget > 0 && (limit == 0 || budget < limit) {
+		limit, kind = budget, "memory"
+	}
+	if limit > 0 {
+		c.capSnapshot(snap, limit, kind)
+	}
+	c.applySnapshot(snap)
 	return nil
 }
 
-func parseAndAggregate(raw []byte) (map[key]aggValues, error) {
-	out := map[key]aggValues{}
+// accept reports whether an entry passes the configured filters and those
+// of r.
+func (c *ConntrackCollector) accept(e conntrack.Entry, r *Rules) bool {
+	if len(c.opts.Zones) > 0 && !slices.Contains(c.opts.Zones, e.Zone) {
+		c.opts.Stats.skipped()
+		return false
+	}
+	if len(r.L4Protocols) > 0 && !slices.Contains(r.L4Protocols, e.L4Proto) {
+		c.opts.Stats.skipped()
+		return false
+	}
+	if r.SrcFilter.enabled() || r.DstFilter.enabled() || r.DPortFilter.enabled() {
+		src, dst, dport := c.endpoints(e)
+		if !r.SrcFilter.match(src) || !r.DstFilter.match(dst) || !matchDPort(r.DPortFilter, e, dport) {
+			c.opts.Stats.skipped()
+			return false
+		}
+	}
+	return true
+}
 
-	sc := bufio.NewScanner(bytes.NewReader(raw))
-	// conntrack lines are typically below 4K, but let's be safe.
-	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+// matchDPort applies a destination port filter; entries without ports
+// (e.g. icmp) only pass when it includes no port, e.g. "!53".
+func matchDPort(f PortFilter, e conntrack.Entry, dport string) bool {
+	if !f.enabled() {
+		return true
+	}
+	p, err := strconv.ParseUint(dport, 10, 16)
+	if err != nil || !e.HasPorts() {
+		return len(f.include) == 0
+	}
+	return f.match(uint16(p))
+}
 
-	var any bool
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if line == "" {
-			continue
-		}
+// endpoints returns the src, dst and dport of an entry, from the original
+// or the reply tuple (Options.ReplyTuple).
+func (c *ConntrackCollector) endpoints(e conntrack.Entry) (src, dst, dport string) {
+	if c.opts.ReplyTuple && e.Reply.SrcIP != "" {
+		// The reply tuple goes from the responder back to the initiator:
+		// invert it to keep src as the initiator side.
+		return e.Reply.DstIP, e.Reply.SrcIP, e.Reply.Sport
+	}
+	return e.Original.SrcIP, e.Original.DstIP, e.Original.Dport
+}
 
-		e, ok := conntrack.ParseLine(line)
-		if !ok {
-			continue
-		}
-		any = true
+// aggregate adds a single entry to the snapshot under its aggregation key.
+func (c *ConntrackCollector) aggregate(snap *snapshot, e conntrack.Entry, r *Rules) {
+	k, ok := c.keyOf(e, r)
+	if !ok {
+		c.opts.Stats.skipped()
+		return
+	}
+	orig, reply := c.stats(e)
+
+	v := snap.flows[k]
+	v.SentPackets += orig.Packets
+	v.SentBytes += orig.Bytes
+	v.ReplyPackets += reply.Packets
+	v.ReplyBytes += reply.Bytes
+	if v.Entries == 0 || e.Timeout < v.TimeoutMin {
+		v.TimeoutMin = e.Timeout
+	}
+	v.TimeoutSum += e.Timeout
+	v.Entries++
+	snap.flows[k] = v
+
+	if c.deltas != nil {
+		c.aggregateDelta(snap, k, e)
+	}
+
+	snap.byState[stateKey{L4: e.L4Proto, State: stateValue(e)}]++
+	if e.Assured {
+		snap.assured[e.L4Proto]++
+	}
+	if e.Unreplied {
+		snap.unreplied[e.L4Proto]++
+	}
+	switch {
+	case e.HWOffload:
+		snap.offloaded[offloadKey{L4: e.L4Proto, Type: "hardware"}]++
+	case e.Offload:
+		snap.offloaded[offloadKey{L4: e.L4Proto, Type: "software"}]++
+	}
+
+	nat := snap.nat[e.NAT()]
+	nat.Entries++
+	nat.SentBytes += orig.Bytes
+	nat.ReplyBytes += reply.Bytes
+	snap.nat[e.NAT()] = nat
 
-		dport := e.Original.Dport
-		l7 := ports.L7ProtocolFromDPort(dport)
+	zone := snap.byZone[e.Zone]
+	zone.Entries++
+	zone.SentPackets += orig.Packets
+	zone.SentBytes += orig.Bytes
+	zone.ReplyPackets += reply.Packets
+	zone.ReplyBytes += reply.Bytes
+	snap.byZone[e.Zone] = zone
+
+	if e.IsICMP() {
+		ik := icmpKey{L4: e.L4Proto, Type: icmpTypeName(e)}
+		icmp := snap.icmp[ik]
+		icmp.Entries++
+		icmp.SentPackets += orig.Packets
+		snap.icmp[ik] = icmp
+	}
+
+	pk := c.protoKeyOf(e)
+	proto := snap.byProto[pk]
+	proto.Entries++
+	proto.SentBytes += orig.Bytes
+	proto.ReplyBytes += reply.Bytes
+	snap.byProto[pk] = proto
+	if c.churn != nil {
+		c.churn.observe(connIDOf(e), pk, e, orig.Bytes+reply.Bytes, snap.time)
+	}
+	c.timeoutHistogram.observe(float64(e.Timeout), e.L4Proto)
 
-		// Protocols without ports: use explicit values as agreed.
-		if !e.HasPorts() {
-			dport = "0"
-			l7 = "na"
+	if e.HasAge {
+		c.ageHistogram.observe(float64(e.Age), e.L4Proto)
+		if e.Age >= snap.longest[e.L4Proto] {
+			snap.longest[e.L4Proto] = e.Age
 		}
+	}
+}
+
+// aggregateDelta adds the increment of a single connection since the
+// previous snapshot to the per-key deltas.
+func (c *ConntrackCollector) aggregateDelta(snap *snapshot, k key, e conntrack.Entry) {
+	id := connIDOf(e)
+	if c.opts.ExcludeOffloaded && e.Offloaded() {
+		// Frozen counters: account the traffic once the entry is back in
+		// software, from the last counters seen before the offload.
+		c.deltas.carry(id)
+		return
+	}
+
+	orig, reply := c.deltas.observe(id, e.OriginalStats, e.ReplyStats)
+	d := snap.deltas[k]
+	d.SentPackets += orig.Packets
+	d.SentBytes += orig.Bytes
+	d.ReplyPackets += reply.Packets
+	d.ReplyBytes += reply.Bytes
+	snap.deltas[k] = d
+}
+
+// stats returns the counters of an entry as they should be accounted.
+func (c *ConntrackCollector) stats(e conntrack.Entry) (orig, reply conntrack.DirectionStats) {
+	if c.opts.ExcludeOffloaded && e.Offloaded() {
+		return conntrack.DirectionStats{}, conntrack.DirectionStats{}
+	}
+	return e.OriginalStats, e.ReplyStats
+}
+
+// keyOf returns the aggregation key of an entry, and false when the entry
+// is dropped by the relabeling rules of r.
+func (c *ConntrackCollector) keyOf(e conntrack.Entry, r *Rules) (key, bool) {
+	src, dst, dport := c.endpoints(e)
+	l7 := c.l7Of(e, dport)
 
-		k := key{
-			Src:  e.Original.SrcIP,
-			Dst:  e.Original.DstIP,
-			L3:   e.L3Proto,
-			L4:   e.L4Proto,
-			DPort: dport,
-			L7:   l7,
+	// Protocols without ports: use explicit values as agreed.
+	if !e.HasPorts() {
+		dport = "0"
+	}
+
+	if a := c.opts.CIDR; a.enabled() {
+		src = truncate(src, a.Src4, a.Src6)
+		dst = truncate(dst, a.Dst4, a.Dst6)
+	}
+
+	k := key{
+		Src:   src,
+		Dst:   dst,
+		L3:    e.L3Proto,
+		L4:    e.L4Proto,
+		DPort: dport,
+		L7:    l7,
+	}
+	if c.opts.SPort.enabled() {
+		k.SPort = c.sport(e)
+	}
+	if c.opts.LabelState {
+		k.State = stateValue(e)
+	}
+	if c.opts.LabelMark {
+		k.Mark = "0x" + strconv.FormatUint(uint64(e.Mark&c.opts.MarkMask), 16)
+	}
+	if c.opts.LabelZone {
+		k.Zone = strconv.FormatUint(uint64(e.Zone), 10)
+	}
+	if c.opts.LabelNAT {
+		k.NAT = e.NAT()
+	}
+	if c.opts.LabelICMP {
+		k.ICMPType, k.ICMPCode = "na", "na"
+		if e.IsICMP() {
+			k.ICMPType, k.ICMPCode = e.Original.Type, e.Original.Code
 		}
+	}
+	if c.opts.LabelReply {
+		k.ReplySrc, k.ReplyDst = e.Reply.SrcIP, e.Reply.DstIP
+	}
+	if c.opts.LabelConnlabels {
+		k.Connlabels = r.ConnlabelNames.Format(e.LabelBits())
+	}
+	if c.opts.LabelNetns {
+		k.Netns = e.Netns
+	}
+	if c.opts.LabelScope {
+		k.SrcScope, k.DstScope = scopeOf(k.Src), scopeOf(k.Dst)
+	}
+	if c.opts.LabelRDNS && c.opts.RDNS != nil {
+		k.SrcName, k.DstName = c.nameOf(k.Src), c.nameOf(k.Dst)
+	}
+	if c.opts.LabelGeoIP && c.opts.GeoIP != nil {
+		k.DstCountry, k.DstASN = c.geoOf(k.Dst)
+	}
+	if c.opts.LabelService && c.opts.Services != nil {
+		if svc, ok := c.opts.Services.Lookup(k.Dst); ok {
+			k.Service, k.ServiceNamespace = svc.Name, svc.Namespace
+		}
+	}
+	if c.opts.LabelContainer && c.opts.Containers != nil {
+		k.SrcContainer, _ = c.opts.Containers.Lookup(k.Src)
+		k.DstContainer, _ = c.opts.Containers.Lookup(k.Dst)
+	}
+	if c.opts.LabelInterface && c.opts.Routes != nil {
+		if ip, ok := addrOf(k.Dst); ok {
+			k.Interface, _ = c.opts.Routes.Lookup(ip)
+		}
+	}
+	if c.opts.LabelSet && c.opts.Sets != nil {
+		k.Set = c.setsOf(k.Src, k.Dst)
+	}
+	if len(r.Relabel) > 0 && !relabel.Process(r.Relabel, keyLabels{&k}) {
+		return k, false
+	}
+	blankLabels(c.omitted, &k)
+	if c.coarsened.Load() {
+		blankLabels(c.coarse, &k)
+	}
+	return k, true
+}
+
+// protoKeyOf returns the protocols of an entry for the rollups. They do not
+// depend on the (possibly relabeled or left out) labels of its key.
+func (c *ConntrackCollector) protoKeyOf(e conntrack.Entry) protoKey {
+	_, _, dport := c.endpoints(e)
+	return protoKey{L4: e.L4Proto, L7: c.l7Of(e, dport)}
+}
 
-		v := out[k]
-		v.SentPackets += e.OriginalStats.Packets
-		v.SentBytes += e.OriginalStats.Bytes
-		v.ReplyPackets += e.ReplyStats.Packets
-		v.ReplyBytes += e.ReplyStats.Bytes
-		out[k] = v
+// l7Of returns the l7protocol label value of an entry with the given
+// destination port.
+func (c *ConntrackCollector) l7Of(e conntrack.Entry, dport string) string {
+	if !e.HasPorts() {
+		return "na"
 	}
+	return c.opts.Ports.L7Protocol(e.L4Proto, dport)
+}
 
-	if err := sc.Err(); err != nil {
-		return nil, err
+// setsOf returns the set label value of address label values.
+func (c *ConntrackCollector) setsOf(labels ...string) string {
+	var addrs []netip.Addr
+	for _, l := range labels {
+		if ip, ok := addrOf(l); ok {
+			addrs = append(addrs, ip)
+		}
 	}
-	if !any {
-		return nil, errors.New("no conntrack entries parsed from nf_conntrack")
+	return strings.Join(c.opts.Sets.Lookup(addrs...), ",")
+}
+
+// nameOf returns the resolved name of an address label value.
+func (c *ConntrackCollector) nameOf(addr string) string {
+	if strings.Contains(addr, "/") {
+		// A prefix (Options.CIDR).
+		return ""
+	}
+	return c.opts.RDNS.Name(addr)
+}
+
+// geoOf returns the country and AS number of an address label value. A
+// prefix (Options.CIDR) is looked up by its first address.
+func (c *ConntrackCollector) geoOf(addr string) (country, asn string) {
+	ip, ok := addrOf(addr)
+	if !ok {
+		return "", ""
 	}
+	return c.opts.GeoIP.Lookup(ip)
+}
 
-	return out, nil
+// stateValue returns the state label value of an entry.
+func stateValue(e conntrack.Entry) string {
+	if e.State == "" {
+		return "na"
+	}
+	return e.State
 }
 
-func (c *ConntrackCollector) applySnapshot(cur map[key]aggValues) {
+func (c *ConntrackCollector) applySnapshot(snap *snapshot) {
+	cur := snap.flows
+
 	// Reset per-connection metrics (delete previous label pairs).
 	c.sentPackets.Reset()
 	c.sentBytes.Reset()
@@ -243,13 +1184,37 @@ func (c *ConntrackCollector) applySnapshot(cur map[key]aggValues) {
 
 	// Update per-connection gauges.
 	for k, v := range cur {
-		labels := labelValues(k)
+		labels := c.labelValues(k)
 		c.sentPackets.WithLabelValues(labels...).Set(float64(v.SentPackets))
 		c.sentBytes.WithLabelValues(labels...).Set(float64(v.SentBytes))
 		c.replyPackets.WithLabelValues(labels...).Set(float64(v.ReplyPackets))
 		c.replyBytes.WithLabelValues(labels...).Set(float64(v.ReplyBytes))
 	}
 
+	if c.counterMetrics != nil {
+		for k, d := range snap.deltas {
+			k = c.counterKey(k)
+			if c.expiry != nil {
+				c.expiry.touch(k, snap.time)
+			}
+			c.counterMetrics.add(c.labelValues(k), d)
+		}
+		c.deltas.commit()
+	}
+	if c.expiry != nil {
+		c.expireSeries(snap.time)
+	}
+
+	if c.timeoutMin != nil {
+		c.timeoutMin.Reset()
+		c.timeoutAvg.Reset()
+		for k, v := range cur {
+			labels := c.labelValues(k)
+			c.timeoutMin.WithLabelValues(labels...).Set(float64(v.TimeoutMin))
+			c.timeoutAvg.WithLabelValues(labels...).Set(float64(v.TimeoutSum) / float64(v.Entries))
+		}
+	}
+
 	// Totals are aggregated from the same snapshot, without labels.
 	var totalSentPackets uint64
 	var totalSentBytes uint64
@@ -262,14 +1227,83 @@ func (c *ConntrackCollector) applySnapshot(cur map[key]aggValues) {
 		totalReplyBytes += v.ReplyBytes
 	}
 
-	c.totalConnections.Set(float64(len(cur)))
+	c.totalConnections.Set(float64(snap.keys))
 	c.totalSentPackets.Set(float64(totalSentPackets))
 	c.totalSentBytes.Set(float64(totalSentBytes))
 	c.totalReplyPackets.Set(float64(totalReplyPackets))
 	c.totalReplyBytes.Set(float64(totalReplyBytes))
+
+	c.connectionsByState.Reset()
+	for k, n := range snap.byState {
+		c.connectionsByState.WithLabelValues(k.L4, k.State).Set(float64(n))
+	}
+	setByL4(c.assured, snap.assured)
+	setByL4(c.unreplied, snap.unreplied)
+
+	c.offloaded.Reset()
+	for k, n := range snap.offloaded {
+		c.offloaded.WithLabelValues(k.L4, k.Type).Set(float64(n))
+	}
+
+	c.natConnections.Reset()
+	c.natSentBytes.Reset()
+	c.natReplyBytes.Reset()
+	for nat, v := range snap.nat {
+		c.natConnections.WithLabelValues(nat).Set(float64(v.Entries))
+		c.natSentBytes.WithLabelValues(nat).Set(float64(v.SentBytes))
+		c.natReplyBytes.WithLabelValues(nat).Set(float64(v.ReplyBytes))
+	}
+
+	c.zoneConnections.Reset()
+	c.zoneSentPackets.Reset()
+	c.zoneSentBytes.Reset()
+	c.zoneReplyPackets.Reset()
+	c.zoneReplyBytes.Reset()
+	for z, v := range snap.byZone {
+		zone := strconv.FormatUint(uint64(z), 10)
+		c.zoneConnections.WithLabelValues(zone).Set(float64(v.Entries))
+		c.zoneSentPackets.WithLabelValues(zone).Set(float64(v.SentPackets))
+		c.zoneSentBytes.WithLabelValues(zone).Set(float64(v.SentBytes))
+		c.zoneReplyPackets.WithLabelValues(zone).Set(float64(v.ReplyPackets))
+		c.zoneReplyBytes.WithLabelValues(zone).Set(float64(v.ReplyBytes))
+	}
+
+	c.icmpConnections.Reset()
+	c.icmpSentPackets.Reset()
+	for k, v := range snap.icmp {
+		c.icmpConnections.WithLabelValues(k.L4, k.Type).Set(float64(v.Entries))
+		c.icmpSentPackets.WithLabelValues(k.L4, k.Type).Set(float64(v.SentPackets))
+	}
+
+	c.protoConnections.Reset()
+	c.protoSentBytes.Reset()
+	c.protoReplyBytes.Reset()
+	byL4 := map[string]uint64{}
+	for k, v := range snap.byProto {
+		byL4[k.L4] += v.Entries
+		c.protoSentBytes.WithLabelValues(k.L4, k.L7).Set(float64(v.SentBytes))
+		c.protoReplyBytes.WithLabelValues(k.L4, k.L7).Set(float64(v.ReplyBytes))
+	}
+	setByL4(c.protoConnections, byL4)
+	if c.churn != nil {
+		c.commitChurn(snap.time)
+	}
+
+	setByL4(c.longestConnection, snap.longest)
+	c.timeoutHistogram.commit()
+	c.ageHistogram.commit()
+
+	c.lastFlows.Store(&flowSnapshot{time: snap.time, flows: cur})
 }
 
-func labelValues(k key) []string {
-	return []string{k.Src, k.Dst, k.L3, k.L4, k.L7, k.DPort}
+// setByL4 replaces the content of a GaugeVec labeled by l4protocol.
+func setByL4(g *prometheus.GaugeVec, counts map[string]uint64) {
+	g.Reset()
+	for l4, n := range counts {
+		g.WithLabelValues(l4).Set(float64(n))
+	}
 }
 
+func (c *ConntrackCollector) labelValues(k key) []string {
+	return labelValuesOf(c.labels, k)
+}
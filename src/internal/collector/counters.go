@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// connID identifies a single connection across snapshots: the kernel
+// conntrack id (netlink backend only, 0 otherwise) plus the original tuple.
+// The tuple alone is enough for procfs, where ids are not printed; with ids,
+// a reused tuple is still told apart from its predecessor.
+type connID struct {
+	ID       uint32
+	Netns    string
+	Zone     uint16
+	L3, L4   string
+	Src, Dst string
+	// Ports, or ICMP type/code/id, or GRE keys, depending on the protocol.
+	A, B, C string
+}
+
+func connIDOf(e conntrack.Entry) connID {
+	id := connID{
+		ID:    e.ID,
+		Netns: e.Netns,
+		Zone:  e.Zone,
+		L3:    e.L3Proto,
+		L4:    e.L4Proto,
+		Src:   e.Original.SrcIP,
+		Dst:   e.Original.DstIP,
+		A:     e.Original.Sport,
+		B:     e.Original.Dport,
+	}
+	switch {
+	case e.IsICMP():
+		id.A, id.B, id.C = e.Original.Type, e.Original.Code, e.Original.ID
+	case e.L4Proto == "gre":
+		id.A, id.B = e.Original.SrcKey, e.Original.DstKey
+	}
+	return id
+}
+
+// connCounters are the counters of a connection seen in the last snapshot.
+type connCounters struct {
+	orig, reply conntrack.DirectionStats
+}
+
+// deltaTracker turns the absolute per-connection counters of consecutive
+// snapshots into per-connection increments.
+//
+// Deltas are computed per connection before they are rolled up into the
+// aggregated key: comparing aggregated sums instead would under/over-count
+// whenever one connection of a key closes (or its counters restart) while
+// another one grows.
+type deltaTracker struct {
+	prev map[connID]connCounters
+	cur  map[connID]connCounters
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		prev: map[connID]connCounters{},
+		cur:  map[connID]connCounters{},
+	}
+}
+
+// observe records the counters of a connection in the pending snapshot and
+// returns the increment since the previous snapshot. New connections count
+// from zero; counters that went backwards (a new connection with a reused
+// identity) count from zero as well.
+func (t *deltaTracker) observe(id connID, orig, reply conntrack.DirectionStats) (dOrig, dReply conntrack.DirectionStats) {
+	last := t.prev[id]
+	t.cur[id] = connCounters{orig: orig, reply: reply}
+	return deltaStats(last.orig, orig), deltaStats(last.reply, reply)
+}
+
+// carry keeps the last known counters of a connection without accounting
+// anything, e.g. while its counters are excluded (offloaded).
+func (t *deltaTracker) carry(id connID) {
+	if last, ok := t.prev[id]; ok {
+		t.cur[id] = last
+	}
+}
+
+// commit makes the pending snapshot the reference for the next one.
+// Connections missing from it are forgotten.
+func (t *deltaTracker) commit() {
+	t.prev = t.cur
+	t.cur = make(map[connID]connCounters, len(t.prev))
+}
+
+// discard drops the pending snapshot (e.g. after a failed read).
+func (t *deltaTracker) discard() {
+	t.cur = make(map[connID]connCounters, len(t.prev))
+}
+
+func deltaStats(last, cur conntrack.DirectionStats) conntrack.DirectionStats {
+	if cur.Packets < last.Packets || cur.Bytes < last.Bytes {
+		return cur
+	}
+	return conntrack.DirectionStats{
+		Packets: cur.Packets - last.Packets,
+		Bytes:   cur.Bytes - last.Bytes,
+	}
+}
+
+// counterMetrics are the per-key traffic counters fed by deltaTracker.
+type counterMetrics struct {
+	sentPackets  *prometheus.CounterVec
+	sentBytes    *prometheus.CounterVec
+	replyPackets *prometheus.CounterVec
+	replyBytes   *prometheus.CounterVec
+}
+
+func newCounterMetrics(labelNames []string) *counterMetrics {
+	return &counterMetrics{
+		sentPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sent_packets_total",
+			Help: "Packets sent (original direction) for the aggregated conntrack key, accumulated from per-connection deltas between snapshots.",
+		}, labelNames),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sent_bytes_total",
+			Help: "Bytes sent (original direction) for the aggregated conntrack key, accumulated from per-connection deltas between snapshots.",
+		}, labelNames),
+		replyPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reply_packets_total",
+			Help: "Packets received (reply direction) for the aggregated conntrack key, accumulated from per-connection deltas between snapshots.",
+		}, labelNames),
+		replyBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reply_bytes_total",
+			Help: "Bytes received (reply direction) for the aggregated conntrack key, accumulated from per-connection deltas between snapshots.",
+		}, labelNames),
+	}
+}
+
+func (m *counterMetrics) families() []family {
+	return []family{
+		{"sent_packets_total", m.sentPackets},
+		{"sent_bytes_total", m.sentBytes},
+		{"reply_packets_total", m.replyPackets},
+		{"reply_bytes_total", m.replyBytes},
+	}
+}
+
+// add accumulates the deltas of one snapshot.
+func (m *counterMetrics) add(labels []string, v aggValues) {
+	m.sentPackets.WithLabelValues(labels...).Add(float64(v.SentPackets))
+	m.sentBytes.WithLabelValues(labels...).Add(float64(v.SentBytes))
+	m.replyPackets.WithLabelValues(labels...).Add(float64(v.ReplyPackets))
+	m.replyBytes.WithLabelValues(labels...).Add(float64(v.ReplyBytes))
+}
+
+// delete deletes the series of a key.
+func (m *counterMetrics) delete(labels []string) {
+	m.sentPackets.DeleteLabelValues(labels...)
+	m.sentBytes.DeleteLabelValues(labels...)
+	m.replyPackets.DeleteLabelValues(labels...)
+	m.replyBytes.DeleteLabelValues(labels...)
+}
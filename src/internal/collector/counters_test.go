@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+func stats(packets, bytes uint64) conntrack.DirectionStats {
+	return conntrack.DirectionStats{Packets: packets, Bytes: bytes}
+}
+
+func TestDeltaTracker(t *testing.T) {
+	a := connID{L3: "ipv4", L4: "tcp", Src: "10.0.0.1", Dst: "10.0.0.2", A: "40000", B: "443"}
+	b := connID{L3: "ipv4", L4: "tcp", Src: "10.0.0.1", Dst: "10.0.0.3", A: "40001", B: "443"}
+	c := connID{L3: "ipv4", L4: "udp", Src: "10.0.0.1", Dst: "10.0.0.4", A: "40002", B: "53"}
+
+	type observation struct {
+		id                  connID
+		orig, reply         conntrack.DirectionStats
+		wantOrig, wantReply conntrack.DirectionStats
+	}
+	tests := []struct {
+		name    string
+		observe []observation
+		carry   []connID
+		discard bool
+	}{
+		{
+			name: "new connections count from zero",
+			observe: []observation{
+				{a, stats(10, 1000), stats(8, 4000), stats(10, 1000), stats(8, 4000)},
+				{b, stats(5, 500), stats(0, 0), stats(5, 500), stats(0, 0)},
+			},
+		},
+		{
+			name: "increments per connection",
+			observe: []observation{
+				{a, stats(15, 1500), stats(10, 5000), stats(5, 500), stats(2, 1000)},
+				{c, stats(1, 60), stats(1, 120), stats(1, 60), stats(1, 120)},
+			},
+			// b is offloaded: its counters are excluded.
+			carry: []connID{b},
+		},
+		{
+			name: "counters going backwards restart from zero",
+			observe: []observation{
+				{a, stats(2, 200), stats(1, 100), stats(2, 200), stats(1, 100)},
+				// b is back, counted from its counters before it was
+				// offloaded.
+				{b, stats(8, 800), stats(1, 60), stats(3, 300), stats(1, 60)},
+			},
+			// c is closed.
+		},
+		{
+			name: "failed read",
+			observe: []observation{
+				{a, stats(4, 400), stats(2, 200), stats(2, 200), stats(1, 100)},
+			},
+			discard: true,
+		},
+		{
+			name: "after a failed read",
+			observe: []observation{
+				// From the last committed snapshot, not the discarded one.
+				{a, stats(6, 600), stats(3, 300), stats(4, 400), stats(2, 200)},
+				// Forgotten once missing from a snapshot.
+				{c, stats(2, 120), stats(2, 240), stats(2, 120), stats(2, 240)},
+			},
+		},
+	}
+	tr := newDeltaTracker()
+	for _, tt := range tests {
+		for _, o := range tt.observe {
+			dOrig, dReply := tr.observe(o.id, o.orig, o.reply)
+			if dOrig != o.wantOrig || dReply != o.wantReply {
+				t.Errorf("%s: observe(%s:%s) = %+v, %+v; want %+v, %+v", tt.name, o.id.Dst, o.id.B, dOrig, dReply, o.wantOrig, o.wantReply)
+			}
+		}
+		for _, id := range tt.carry {
+			tr.carry(id)
+		}
+		if tt.discard {
+			tr.discard()
+		} else {
+			tr.commit()
+		}
+	}
+	if _, ok := tr.prev[b]; ok || len(tr.prev) != 2 {
+		t.Errorf("tracked connections: got %v, want a and c", slices.Collect(maps.Keys(tr.prev)))
+	}
+}
+
+func TestCapSnapshot(t *testing.T) {
+	c := NewConntrackCollector(nil, Options{Labels: []string{"dst", "dport"}, MaxSeries: 3})
+	flows := func(bytes map[string]uint64) *snapshot {
+		snap := newSnapshot()
+		for dst, b := range bytes {
+			snap.flows[key{Dst: dst, DPort: "443"}] = aggValues{Entries: 1, SentBytes: b}
+		}
+		return snap
+	}
+	overflow := key{Dst: overflowValue, DPort: overflowValue}
+
+	tests := []struct {
+		name        string
+		bytes       map[string]uint64
+		limit       int
+		kind        string
+		want        map[string]uint64 // bytes by dst, overflow included
+		wantDropped float64           // since the first snapshot
+	}{
+		{
+			name:  "under the cap",
+			bytes: map[string]uint64{"10.0.0.1": 100, "10.0.0.2": 200},
+			limit: 3, kind: "snapshot",
+			want: map[string]uint64{"10.0.0.1": 100, "10.0.0.2": 200},
+		},
+		{
+			name:  "previous keys first",
+			bytes: map[string]uint64{"10.0.0.1": 100, "10.0.0.2": 200, "10.0.0.3": 300, "10.0.0.4": 400},
+			limit: 3, kind: "snapshot",
+			want:        map[string]uint64{"10.0.0.1": 100, "10.0.0.2": 200, overflowValue: 700},
+			wantDropped: 2,
+		},
+		{
+			name:  "then by bytes",
+			bytes: map[string]uint64{"10.0.0.2": 200, "10.0.0.3": 300, "10.0.0.4": 400, "10.0.0.5": 50},
+			limit: 3, kind: "snapshot",
+			want:        map[string]uint64{"10.0.0.2": 200, "10.0.0.4": 400, overflowValue: 350},
+			wantDropped: 4,
+		},
+		{
+			name:  "memory budget",
+			bytes: map[string]uint64{"10.0.0.2": 200, "10.0.0.3": 300, "10.0.0.4": 400},
+			limit: 2, kind: "memory",
+			want:        map[string]uint64{"10.0.0.4": 400, overflowValue: 500},
+			wantDropped: 2,
+		},
+	}
+	for _, tt := range tests {
+		snap := flows(tt.bytes)
+		c.capSnapshot(snap, tt.limit, tt.kind)
+		got := map[string]uint64{}
+		for k, v := range snap.flows {
+			if k == overflow {
+				got[overflowValue] = v.SentBytes
+			} else {
+				got[k.Dst] = v.SentBytes
+			}
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		var m dto.Metric
+		if err := c.seriesDropped.WithLabelValues(tt.kind).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetCounter().GetValue(); got != tt.wantDropped {
+			t.Errorf("%s: series_dropped_total{kind=%q} = %v, want %v", tt.name, tt.kind, got, tt.wantDropped)
+		}
+	}
+}
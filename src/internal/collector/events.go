@@ -0,0 +1,149 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// EventSource streams conntrack events (see ctnetlink.EventSource).
+//
+// Events blocks until ctx is done or the subscription fails. lost is called
+// whenever the kernel reports that events were dropped.
+type EventSource interface {
+	Events(ctx context.Context, fn func(conntrack.Event), lost func()) error
+}
+
+// eventResubscribeDelay is how long we wait before re-subscribing after the
+// event socket failed.
+const eventResubscribeDelay = 5 * time.Second
+
+// eventMetrics are the metrics maintained from conntrack events.
+//
+// Snapshots only see connections that are alive at refresh time; connections
+// that open and close between two refreshes are invisible to them. DESTROY
+// events carry the final counters of a connection, so we accumulate them into
+// per-key counters here. Unlike snapshot gauges, these are never reset.
+type eventMetrics struct {
+	events     *prometheus.CounterVec
+	eventsLost prometheus.Counter
+
+	closedConnections  *prometheus.CounterVec
+	closedSentPackets  *prometheus.CounterVec
+	closedSentBytes    *prometheus.CounterVec
+	closedReplyPackets *prometheus.CounterVec
+	closedReplyBytes   *prometheus.CounterVec
+}
+
+func newEventMetrics(labelNames []string) *eventMetrics {
+	m := &eventMetrics{}
+
+	m.events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_total",
+		Help: "Number of conntrack events received, by event type.",
+	}, []string{"type"})
+	m.eventsLost = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "events_lost_total",
+		Help: "Number of times the kernel dropped conntrack events because the socket buffer was full.",
+	})
+
+	m.closedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "closed_connections_total",
+		Help: "Number of closed connections for the aggregated conntrack key (from DESTROY events).",
+	}, labelNames)
+	m.closedSentPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "closed_sent_packets_total",
+		Help: "Packets sent (original direction) by closed connections for the aggregated conntrack key.",
+	}, labelNames)
+	m.closedSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "closed_sent_bytes_total",
+		Help: "Bytes sent (original direction) by closed connections for the aggregated conntrack key.",
+	}, labelNames)
+	m.closedReplyPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "closed_reply_packets_total",
+		Help: "Packets received (reply direction) by closed connections for the aggregated conntrack key.",
+	}, labelNames)
+	m.closedReplyBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "closed_reply_bytes_total",
+		Help: "Bytes received (reply direction) by closed connections for the aggregated conntrack key.",
+	}, labelNames)
+
+	return m
+}
+
+func (m *eventMetrics) families() []family {
+	return []family{
+		{"events_total", m.events},
+		{"events_lost_total", m.eventsLost},
+		{"closed_connections_total", m.closedConnections},
+		{"closed_sent_packets_total", m.closedSentPackets},
+		{"closed_sent_bytes_total", m.closedSentBytes},
+		{"closed_reply_packets_total", m.closedReplyPackets},
+		{"closed_reply_bytes_total", m.closedReplyBytes},
+	}
+}
+
+// delete deletes the closed connection series of a key.
+func (m *eventMetrics) delete(labels []string) {
+	m.closedConnections.DeleteLabelValues(labels...)
+	m.closedSentPackets.DeleteLabelValues(labels...)
+	m.closedSentBytes.DeleteLabelValues(labels...)
+	m.closedReplyPackets.DeleteLabelValues(labels...)
+	m.closedReplyBytes.DeleteLabelValues(labels...)
+}
+
+// runEvents keeps an event subscription open until ctx is done,
+// re-subscribing after failures, which are accounted in Options.Health as
+// the "events" collector.
+func (c *ConntrackCollector) runEvents(ctx context.Context) {
+	for {
+		err := c.opts.Events.Events(ctx, c.handleEvent, c.eventMetrics.eventsLost.Inc)
+		if err != nil && ctx.Err() == nil {
+			c.opts.Health.failed("events", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventResubscribeDelay):
+		}
+	}
+}
+
+func (c *ConntrackCollector) handleEvent(ev conntrack.Event) {
+	m := c.eventMetrics
+	m.events.WithLabelValues(ev.Type.String()).Inc()
+	churn := c.churnMetrics != nil || c.durations != nil || c.connBytes != nil
+	if ev.Type != conntrack.EventDestroy && !churn {
+		return
+	}
+	r := c.rules.Load()
+	if !c.accept(ev.Entry, r) {
+		return
+	}
+	k, ok := c.keyOf(ev.Entry, r)
+	if !ok {
+		return
+	}
+	if churn {
+		c.handleChurnEvent(ev)
+	}
+	if ev.Type != conntrack.EventDestroy {
+		return
+	}
+
+	k = c.counterKey(k)
+	if c.expiry != nil {
+		c.expiry.touch(k, time.Now())
+	}
+	labels := c.labelValues(k)
+	orig, reply := c.stats(ev.Entry)
+	m.closedConnections.WithLabelValues(labels...).Inc()
+	m.closedSentPackets.WithLabelValues(labels...).Add(float64(orig.Packets))
+	m.closedSentBytes.WithLabelValues(labels...).Add(float64(orig.Bytes))
+	m.closedReplyPackets.WithLabelValues(labels...).Add(float64(reply.Packets))
+	m.closedReplyBytes.WithLabelValues(labels...).Add(float64(reply.Bytes))
+}
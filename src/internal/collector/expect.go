@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/conntrack"
+	"conntrack-exporter/internal/procfs"
+)
+
+// ExpectCollector periodically reads the conntrack expectation table
+// (`/proc/net/nf_conntrack_expect`) and exports the number of pending
+// expectations per helper.
+//
+// Helpers (ftp, sip, tftp, ...) can only hold a limited number of
+// expectations per master connection (and nf_conntrack_expect_max in
+// total); once exhausted, related connections silently stop working.
+//
+// Like ConntrackCollector, metrics are snapshot gauges reset on each refresh.
+type ExpectCollector struct {
+	fs       procfs.FS
+	interval time.Duration
+	health   *Health
+
+	expectations *prometheus.GaugeVec
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewExpectCollector(fs procfs.FS, interval time.Duration, health *Health) *ExpectCollector {
+	return &ExpectCollector{
+		fs:       fs,
+		interval: interval,
+		health:   health,
+		expectations: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "expectations",
+			Help: "Number of conntrack expectations in the last snapshot, by helper (unknown when the master has no helper).",
+		}, []string{"helper"}),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// MustRegister registers all metrics into the provided registry.
+func (c *ExpectCollector) MustRegister(reg prometheus.Registerer) {
+	register(reg, c.interval, c.update, c.expectations)
+}
+
+func (c *ExpectCollector) update(ctx context.Context) {
+	_ = c.health.collect(ctx, "expect", c.UpdateOnce)
+}
+
+// Start begins periodic collection in a background goroutine.
+// It performs an initial update immediately.
+func (c *ExpectCollector) Start(ctx context.Context) {
+	go func() {
+		defer close(c.doneCh)
+		runPeriodic(ctx, c.interval, c.stopCh, c.update)
+	}()
+}
+
+func (c *ExpectCollector) Stop() {
+	close(c.stopCh)
+	<-c.doneCh
+}
+
+// UpdateOnce reads the expectation table and updates metrics.
+//
+// Unlike the conntrack table, an empty expectation table is the normal case.
+func (c *ExpectCollector) UpdateOnce(ctx context.Context) error {
+	_ = ctx // reserved for future (e.g. timeouts around file reads)
+
+	raw, err := c.fs.ReadFile("net/nf_conntrack_expect")
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]uint64{}
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for sc.Scan() {
+		x, ok := conntrack.ParseExpectLine(sc.Text())
+		if !ok {
+			continue
+		}
+		helper := x.Helper
+		if helper == "" {
+			helper = "unknown"
+		}
+		counts[helper]++
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	c.expectations.Reset()
+	for helper, n := range counts {
+		c.expectations.WithLabelValues(helper).Set(float64(n))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// family is a metric family of ConntrackCollector, named without the metric
+// prefix, for Options.Metrics.
+type family struct {
+	name      string
+	collector prometheus.Collector
+}
+
+// CheckMetrics validates the patterns of Options.Metrics.
+func CheckMetrics(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(strings.TrimPrefix(p, "-"), ""); err != nil || p == "" || p == "-" {
+			return fmt.Errorf("invalid metric pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// counterGauges are the per-connection gauges whose OpenMetrics family name
+// is that of a counter of Options.Counters: OpenMetrics names the family of
+// a counter without its _total suffix.
+var counterGauges = []string{"sent_packets", "sent_bytes", "reply_packets", "reply_bytes"}
+
+// CheckOpenMetrics returns an error when the families selected by patterns
+// (see Options.Metrics) include, with Options.Counters, a gauge and a
+// counter of the same OpenMetrics family name, which strict parsers reject.
+func CheckOpenMetrics(patterns []string) error {
+	var clash []string
+	for _, g := range counterGauges {
+		if selected(patterns, g) && selected(patterns, g+"_total") {
+			clash = append(clash, g)
+		}
+	}
+	if len(clash) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the %s gauges have the OpenMetrics family names of the counters: exclude them (-%s in collector.metrics) or disable web.openmetrics",
+		strings.Join(clash, ", "), strings.Join(clash, ",-"))
+}
+
+// selectFamilies returns the collectors of the families selected by patterns
+// (see Options.Metrics).
+func selectFamilies(patterns []string, fs []family) []prometheus.Collector {
+	var cs []prometheus.Collector
+	for _, f := range fs {
+		if selected(patterns, f.name) {
+			cs = append(cs, f.collector)
+		}
+	}
+	return cs
+}
+
+// selected reports whether patterns select the family name.
+func selected(patterns []string, name string) bool {
+	var include, exclude []string
+	for _, p := range patterns {
+		if p, ok := strings.CutPrefix(p, "-"); ok {
+			exclude = append(exclude, p)
+		} else {
+			include = append(include, p)
+		}
+	}
+	return (len(include) == 0 || matchAny(include, name)) && !matchAny(exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
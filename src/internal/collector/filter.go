@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CIDRFilter selects addresses by prefix. An address passes when it is in
+// one of the included prefixes (any address when there is none) and in none
+// of the excluded ones.
+type CIDRFilter struct {
+	include, exclude []netip.Prefix
+}
+
+// ParseCIDRFilter parses prefixes, excluded ones prefixed with `!` (e.g.
+// "10.0.0.0/8", "!10.1.0.0/16"). A bare address stands for a single-address
+// prefix.
+func ParseCIDRFilter(items []string) (CIDRFilter, error) {
+	var f CIDRFilter
+	for _, item := range items {
+		s, negate := strings.CutPrefix(item, "!")
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(s)
+			if ipErr != nil {
+				return f, fmt.Errorf("invalid prefix %q", item)
+			}
+			p = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		p = p.Masked()
+		if negate {
+			f.exclude = append(f.exclude, p)
+		} else {
+			f.include = append(f.include, p)
+		}
+	}
+	return f, nil
+}
+
+// enabled reports whether any prefix is configured.
+func (f CIDRFilter) enabled() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0
+}
+
+// match reports whether an address passes the filter. Unparsable addresses
+// only pass a filter without included prefixes.
+func (f CIDRFilter) match(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return len(f.include) == 0
+	}
+	ip = ip.Unmap()
+	for _, p := range f.exclude {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// PortFilter selects destination ports, with the same include/exclude
+// semantics as CIDRFilter.
+type PortFilter struct {
+	include, exclude []portRange
+}
+
+type portRange struct{ lo, hi uint16 }
+
+// ParsePortFilter parses ports and ranges, excluded ones prefixed with `!`
+// (e.g. "80", "1000-2000", "!53").
+func ParsePortFilter(items []string) (PortFilter, error) {
+	var f PortFilter
+	for _, item := range items {
+		s, negate := strings.CutPrefix(item, "!")
+		loStr, hiStr, isRange := strings.Cut(s, "-")
+		lo, err := strconv.ParseUint(loStr, 10, 16)
+		if err != nil {
+			return f, fmt.Errorf("invalid port %q", item)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.ParseUint(hiStr, 10, 16); err != nil || hi < lo {
+				return f, fmt.Errorf("invalid port range %q", item)
+			}
+		}
+		r := portRange{uint16(lo), uint16(hi)}
+		if negate {
+			f.exclude = append(f.exclude, r)
+		} else {
+			f.include = append(f.include, r)
+		}
+	}
+	return f, nil
+}
+
+// enabled reports whether any port is configured.
+func (f PortFilter) enabled() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0
+}
+
+// match reports whether a port passes the filter.
+func (f PortFilter) match(port uint16) bool {
+	in := func(r portRange) bool { return r.lo <= port && port <= r.hi }
+	if slices.ContainsFunc(f.exclude, in) {
+		return false
+	}
+	return len(f.include) == 0 || slices.ContainsFunc(f.include, in)
+}
@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"testing"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		pass  []string
+		fail  []string
+	}{
+		{
+			name: "none",
+			pass: []string{"10.0.0.1", "fd00::1", "overflow"},
+		},
+		{
+			name:  "include",
+			items: []string{"10.0.0.0/8", "fd00::/8"},
+			pass:  []string{"10.1.2.3", "fd00::1", "::ffff:10.0.0.1"},
+			fail:  []string{"192.168.0.1", "fe80::1", "overflow"},
+		},
+		{
+			name:  "exclude",
+			items: []string{"!10.1.0.0/16"},
+			pass:  []string{"10.0.0.1", "192.168.0.1", "overflow"},
+			fail:  []string{"10.1.2.3", "::ffff:10.1.0.1"},
+		},
+		{
+			name:  "exclude wins over include",
+			items: []string{"10.0.0.0/8", "!10.1.0.0/16"},
+			pass:  []string{"10.2.0.1"},
+			fail:  []string{"10.1.0.1", "192.168.0.1"},
+		},
+		{
+			name:  "bare address",
+			items: []string{"10.0.0.1", "!fd00::1"},
+			pass:  []string{"10.0.0.1"},
+			fail:  []string{"10.0.0.2", "fd00::1"},
+		},
+		{
+			name:  "unmasked prefix",
+			items: []string{"10.1.2.3/16"},
+			pass:  []string{"10.1.0.1"},
+			fail:  []string{"10.2.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseCIDRFilter(tt.items)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := f.enabled(), len(tt.items) > 0; got != want {
+				t.Errorf("enabled() = %v, want %v", got, want)
+			}
+			for _, addr := range tt.pass {
+				if !f.match(addr) {
+					t.Errorf("%v: %s filtered out", tt.items, addr)
+				}
+			}
+			for _, addr := range tt.fail {
+				if f.match(addr) {
+					t.Errorf("%v: %s passed", tt.items, addr)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, item := range []string{"10.0.0.0/33", "!", "host"} {
+		if _, err := ParseCIDRFilter([]string{item}); err == nil {
+			t.Errorf("ParseCIDRFilter(%q) succeeded", item)
+		}
+	}
+	for _, item := range []string{"65536", "!", "http", "2000-1000", "1000-", "-1000"} {
+		if _, err := ParsePortFilter([]string{item}); err == nil {
+			t.Errorf("ParsePortFilter(%q) succeeded", item)
+		}
+	}
+}
+
+func TestMatchDPort(t *testing.T) {
+	tcp := func(dport string) conntrack.Entry {
+		return conntrack.Entry{L4Proto: "tcp", Original: conntrack.ConntrackTuple{Sport: "40000", Dport: dport}}
+	}
+	icmp := conntrack.Entry{L4Proto: "icmp", Original: conntrack.ConntrackTuple{Type: "8", Code: "0"}}
+	gre := conntrack.Entry{L4Proto: "gre", Original: conntrack.ConntrackTuple{SrcKey: "0x1", DstKey: "0x0"}}
+
+	tests := []struct {
+		name  string
+		items []string
+		entry conntrack.Entry
+		want  bool
+	}{
+		{"none", nil, tcp("443"), true},
+		{"none portless", nil, icmp, true},
+		{"port", []string{"443"}, tcp("443"), true},
+		{"other port", []string{"443"}, tcp("80"), false},
+		{"range", []string{"1000-2000"}, tcp("1500"), true},
+		{"range bounds", []string{"1000-2000"}, tcp("2000"), true},
+		{"out of range", []string{"1000-2000"}, tcp("2001"), false},
+		{"negated", []string{"!53"}, tcp("53"), false},
+		{"negated other port", []string{"!53"}, tcp("443"), true},
+		{"negated range", []string{"!1000-2000"}, tcp("1000"), false},
+		{"negation wins", []string{"1-1024", "!53"}, tcp("53"), false},
+		{"include portless", []string{"443"}, icmp, false},
+		{"negated portless", []string{"!53"}, icmp, true},
+		{"negated portless gre", []string{"!53"}, gre, true},
+		{"include and negated portless", []string{"443", "!53"}, gre, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParsePortFilter(tt.items)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchDPort(f, tt.entry, tt.entry.Original.Dport); got != tt.want {
+				t.Errorf("matchDPort(%v, %s dport=%q) = %v, want %v", tt.items, tt.entry.L4Proto, tt.entry.Original.Dport, got, tt.want)
+			}
+		})
+	}
+}
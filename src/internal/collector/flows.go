@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"time"
+)
+
+// Flow is an aggregated key of a snapshot, with the values of its
+// per-connection metrics.
+type Flow struct {
+	// Labels are the label values of the key, by name (see Options.Labels).
+	Labels map[string]string `json:"labels"`
+
+	Connections  uint64 `json:"connections"`
+	SentPackets  uint64 `json:"sent_packets"`
+	SentBytes    uint64 `json:"sent_bytes"`
+	ReplyPackets uint64 `json:"reply_packets"`
+	ReplyBytes   uint64 `json:"reply_bytes"`
+}
+
+// flowSnapshot is the last applied snapshot, for Flows.
+type flowSnapshot struct {
+	time  time.Time
+	flows map[key]aggValues
+}
+
+// Flows returns the aggregated keys of the last snapshot (after TopN,
+// MinBytes and MaxSeries folding), and when it was taken. The zero time
+// means that no snapshot was taken yet.
+func (c *ConntrackCollector) Flows() ([]Flow, time.Time) {
+	last := c.lastFlows.Load()
+	if last == nil {
+		return nil, time.Time{}
+	}
+	names := labelNamesOf(c.labels)
+	flows := make([]Flow, 0, len(last.flows))
+	for k, v := range last.flows {
+		labels := make(map[string]string, len(names))
+		for i, value := range c.labelValues(k) {
+			labels[names[i]] = value
+		}
+		flows = append(flows, Flow{
+			Labels:       labels,
+			Connections:  v.Entries,
+			SentPackets:  v.SentPackets,
+			SentBytes:    v.SentBytes,
+			ReplyPackets: v.ReplyPackets,
+			ReplyBytes:   v.ReplyBytes,
+		})
+	}
+	return flows, last.time
+}
@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// Health accounts for the background refreshes of the collectors, which
+// serve their last successful result: without these metrics a refresh that
+// keeps failing would go unnoticed behind stale values. Failures are logged
+// at warn level. A nil *Health accounts nothing.
+//
+// Refreshes taking longer than timeout (when positive) are aborted through
+// their context, and keep the previous result.
+type Health struct {
+	log     *logging.Logger
+	timeout time.Duration
+
+	lastCollect   *prometheus.GaugeVec
+	duration      *prometheus.GaugeVec
+	errors        *prometheus.CounterVec
+	timeouts      *prometheus.CounterVec
+	entriesParsed prometheus.Gauge
+
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time
+}
+
+func NewHealth(log *logging.Logger, timeout time.Duration) *Health {
+	return &Health{
+		log:         log,
+		timeout:     timeout,
+		lastSuccess: map[string]time.Time{},
+		lastCollect: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "exporter_last_collect_timestamp_seconds",
+			Help: "Unix time of the last successful refresh, by collector.",
+		}, []string{"collector"}),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "exporter_collect_duration_seconds",
+			Help: "Duration of the last refresh, by collector.",
+		}, []string{"collector"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "exporter_collect_errors_total",
+			Help: "Number of failed refreshes, by collector.",
+		}, []string{"collector"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "exporter_collect_timeouts_total",
+			Help: "Number of refreshes aborted after --collector.timeout, by collector (also counted as failed).",
+		}, []string{"collector"}),
+		entriesParsed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "exporter_entries_parsed",
+			Help: "Number of conntrack entries read in the last snapshot, before filters.",
+		}),
+	}
+}
+
+// MustRegister registers all metrics into the provided registry.
+func (h *Health) MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(h.lastCollect, h.duration, h.errors, h.timeouts, h.entriesParsed)
+}
+
+// collect runs a refresh of the named collector, in a trace span, and
+// accounts for it.
+func (h *Health) collect(ctx context.Context, name string, update func(context.Context) error) error {
+	ctx, span := tracer.Start(ctx, "collect "+name, trace.WithAttributes(attribute.String("collector", name)))
+	defer span.End()
+	if h != nil && h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	err := h.account(name, func() error { return update(ctx) })
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (h *Health) account(name string, update func() error) error {
+	if h == nil {
+		return update()
+	}
+	// Export zero errors before the first failure.
+	failures := h.errors.WithLabelValues(name)
+	timeouts := h.timeouts.WithLabelValues(name)
+
+	start := time.Now()
+	err := update()
+	h.duration.WithLabelValues(name).Set(time.Since(start).Seconds())
+	if err != nil {
+		failures.Inc()
+		if errors.Is(err, context.DeadlineExceeded) {
+			timeouts.Inc()
+		}
+		h.log.Warn("collection failed", "collector", name, "err", err)
+		return err
+	}
+	now := time.Now()
+	h.lastCollect.WithLabelValues(name).Set(float64(now.UnixNano()) / 1e9)
+	h.mu.Lock()
+	h.lastSuccess[name] = now
+	h.mu.Unlock()
+	return nil
+}
+
+// LastSuccess returns the time of the last successful refresh of the named
+// collector, zero before the first one.
+func (h *Health) LastSuccess(name string) time.Time {
+	if h == nil {
+		return time.Time{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSuccess[name]
+}
+
+// parsed records the number of entries read in the last snapshot.
+func (h *Health) parsed(n int) {
+	if h == nil {
+		return
+	}
+	h.entriesParsed.Set(float64(n))
+}
+
+// failed accounts for a failure of the named collector outside of a
+// refresh, e.g. of its event subscription: it counts in
+// exporter_collect_errors_total and is logged at warn level.
+func (h *Health) failed(name string, err error) {
+	if h == nil {
+		return
+	}
+	h.errors.WithLabelValues(name).Inc()
+	h.log.Warn("collection failed", "collector", name, "err", err)
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshotHistogram is a histogram over the entries of the last snapshot.
+//
+// prometheus.Histogram accumulates observations forever, which is wrong for
+// snapshot data: an entry alive for 10 refreshes would be observed 10 times.
+// Instead, observations are collected into a pending set during a refresh and
+// swapped in atomically by commit(), replacing the previous snapshot.
+type snapshotHistogram struct {
+	desc    *prometheus.Desc
+	buckets []float64
+
+	mu      sync.Mutex
+	pending map[string]*histValues
+	cur     map[string]*histValues
+}
+
+type histValues struct {
+	labels  []string
+	count   uint64
+	sum     float64
+	buckets []uint64 // non-cumulative, len(buckets)
+}
+
+func newSnapshotHistogram(name, help string, labelNames []string, buckets []float64) *snapshotHistogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &snapshotHistogram{
+		desc:    prometheus.NewDesc(name, help, labelNames, nil),
+		buckets: b,
+		pending: map[string]*histValues{},
+		cur:     map[string]*histValues{},
+	}
+}
+
+// observe adds v to the pending snapshot. Only called by the refresh loop.
+func (h *snapshotHistogram) observe(v float64, labels ...string) {
+	k := strings.Join(labels, "\xff")
+	hv := h.pending[k]
+	if hv == nil {
+		hv = &histValues{labels: labels, buckets: make([]uint64, len(h.buckets))}
+		h.pending[k] = hv
+	}
+	hv.count++
+	hv.sum += v
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.buckets[i]++
+	}
+}
+
+// commit publishes the pending snapshot and starts a new one.
+func (h *snapshotHistogram) commit() {
+	h.mu.Lock()
+	h.cur = h.pending
+	h.mu.Unlock()
+	h.pending = map[string]*histValues{}
+}
+
+// discard drops the pending snapshot (e.g. after a failed read).
+func (h *snapshotHistogram) discard() {
+	h.pending = map[string]*histValues{}
+}
+
+func (h *snapshotHistogram) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *snapshotHistogram) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, hv := range h.cur {
+		cum := make(map[float64]uint64, len(h.buckets))
+		var n uint64
+		for i, b := range h.buckets {
+			n += hv.buckets[i]
+			cum[b] = n
+		}
+		ch <- prometheus.MustNewConstHistogram(h.desc, hv.count, hv.sum, cum, hv.labels...)
+	}
+}
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"strconv"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// icmpKey is the key of the ICMP breakdown.
+type icmpKey struct {
+	L4, Type string
+}
+
+// icmpTypes and icmpv6Types name the ICMP message types (IANA registries);
+// conntrack tracks queries (echo, timestamp, ...), errors only show up as
+// RELATED to other connections.
+var (
+	icmpTypes = map[string]string{
+		"0":  "echo-reply",
+		"3":  "dest-unreachable",
+		"4":  "source-quench",
+		"5":  "redirect",
+		"8":  "echo",
+		"9":  "router-advertisement",
+		"10": "router-solicitation",
+		"11": "time-exceeded",
+		"12": "parameter-problem",
+		"13": "timestamp",
+		"14": "timestamp-reply",
+		"15": "info-request",
+		"16": "info-reply",
+		"17": "address-mask",
+		"18": "address-mask-reply",
+	}
+	icmpv6Types = map[string]string{
+		"1":   "dest-unreachable",
+		"2":   "packet-too-big",
+		"3":   "time-exceeded",
+		"4":   "parameter-problem",
+		"128": "echo",
+		"129": "echo-reply",
+		"130": "mld-query",
+		"131": "mld-report",
+		"132": "mld-done",
+		"133": "router-solicitation",
+		"134": "router-advertisement",
+		"135": "neighbor-solicitation",
+		"136": "neighbor-advertisement",
+		"137": "redirect",
+		"143": "mld2-report",
+	}
+)
+
+// icmpTypeName returns the name of the ICMP type of an ICMP or ICMPv6 entry,
+// or its number when it has no name ("unknown" when the entry has none).
+func icmpTypeName(e conntrack.Entry) string {
+	names := icmpTypes
+	if e.L4Proto == "icmpv6" {
+		names = icmpv6Types
+	}
+	t := e.Original.Type
+	if name, ok := names[t]; ok {
+		return name
+	}
+	if _, err := strconv.ParseUint(t, 10, 8); err != nil {
+		return "unknown"
+	}
+	return t
+}
@@ -0,0 +1,239 @@
+package collector
+
+import (
+	"fmt"
+	"slices"
+)
+
+// labelDef is a single label of the per-connection metrics.
+type labelDef struct {
+	name  string
+	field func(k *key) *string
+}
+
+// baseLabels is the default label set, present unless Options.Labels says
+// otherwise.
+var baseLabels = []labelDef{
+	{"src", func(k *key) *string { return &k.Src }},
+	{"dst", func(k *key) *string { return &k.Dst }},
+	{"l3protocol", func(k *key) *string { return &k.L3 }},
+	{"l4protocol", func(k *key) *string { return &k.L4 }},
+	{"l7protocol", func(k *key) *string { return &k.L7 }},
+	{"dport", func(k *key) *string { return &k.DPort }},
+}
+
+// optionalLabels are appended after the base labels when enabled, in this
+// order.
+var optionalLabels = []labelDef{
+	{"sport", func(k *key) *string { return &k.SPort }},
+	{"state", func(k *key) *string { return &k.State }},
+	{"mark", func(k *key) *string { return &k.Mark }},
+	{"zone", func(k *key) *string { return &k.Zone }},
+	{"nat", func(k *key) *string { return &k.NAT }},
+	{"icmp_type", func(k *key) *string { return &k.ICMPType }},
+	{"icmp_code", func(k *key) *string { return &k.ICMPCode }},
+	{"reply_src", func(k *key) *string { return &k.ReplySrc }},
+	{"reply_dst", func(k *key) *string { return &k.ReplyDst }},
+	{"connlabels", func(k *key) *string { return &k.Connlabels }},
+	{"netns", func(k *key) *string { return &k.Netns }},
+	{"src_scope", func(k *key) *string { return &k.SrcScope }},
+	{"dst_scope", func(k *key) *string { return &k.DstScope }},
+	{"src_name", func(k *key) *string { return &k.SrcName }},
+	{"dst_name", func(k *key) *string { return &k.DstName }},
+	{"dst_country", func(k *key) *string { return &k.DstCountry }},
+	{"dst_asn", func(k *key) *string { return &k.DstASN }},
+	{"service", func(k *key) *string { return &k.Service }},
+	{"service_namespace", func(k *key) *string { return &k.ServiceNamespace }},
+	{"src_container", func(k *key) *string { return &k.SrcContainer }},
+	{"dst_container", func(k *key) *string { return &k.DstContainer }},
+	{"interface", func(k *key) *string { return &k.Interface }},
+	{"set", func(k *key) *string { return &k.Set }},
+}
+
+// aggregationPresets are named label sets for Options.Labels, from the finest
+// to the coarsest aggregation.
+var aggregationPresets = map[string][]string{
+	// One series per address pair and transport protocol.
+	"pair": {"src", "dst", "l3protocol", "l4protocol"},
+	// One series per destination service, whatever the source.
+	"dst": {"dst", "l3protocol", "l4protocol", "l7protocol", "dport"},
+	// One series per destination port, whatever the addresses.
+	"dport": {"l4protocol", "l7protocol", "dport"},
+	// One series per application protocol.
+	"l7": {"l7protocol"},
+	// One series per source host.
+	"host": {"src", "l3protocol"},
+}
+
+// AggregationLabels returns the label set of an aggregation preset
+// (pair, dst, dport, l7 or host).
+func AggregationLabels(preset string) ([]string, error) {
+	labels, ok := aggregationPresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation %q", preset)
+	}
+	return labels, nil
+}
+
+// allLabels are all the labels, in order.
+var allLabels = slices.Concat(baseLabels, optionalLabels)
+
+// CheckLabels validates label names for Options.Labels (and relabel rules).
+func CheckLabels(names []string) error {
+	for _, n := range names {
+		if !slices.ContainsFunc(allLabels, byName(n)) {
+			return fmt.Errorf("unknown label %q", n)
+		}
+	}
+	return nil
+}
+
+// keyLabels exposes the labels of a key to relabel rules.
+type keyLabels struct{ k *key }
+
+func (l keyLabels) Get(name string) string {
+	if i := slices.IndexFunc(allLabels, byName(name)); i >= 0 {
+		return *allLabels[i].field(l.k)
+	}
+	return ""
+}
+
+func (l keyLabels) Set(name, value string) {
+	if i := slices.IndexFunc(allLabels, byName(name)); i >= 0 {
+		*allLabels[i].field(l.k) = value
+	}
+}
+
+func byName(name string) func(labelDef) bool {
+	return func(d labelDef) bool { return d.name == name }
+}
+
+// newLabelSet returns the per-connection label set for the given options,
+// and the labels left out of it (to be blanked in the key so that entries
+// aggregate).
+//
+// The set is Options.Labels (or the base labels when empty) plus the
+// optional labels enabled by their own options.
+func newLabelSet(opts Options) (defs, omitted []labelDef) {
+	selected := map[string]bool{}
+	if len(opts.Labels) > 0 {
+		for _, n := range opts.Labels {
+			selected[n] = true
+		}
+	} else {
+		for _, d := range baseLabels {
+			selected[d.name] = true
+		}
+	}
+	enable := func(on bool, names ...string) {
+		for _, n := range names {
+			selected[n] = selected[n] || on
+		}
+	}
+	enable(opts.SPort.enabled(), "sport")
+	enable(opts.LabelState, "state")
+	enable(opts.LabelMark, "mark")
+	enable(opts.LabelZone, "zone")
+	enable(opts.LabelNAT, "nat")
+	enable(opts.LabelICMP, "icmp_type", "icmp_code")
+	enable(opts.LabelReply, "reply_src", "reply_dst")
+	enable(opts.LabelConnlabels, "connlabels")
+	enable(opts.LabelNetns, "netns")
+	enable(opts.LabelScope, "src_scope", "dst_scope")
+	enable(opts.LabelRDNS, "src_name", "dst_name")
+	enable(opts.LabelGeoIP, "dst_country", "dst_asn")
+	enable(opts.LabelService, "service", "service_namespace")
+	enable(opts.LabelContainer, "src_container", "dst_container")
+	enable(opts.LabelInterface, "interface")
+	enable(opts.LabelSet, "set")
+
+	for _, d := range allLabels {
+		if selected[d.name] {
+			defs = append(defs, d)
+		} else {
+			omitted = append(omitted, d)
+		}
+	}
+	return defs, omitted
+}
+
+// enableLabels turns on the optional labels of a label set in opts, so that
+// keyOf fills their key fields.
+func enableLabels(opts *Options, defs []labelDef) {
+	for _, d := range defs {
+		switch d.name {
+		case "sport":
+			if !opts.SPort.enabled() {
+				opts.SPort = SPortKeep
+			}
+		case "state":
+			opts.LabelState = true
+		case "mark":
+			opts.LabelMark = true
+		case "zone":
+			opts.LabelZone = true
+		case "nat":
+			opts.LabelNAT = true
+		case "icmp_type", "icmp_code":
+			opts.LabelICMP = true
+		case "reply_src", "reply_dst":
+			opts.LabelReply = true
+		case "connlabels":
+			opts.LabelConnlabels = true
+		case "netns":
+			opts.LabelNetns = true
+		case "src_scope", "dst_scope":
+			opts.LabelScope = true
+		case "src_name", "dst_name":
+			opts.LabelRDNS = true
+		case "dst_country", "dst_asn":
+			opts.LabelGeoIP = true
+		case "service", "service_namespace":
+			opts.LabelService = true
+		case "src_container", "dst_container":
+			opts.LabelContainer = true
+		case "interface":
+			opts.LabelInterface = true
+		case "set":
+			opts.LabelSet = true
+		}
+	}
+}
+
+func labelNamesOf(defs []labelDef) []string {
+	out := make([]string, len(defs))
+	for i, d := range defs {
+		out[i] = d.name
+	}
+	return out
+}
+
+func labelValuesOf(defs []labelDef, k key) []string {
+	out := make([]string, len(defs))
+	for i, d := range defs {
+		out[i] = *d.field(&k)
+	}
+	return out
+}
+
+// coarseLabels are the labels with a value per host or connection, blanked
+// by CoarsenSeries.
+var coarseLabels = []string{"src", "dst", "sport", "reply_src", "reply_dst", "src_name", "dst_name"}
+
+// coarseLabelSet returns the coarse labels of a label set.
+func coarseLabelSet(defs []labelDef) []labelDef {
+	var coarse []labelDef
+	for _, d := range defs {
+		if slices.Contains(coarseLabels, d.name) {
+			coarse = append(coarse, d)
+		}
+	}
+	return coarse
+}
+
+// blankLabels clears the key fields of omitted labels.
+func blankLabels(omitted []labelDef, k *key) {
+	for _, d := range omitted {
+		*d.field(k) = ""
+	}
+}
@@ -0,0 +1,183 @@
+package collector
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// overflowValue is the value of every label of the key that keys above
+// Options.MaxSeries collapse into.
+const overflowValue = "overflow"
+
+// minSeriesBudget is the lowest cap ShrinkSeries sets.
+const minSeriesBudget = 10
+
+// seriesLimit caps the number of keys of the cumulative per-key counters
+// (conntrack_closed_*, Options.Counters): their series are never deleted, so
+// the cap covers every key ever admitted.
+type seriesLimit struct {
+	max      int
+	overflow key
+	dropped  prometheus.Counter
+
+	mu   sync.Mutex
+	seen map[key]struct{}
+}
+
+// admit returns k, or the overflow key when k is new and the cap is
+// reached (one series is left for the overflow key). Safe for concurrent
+// use: events are handled in their own goroutine.
+func (l *seriesLimit) admit(k key) key {
+	if k == l.overflow {
+		// Already collapsed by capSnapshot.
+		return k
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[k]; ok {
+		return k
+	}
+	if len(l.seen) < l.max-1 {
+		l.seen[k] = struct{}{}
+		return k
+	}
+	l.dropped.Inc()
+	return l.overflow
+}
+
+// capSnapshot limits the keys of a snapshot to limit, including the overflow
+// key: keys of the previous snapshot are kept first so that existing series
+// stay stable, then new keys by decreasing bytes. The others collapse into
+// the overflow key, and are counted as dropped by kind.
+func (c *ConntrackCollector) capSnapshot(snap *snapshot, limit int, kind string) {
+	if len(snap.flows) > limit {
+		keys := c.keysByBytes(snap)
+		slices.SortStableFunc(keys, func(a, b key) int {
+			_, pa := c.prevKeys[a]
+			_, pb := c.prevKeys[b]
+			switch {
+			case pa == pb:
+				return 0
+			case pa:
+				return -1
+			}
+			return 1
+		})
+		drop := keys[limit-1:]
+		c.seriesDropped.WithLabelValues(kind).Add(float64(len(drop)))
+		fold(snap, drop, c.keyWith(overflowValue))
+	}
+
+	clear(c.prevKeys)
+	for k := range snap.flows {
+		c.prevKeys[k] = struct{}{}
+	}
+}
+
+// CoarsenSeries blanks the address and source port labels (see
+// coarseLabels) of the next snapshots, so that their entries aggregate by the
+// remaining labels, e.g. by protocol and destination port. It reports whether
+// that changed the label set, false when already coarsened or when the label
+// set has none of these labels. Keys of the cumulative counters are
+// coarsened too meanwhile.
+func (c *ConntrackCollector) CoarsenSeries() bool {
+	return len(c.coarse) > 0 && !c.coarsened.Swap(true)
+}
+
+// ShrinkSeries halves the number of keys of the next snapshots (down to
+// minSeriesBudget), starting from the keys of the last one: the keys with the
+// least bytes collapse into the overflow key, like with Options.MaxSeries.
+// It returns the new cap. Options.Shrinkable must be set.
+func (c *ConntrackCollector) ShrinkSeries() int {
+	budget := int(c.seriesBudget.Load())
+	if budget == 0 {
+		if last := c.lastFlows.Load(); last != nil {
+			budget = len(last.flows)
+		}
+	}
+	budget = max(budget/2, minSeriesBudget)
+	c.seriesBudget.Store(int64(budget))
+	return budget
+}
+
+// RestoreSeries lifts the cap set by ShrinkSeries and the coarsening of
+// CoarsenSeries.
+func (c *ConntrackCollector) RestoreSeries() {
+	c.seriesBudget.Store(0)
+	c.coarsened.Store(false)
+}
+
+// counterKey returns the key the cumulative counters of k are accounted to
+// (see seriesLimit).
+func (c *ConntrackCollector) counterKey(k key) key {
+	if c.counterLimit == nil {
+		return k
+	}
+	return c.counterLimit.admit(k)
+}
+
+// seriesExpiry tracks when the keys of the cumulative per-key counters were
+// last observed, so that the series of keys gone for Options.SeriesTTL can be
+// deleted. Safe for concurrent use: events are handled in their own
+// goroutine.
+type seriesExpiry struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	lastSeen map[key]time.Time
+}
+
+func newSeriesExpiry(ttl time.Duration) *seriesExpiry {
+	return &seriesExpiry{ttl: ttl, lastSeen: map[key]time.Time{}}
+}
+
+// touch records that k was observed at now. It must be called before the
+// series of k are updated, so that expire never deletes a series being
+// updated.
+func (e *seriesExpiry) touch(k key, now time.Time) {
+	e.mu.Lock()
+	e.lastSeen[k] = now
+	e.mu.Unlock()
+}
+
+// expire calls del for the keys not observed within the TTL before now, and
+// forgets them.
+func (e *seriesExpiry) expire(now time.Time, del func(key)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for k, t := range e.lastSeen {
+		if now.Sub(t) > e.ttl {
+			del(k)
+			delete(e.lastSeen, k)
+		}
+	}
+}
+
+// forget removes k from the keys counted against the cap, e.g. once its
+// series expired.
+func (l *seriesLimit) forget(k key) {
+	l.mu.Lock()
+	delete(l.seen, k)
+	l.mu.Unlock()
+}
+
+// expireSeries deletes the cumulative counter series of the keys not
+// observed for Options.SeriesTTL (see seriesExpiry).
+func (c *ConntrackCollector) expireSeries(now time.Time) {
+	c.expiry.expire(now, func(k key) {
+		labels := c.labelValues(k)
+		if c.counterMetrics != nil {
+			c.counterMetrics.delete(labels)
+		}
+		if c.eventMetrics != nil {
+			c.eventMetrics.delete(labels)
+		}
+		if c.counterLimit != nil {
+			c.counterLimit.forget(k)
+		}
+		c.seriesExpired.Inc()
+	})
+}
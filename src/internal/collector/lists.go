@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ListSource counts the entries of the kernel conntrack lists kept outside
+// the table, by list name (see ctnetlink.ListSource).
+type ListSource interface {
+	Lists(ctx context.Context) (map[string]uint64, error)
+}
+
+// ListCollector periodically exports the sizes of the dying and unconfirmed
+// conntrack lists (see ListSource).
+//
+// Entries on these lists still hold memory and count against
+// nf_conntrack_max without being visible in the table: a growing dying list
+// (e.g. an event listener that cannot keep up) precedes early drops.
+type ListCollector struct {
+	source   ListSource
+	interval time.Duration
+	health   *Health
+
+	entries *prometheus.GaugeVec
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewListCollector(source ListSource, interval time.Duration, health *Health) *ListCollector {
+	c := &ListCollector{
+		source:   source,
+		interval: interval,
+		health:   health,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	c.entries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "list_entries",
+		Help: "Number of conntrack entries outside the table, by list (dying, unconfirmed).",
+	}, []string{"list"})
+
+	return c
+}
+
+// MustRegister registers all metrics into the provided registry.
+func (c *ListCollector) MustRegister(reg prometheus.Registerer) {
+	register(reg, c.interval, c.update, c.entries)
+}
+
+func (c *ListCollector) update(ctx context.Context) {
+	_ = c.health.collect(ctx, "lists", c.UpdateOnce)
+}
+
+// Start begins periodic collection in a background goroutine.
+// It performs an initial update immediately.
+func (c *ListCollector) Start(ctx context.Context) {
+	go func() {
+		defer close(c.doneCh)
+		runPeriodic(ctx, c.interval, c.stopCh, c.update)
+	}()
+}
+
+func (c *ListCollector) Stop() {
+	close(c.stopCh)
+	<-c.doneCh
+}
+
+// UpdateOnce counts the list entries and updates metrics.
+func (c *ListCollector) UpdateOnce(ctx context.Context) error {
+	lists, err := c.source.Lists(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.entries.Reset()
+	for name, n := range lists {
+		c.entries.WithLabelValues(name).Set(float64(n))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"conntrack-exporter/internal/conntrack"
+	"conntrack-exporter/internal/netns"
+	"conntrack-exporter/internal/procfs"
+)
+
+// NetnsSource reads the conntrack table of every network namespace on the
+// host by switching into each namespace and reading Inner there. Entries are
+// tagged with the namespace name (Entry.Netns).
+//
+// Inner must resolve the namespace per thread: a netlink source (sockets are
+// created in the current namespace) or a ProcfsSource rooted at
+// `<procfs>/thread-self`. `/proc/self/net` follows the main thread and would
+// always show the host namespace.
+type NetnsSource struct {
+	Inner Source
+
+	// FS is the procfs used to find namespaces.
+	FS procfs.FS
+	// RunDir holds named namespaces (see netns.DefaultRunDir).
+	RunDir string
+	// ScanProcs also collects the namespaces of all processes (containers
+	// not registered in RunDir).
+	ScanProcs bool
+}
+
+func (s NetnsSource) Entries(ctx context.Context, fn func(conntrack.Entry)) error {
+	nss, err := netns.List(s.FS, s.RunDir, s.ScanProcs)
+	if err != nil {
+		return err
+	}
+
+	// Namespaces come and go (and many have an empty table), so a failing
+	// namespace does not fail the whole collection.
+	var errs []error
+	for _, ns := range nss {
+		err := netns.Do(s.FS, ns.Path, func() error {
+			return s.Inner.Entries(ctx, func(e conntrack.Entry) {
+				e.Netns = ns.Name
+				fn(e)
+			})
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("netns %s: %w", ns.Name, err))
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if len(errs) == len(nss) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// rejectSampleInterval limits how often a rejected line is logged.
+const rejectSampleInterval = 10 * time.Second
+
+// ParseStats accounts for conntrack lines and entries that did not make it
+// into the metrics:
+//   - parse errors: non-empty lines rejected by the parser (see ProcfsSource)
+//   - skipped: entries dropped by the collector filters (e.g. Options.Zones)
+//
+// A sample of rejected lines is logged at debug level, at most once per
+// rejectSampleInterval, so that format regressions are visible without
+// flooding the log. A nil *ParseStats accounts nothing.
+type ParseStats struct {
+	log *logging.Logger
+
+	parseErrors  prometheus.Counter
+	linesSkipped prometheus.Counter
+
+	mu         sync.Mutex
+	lastSample time.Time
+	suppressed uint64
+}
+
+func NewParseStats(log *logging.Logger) *ParseStats {
+	return &ParseStats{
+		log: log,
+		parseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_parse_errors_total",
+			Help: "Number of conntrack lines that could not be parsed.",
+		}),
+		linesSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_lines_skipped_total",
+			Help: "Number of conntrack entries skipped by the collector filters.",
+		}),
+	}
+}
+
+// MustRegister registers all metrics into the provided registry.
+func (s *ParseStats) MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(s.parseErrors, s.linesSkipped)
+}
+
+// rejected accounts for a line the parser rejected.
+func (s *ParseStats) rejected(line []byte) {
+	if s == nil {
+		return
+	}
+	s.parseErrors.Inc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastSample) < rejectSampleInterval {
+		s.suppressed++
+		return
+	}
+	s.log.Debug("rejected conntrack line", "line", string(line), "suppressed", s.suppressed)
+	s.lastSample = time.Now()
+	s.suppressed = 0
+}
+
+// skipped accounts for an entry dropped by a filter.
+func (s *ParseStats) skipped() {
+	if s == nil {
+		return
+	}
+	s.linesSkipped.Inc()
+}
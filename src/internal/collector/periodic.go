@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultPrefix is the default prefix of metric names. Collectors define
+// their metrics without it: it is added when registering them (see
+// prometheus.WrapRegistererWithPrefix), so that it can be changed.
+const DefaultPrefix = "conntrack"
+
+// runPeriodic calls update immediately and then every interval until ctx is
+// done or stopCh is closed. Errors are left to update to handle: a failed
+// refresh keeps the previously published metrics.
+//
+// A zero interval selects scrape mode: nothing is refreshed in the
+// background, see register.
+func runPeriodic(ctx context.Context, interval time.Duration, stopCh <-chan struct{}, update func(context.Context)) {
+	if interval <= 0 {
+		select {
+		case <-ctx.Done():
+		case <-stopCh:
+		}
+		return
+	}
+
+	update(ctx)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopCh:
+			return
+		case <-t.C:
+			update(ctx)
+		}
+	}
+}
+
+// register registers the metrics of a collector. With a zero interval
+// (scrape mode) they are wrapped into a collector calling update on each
+// scrape, before collecting them, with the context of the gathering when reg
+// is a Registerer of a Registry.
+func register(reg prometheus.Registerer, interval time.Duration, update func(context.Context), cs ...prometheus.Collector) {
+	if interval > 0 {
+		reg.MustRegister(cs...)
+		return
+	}
+	sc := &scrapeCollector{update: update, cs: cs}
+	if r, ok := reg.(*registerer); ok {
+		sc.reg = r.reg
+		r.reg.scrape.Store(true)
+	}
+	reg.MustRegister(sc)
+}
+
+// Registry is a registry of collectors whose scrape mode refreshes (see
+// register) run with the context of the gathering, see GatherContext.
+type Registry struct {
+	*prometheus.Registry
+
+	// scrape is set once a collector refreshing on scrape is registered:
+	// its gatherings are then serialized, under mu, each one setting ctx.
+	scrape atomic.Bool
+	mu     sync.Mutex
+	ctx    context.Context
+}
+
+func NewRegistry() *Registry {
+	return &Registry{Registry: prometheus.NewRegistry()}
+}
+
+// Registerer returns the Registerer of r through wrap (e.g. adding
+// constant labels or a prefix), for the Register methods of collectors.
+func (r *Registry) Registerer(wrap func(prometheus.Registerer) prometheus.Registerer) prometheus.Registerer {
+	return &registerer{Registerer: wrap(r.Registry), reg: r}
+}
+
+// Gather gathers r with a background context.
+func (r *Registry) Gather() ([]*dto.MetricFamily, error) {
+	return r.GatherContext(context.Background())
+}
+
+// GatherContext gathers r, running its refreshes in scrape mode with ctx,
+// e.g. that of the scrape request, so that they stop once the client is gone.
+func (r *Registry) GatherContext(ctx context.Context) ([]*dto.MetricFamily, error) {
+	if !r.scrape.Load() {
+		return r.Registry.Gather()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx = ctx
+	defer func() { r.ctx = nil }()
+	return r.Registry.Gather()
+}
+
+// context returns the context of the gathering in progress.
+func (r *Registry) context() context.Context {
+	if r == nil || r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// registerer is a Registerer of a Registry, see Registry.Registerer.
+type registerer struct {
+	prometheus.Registerer
+	reg *Registry
+}
+
+// ContextGatherer returns a Gatherer of g whose refreshes in scrape mode run
+// with ctx when g is a Registry (see Registry.GatherContext), g otherwise.
+func ContextGatherer(ctx context.Context, g prometheus.Gatherer) prometheus.Gatherer {
+	r, ok := g.(*Registry)
+	if !ok {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return r.GatherContext(ctx)
+	})
+}
+
+// scrapeCollector refreshes metrics on each scrape. Concurrent scrapes are
+// serialized, so that each one sees the metrics of its own refresh.
+type scrapeCollector struct {
+	mu     sync.Mutex
+	update func(context.Context)
+	cs     []prometheus.Collector
+	// reg is the Registry whose gatherings give the context of the
+	// refreshes, nil for a background context.
+	reg *Registry
+}
+
+func (s *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range s.cs {
+		c.Describe(ch)
+	}
+}
+
+func (s *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(s.reg.context())
+	for _, c := range s.cs {
+		c.Collect(ch)
+	}
+}
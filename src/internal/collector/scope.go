@@ -0,0 +1,37 @@
+package collector
+
+import "net/netip"
+
+var (
+	cgnat = netip.MustParsePrefix("100.64.0.0/10")
+	ula   = netip.MustParsePrefix("fc00::/7")
+)
+
+// scopeOf classifies an address label value: loopback, link_local,
+// rfc1918, ula, cgnat (RFC 6598 shared space), multicast, public, or
+// unknown when it is not an address.
+func scopeOf(label string) string {
+	ip, ok := addrOf(label)
+	if !ok {
+		return "unknown"
+	}
+	ip = ip.Unmap()
+	switch {
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		return "link_local"
+	case ip.IsMulticast():
+		return "multicast"
+	case ula.Contains(ip):
+		return "ula"
+	case ip.IsPrivate():
+		return "rfc1918"
+	case cgnat.Contains(ip):
+		return "cgnat"
+	case ip.IsGlobalUnicast():
+		return "public"
+	}
+	// Unspecified addresses.
+	return "unknown"
+}
@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+
+	"conntrack-exporter/internal/conntrack"
+	"conntrack-exporter/internal/procfs"
+)
+
+// Source produces the current conntrack table for one collection cycle.
+//
+// Implementations:
+// - ProcfsSource: parses `/proc/net/nf_conntrack` (or `/proc/net/ip_conntrack`)
+// - ctnetlink.Source: dumps the table over NETLINK_NETFILTER
+type Source interface {
+	Entries(ctx context.Context, fn func(conntrack.Entry)) error
+}
+
+// ProcfsSource reads conntrack entries from `<procfs>/net/nf_conntrack`, or
+// from the legacy `<procfs>/net/ip_conntrack` when the former does not exist.
+type ProcfsSource struct {
+	FS procfs.FS
+
+	// Stats accounts for rejected lines (optional).
+	Stats *ParseStats
+}
+
+// ctxCheckLines is the number of lines read between two checks of the
+// context: checking it on every line of a table of millions of entries
+// would cost more than the timeout saves.
+const ctxCheckLines = 4096
+
+// procfsFiles are the conntrack table files, in order of preference.
+var procfsFiles = []string{"net/nf_conntrack", "net/ip_conntrack"}
+
+// Entries stops with the error of ctx once it is done, checked every
+// ctxCheckLines lines.
+func (s ProcfsSource) Entries(ctx context.Context, fn func(conntrack.Entry)) error {
+	var (
+		f    *os.File
+		name string
+		err  error
+	)
+	for _, name = range procfsFiles {
+		f, err = s.FS.Open(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The table is streamed line by line: with millions of entries the file
+	// is hundreds of megabytes and must not be held in memory at once.
+	sc := bufio.NewScanner(f)
+	// conntrack lines are typically below 4K, but let's be safe.
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var any bool
+	for n := 1; sc.Scan(); n++ {
+		if n%ctxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		// The scanner's buffer is reused: ParseLineBytes copies what it keeps.
+		line := sc.Bytes()
+		e, ok := conntrack.ParseLineBytes(line)
+		if !ok {
+			if !isBlank(line) {
+				s.Stats.rejected(line)
+			}
+			continue
+		}
+		any = true
+		fn(e)
+	}
+
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if !any {
+		return errors.New("no conntrack entries parsed from " + name)
+	}
+
+	return nil
+}
+
+func isBlank(line []byte) bool {
+	return len(bytes.TrimSpace(line)) == 0
+}
@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"strconv"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// SPortMode is how the source port is exported (see Options.SPort).
+type SPortMode string
+
+const (
+	// SPortDrop leaves the source port out (default).
+	SPortDrop SPortMode = "drop"
+	// SPortKeep adds the source port as a `sport` label.
+	SPortKeep SPortMode = "keep"
+	// SPortEphemeral adds the source port as a `sport` label, with
+	// ephemeral ports bucketed into sport="ephemeral".
+	SPortEphemeral SPortMode = "ephemeral-bucket"
+)
+
+// ephemeralPort is the first port of the default Linux ephemeral range
+// (net.ipv4.ip_local_port_range).
+const ephemeralPort = 32768
+
+// ParseSPortMode validates a source port mode.
+func ParseSPortMode(s string) (SPortMode, bool) {
+	switch m := SPortMode(s); m {
+	case SPortDrop, SPortKeep, SPortEphemeral:
+		return m, true
+	}
+	return "", false
+}
+
+// enabled reports whether the mode adds a `sport` label.
+func (m SPortMode) enabled() bool {
+	return m != "" && m != SPortDrop
+}
+
+// sport returns the sport label value of an entry: "0" for protocols without
+// ports, as dport.
+func (c *ConntrackCollector) sport(e conntrack.Entry) string {
+	if !e.HasPorts() {
+		return "0"
+	}
+	sport := e.Original.Sport
+	if c.opts.ReplyTuple && e.Reply.SrcIP != "" {
+		// See endpoints.
+		sport = e.Reply.Dport
+	}
+	if c.opts.SPort == SPortEphemeral {
+		if p, err := strconv.Atoi(sport); err == nil && p >= ephemeralPort {
+			return "ephemeral"
+		}
+	}
+	return sport
+}
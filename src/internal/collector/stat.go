@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/conntrack"
+	"conntrack-exporter/internal/procfs"
+)
+
+// statCounter maps a column of `/proc/net/stat/nf_conntrack` to a metric.
+type statCounter struct {
+	column string
+	desc   *prometheus.Desc
+}
+
+func newStatCounter(column, help string) statCounter {
+	return statCounter{
+		column: column,
+		desc:   prometheus.NewDesc("stat_"+column+"_total", help, []string{"cpu"}, nil),
+	}
+}
+
+// statCounters are the exported per-CPU counters. Columns missing on the
+// running kernel are skipped.
+var statCounters = []statCounter{
+	newStatCounter("found", "Number of successful conntrack lookups, per CPU."),
+	newStatCounter("invalid", "Number of packets that could not be tracked (invalid or malformed), per CPU."),
+	newStatCounter("insert", "Number of entries inserted into the table, per CPU."),
+	newStatCounter("delete", "Number of entries deleted from the table, per CPU (not maintained by recent kernels)."),
+	newStatCounter("insert_failed", "Number of entries whose insertion into the table failed (e.g. clashes), per CPU."),
+	newStatCounter("drop", "Number of packets dropped because a new entry could not be created (table full), per CPU."),
+	newStatCounter("early_drop", "Number of entries evicted early to make room for new ones (table full), per CPU."),
+	newStatCounter("search_restart", "Number of table lookups restarted due to concurrent hash resizing, per CPU."),
+}
+
+// StatSource produces the per-CPU conntrack statistics, indexed by CPU.
+//
+// Implementations:
+// - ProcfsStatSource: parses `/proc/net/stat/nf_conntrack`
+// - ctnetlink.StatSource: IPCTNL_MSG_CT_GET_STATS_CPU over netlink
+type StatSource interface {
+	Stats(ctx context.Context) ([]conntrack.CPUStat, error)
+}
+
+// ProcfsStatSource reads `<procfs>/net/stat/nf_conntrack`.
+type ProcfsStatSource struct {
+	FS procfs.FS
+}
+
+func (s ProcfsStatSource) Stats(ctx context.Context) ([]conntrack.CPUStat, error) {
+	_ = ctx // reserved for future (e.g. timeouts around file reads)
+
+	raw, err := s.FS.ReadFile("net/stat/nf_conntrack")
+	if err != nil {
+		return nil, err
+	}
+	return conntrack.ParseStat(raw)
+}
+
+// StatCollector periodically reads the per-CPU conntrack statistics (see
+// StatSource) and exports them as counters.
+//
+// These are the standard kernel signals of table pressure: drop/early_drop
+// grow when the table is full, insert_failed on clashes.
+//
+// The values are monotonic kernel counters, so they are exported as const
+// counters from the last read rather than as snapshot gauges.
+type StatCollector struct {
+	source   StatSource
+	interval time.Duration
+	health   *Health
+
+	mu   sync.Mutex
+	cpus []conntrack.CPUStat
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewStatCollector(source StatSource, interval time.Duration, health *Health) *StatCollector {
+	return &StatCollector{
+		source:   source,
+		interval: interval,
+		health:   health,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// MustRegister registers the collector into the provided registry.
+func (c *StatCollector) MustRegister(reg prometheus.Registerer) {
+	register(reg, c.interval, c.update, c)
+}
+
+func (c *StatCollector) update(ctx context.Context) {
+	_ = c.health.collect(ctx, "stat", c.UpdateOnce)
+}
+
+// Start begins periodic collection in a background goroutine.
+// It performs an initial update immediately.
+func (c *StatCollector) Start(ctx context.Context) {
+	go func() {
+		defer close(c.doneCh)
+		runPeriodic(ctx, c.interval, c.stopCh, c.update)
+	}()
+}
+
+func (c *StatCollector) Stop() {
+	close(c.stopCh)
+	<-c.doneCh
+}
+
+// UpdateOnce reads the statistics and replaces the cached values.
+func (c *StatCollector) UpdateOnce(ctx context.Context) error {
+	cpus, err := c.source.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cpus = cpus
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *StatCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, sc := range statCounters {
+		ch <- sc.desc
+	}
+}
+
+func (c *StatCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, row := range c.cpus {
+		cpu := strconv.Itoa(i)
+		for _, sc := range statCounters {
+			v, ok := row[sc.column]
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(sc.desc, prometheus.CounterValue, float64(v), cpu)
+		}
+	}
+}
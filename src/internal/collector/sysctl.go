@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/procfs"
+	"conntrack-exporter/internal/sysctl"
+)
+
+// SysctlCollector periodically reads the conntrack sysctls the exporter
+// depends on (nf_conntrack_acct, nf_conntrack_timestamp, nf_conntrack_max),
+// so that changes made at runtime show up in monitoring: the startup checks
+// only see the values at startup.
+type SysctlCollector struct {
+	fs       procfs.FS
+	interval time.Duration
+	health   *Health
+
+	// Label-less vectors, so that a sysctl is not exported until it was
+	// read: a missing value must not look like a disabled setting.
+	acct      *prometheus.GaugeVec
+	timestamp *prometheus.GaugeVec
+	max       *prometheus.GaugeVec
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewSysctlCollector(fs procfs.FS, interval time.Duration, health *Health) *SysctlCollector {
+	c := &SysctlCollector{
+		fs:       fs,
+		interval: interval,
+		health:   health,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	c.acct = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sysctl_acct",
+		Help: "Value of net.netfilter.nf_conntrack_acct (1: packets/bytes are counted).",
+	}, nil)
+	c.timestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sysctl_timestamp",
+		Help: "Value of net.netfilter.nf_conntrack_timestamp (1: connection ages are recorded).",
+	}, nil)
+	c.max = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sysctl_max",
+		Help: "Value of net.netfilter.nf_conntrack_max.",
+	}, nil)
+
+	return c
+}
+
+// MustRegister registers all metrics into the provided registry.
+func (c *SysctlCollector) MustRegister(reg prometheus.Registerer) {
+	register(reg, c.interval, c.update, c.acct, c.timestamp, c.max)
+}
+
+func (c *SysctlCollector) update(ctx context.Context) {
+	_ = c.health.collect(ctx, "sysctl", c.UpdateOnce)
+}
+
+// Start begins periodic collection in a background goroutine.
+// It performs an initial update immediately.
+func (c *SysctlCollector) Start(ctx context.Context) {
+	go func() {
+		defer close(c.doneCh)
+		runPeriodic(ctx, c.interval, c.stopCh, c.update)
+	}()
+}
+
+func (c *SysctlCollector) Stop() {
+	close(c.stopCh)
+	<-c.doneCh
+}
+
+// UpdateOnce reads the sysctls and updates metrics. A sysctl that cannot be
+// read keeps its last value; the others are still updated.
+func (c *SysctlCollector) UpdateOnce(ctx context.Context) error {
+	_ = ctx
+
+	acct, err1 := sysctl.ReadNfConntrackAcct(c.fs)
+	if err1 == nil {
+		c.acct.WithLabelValues().Set(float64(acct))
+	}
+	timestamp, err2 := sysctl.ReadNfConntrackTimestamp(c.fs)
+	if err2 == nil {
+		c.timestamp.WithLabelValues().Set(float64(timestamp))
+	}
+	maxEntries, err3 := sysctl.ReadNfConntrackMax(c.fs)
+	if err3 == nil {
+		c.max.WithLabelValues().Set(float64(maxEntries))
+	}
+	return errors.Join(err1, err2, err3)
+}
@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"conntrack-exporter/internal/procfs"
+	"conntrack-exporter/internal/sysctl"
+)
+
+// TableCollector periodically reads the conntrack table size sysctls
+// (nf_conntrack_count, nf_conntrack_max) and exports the table utilization.
+//
+// When the table is full, new connections are dropped; this is the most
+// common conntrack alert. The count is read from the kernel rather than from
+// the snapshot, so it is cheap and not affected by filters.
+//
+// With a StatSource, drops are taken into account in the table pressure:
+// early drops keep the table below nf_conntrack_max while connections are
+// already being evicted.
+type TableCollector struct {
+	fs       procfs.FS
+	stats    StatSource
+	interval time.Duration
+	health   *Health
+
+	entries     prometheus.Gauge
+	limit       prometheus.Gauge
+	utilization prometheus.Gauge
+	pressure    prometheus.Gauge
+	untilFull   prometheus.Gauge
+
+	// Previous refresh, for rates (prevTime is zero before the first one).
+	prevTime     time.Time
+	prevCount    uint64
+	prevDrops    uint64
+	prevHasDrops bool
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewTableCollector returns a collector of the table size sysctls; stats
+// is optional (nil: drops are not taken into account).
+func NewTableCollector(fs procfs.FS, stats StatSource, interval time.Duration, health *Health) *TableCollector {
+	c := &TableCollector{
+		fs:       fs,
+		stats:    stats,
+		interval: interval,
+		health:   health,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	c.entries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "entries",
+		Help: "Number of entries in the conntrack table (net.netfilter.nf_conntrack_count).",
+	})
+	c.limit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "entries_limit",
+		Help: "Maximum number of entries in the conntrack table (net.netfilter.nf_conntrack_max).",
+	})
+	c.utilization = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "entries_utilization_ratio",
+		Help: "Fraction of the conntrack table in use (conntrack_entries / conntrack_entries_limit).",
+	})
+	c.pressure = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "table_pressure",
+		Help: "Pressure on the conntrack table, from 0 to 1: the utilization ratio, or 1 when entries were dropped (drop, early_drop) since the previous refresh.",
+	})
+	c.untilFull = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "table_seconds_until_full",
+		Help: "Predicted seconds until the conntrack table is full, at the growth rate of the entries since the previous refresh (+Inf when not growing).",
+	})
+
+	return c
+}
+
+// MustRegister registers all metrics into the provided registry.
+func (c *TableCollector) MustRegister(reg prometheus.Registerer) {
+	register(reg, c.interval, c.update, c.entries, c.limit, c.utilization, c.pressure, c.untilFull)
+}
+
+func (c *TableCollector) update(ctx context.Context) {
+	_ = c.health.collect(ctx, "table", c.UpdateOnce)
+}
+
+// Start begins periodic collection in a background goroutine.
+// It performs an initial update immediately.
+func (c *TableCollector) Start(ctx context.Context) {
+	go func() {
+		defer close(c.doneCh)
+		runPeriodic(ctx, c.interval, c.stopCh, c.update)
+	}()
+}
+
+func (c *TableCollector) Stop() {
+	close(c.stopCh)
+	<-c.doneCh
+}
+
+// UpdateOnce reads the sysctls (and statistics) and updates metrics.
+func (c *TableCollector) UpdateOnce(ctx context.Context) error {
+	count, err := sysctl.ReadNfConntrackCount(c.fs)
+	if err != nil {
+		return err
+	}
+	maxEntries, err := sysctl.ReadNfConntrackMax(c.fs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// Drops since the previous refresh; the first refresh only sets the
+	// reference. Statistics failing to read (e.g. missing from a restricted
+	// procfs) leave the pressure to the utilization ratio: the stat
+	// collector, when enabled, reports the error.
+	dropped := false
+	if c.stats != nil {
+		if cpus, err := c.stats.Stats(ctx); err != nil {
+			c.prevHasDrops = false
+		} else {
+			var drops uint64
+			for _, row := range cpus {
+				drops += row["drop"] + row["early_drop"]
+			}
+			dropped = c.prevHasDrops && drops > c.prevDrops
+			c.prevDrops, c.prevHasDrops = drops, true
+		}
+	}
+
+	c.entries.Set(float64(count))
+	c.limit.Set(float64(maxEntries))
+	if maxEntries > 0 {
+		ratio := float64(count) / float64(maxEntries)
+		c.utilization.Set(ratio)
+		if dropped {
+			ratio = 1
+		}
+		c.pressure.Set(min(ratio, 1))
+	}
+
+	// nf_conntrack_max=0 means no limit.
+	untilFull := math.Inf(1)
+	switch {
+	case maxEntries == 0:
+	case count >= maxEntries:
+		untilFull = 0
+	case !c.prevTime.IsZero() && count > c.prevCount:
+		rate := float64(count-c.prevCount) / now.Sub(c.prevTime).Seconds()
+		untilFull = float64(maxEntries-count) / rate
+	}
+	c.untilFull.Set(untilFull)
+	c.prevCount, c.prevTime = count, now
+	return nil
+}
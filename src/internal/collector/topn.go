@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"cmp"
+	"slices"
+)
+
+// otherValue is the value of every label of the key the smallest keys are
+// folded into (see Options.TopN).
+const otherValue = "other"
+
+// foldTopN keeps the n aggregated keys with the most bytes and folds the
+// others into a single key with all labels set to "other".
+func (c *ConntrackCollector) foldTopN(snap *snapshot, n int) {
+	if len(snap.flows) <= n {
+		return
+	}
+
+	keys := c.keysByBytes(snap)
+	fold(snap, keys[n:], c.keyWith(otherValue))
+}
+
+// foldSmall folds the keys with fewer bytes or packets (both directions)
+// than the thresholds into the "other" key (see foldTopN). A zero threshold
+// is disabled.
+func (c *ConntrackCollector) foldSmall(snap *snapshot, minBytes, minPackets uint64) {
+	var small []key
+	for k, v := range snap.flows {
+		if v.SentBytes+v.ReplyBytes < minBytes || v.SentPackets+v.ReplyPackets < minPackets {
+			small = append(small, k)
+		}
+	}
+	if len(small) > 0 {
+		fold(snap, small, c.keyWith(otherValue))
+	}
+}
+
+// keysByBytes returns the keys of a snapshot by decreasing bytes (both
+// directions), ties broken by label values so that the order does not
+// depend on map order.
+func (c *ConntrackCollector) keysByBytes(snap *snapshot) []key {
+	keys := make([]key, 0, len(snap.flows))
+	for k := range snap.flows {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		va, vb := snap.flows[a], snap.flows[b]
+		if r := cmp.Compare(vb.SentBytes+vb.ReplyBytes, va.SentBytes+va.ReplyBytes); r != 0 {
+			return r
+		}
+		return slices.Compare(c.labelValues(a), c.labelValues(b))
+	})
+	return keys
+}
+
+// keyWith returns the key with all labels set to value.
+func (c *ConntrackCollector) keyWith(value string) key {
+	var k key
+	for _, d := range c.labels {
+		*d.field(&k) = value
+	}
+	return k
+}
+
+// fold moves the values of keys, and their deltas, into the key into.
+func fold(snap *snapshot, keys []key, into key) {
+	for _, k := range keys {
+		snap.flows[into] = snap.flows[into].merge(snap.flows[k])
+		delete(snap.flows, k)
+		if d, ok := snap.deltas[k]; ok {
+			snap.deltas[into] = snap.deltas[into].merge(d)
+			delete(snap.deltas, k)
+		}
+	}
+}
+
+// merge returns the values of two keys folded together.
+func (v aggValues) merge(o aggValues) aggValues {
+	if v.Entries == 0 || (o.Entries > 0 && o.TimeoutMin < v.TimeoutMin) {
+		v.TimeoutMin = o.TimeoutMin
+	}
+	v.SentPackets += o.SentPackets
+	v.SentBytes += o.SentBytes
+	v.ReplyPackets += o.ReplyPackets
+	v.ReplyBytes += o.ReplyBytes
+	v.Entries += o.Entries
+	v.TimeoutSum += o.TimeoutSum
+	return v
+}
@@ -0,0 +1,7 @@
+package collector
+
+import "go.opentelemetry.io/otel"
+
+// tracer traces the refreshes of the collectors; spans are dropped unless
+// tracing is set up (see tracing.Setup).
+var tracer = otel.Tracer("conntrack-exporter/collector")
@@ -2,64 +2,332 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"conntrack-exporter/internal/collector"
+	"conntrack-exporter/internal/connlabel"
+	"conntrack-exporter/internal/docker"
+	"conntrack-exporter/internal/netns"
+	"conntrack-exporter/internal/ports"
+	"conntrack-exporter/internal/relabel"
 )
 
 // Config holds runtime configuration for the exporter.
 type Config struct {
 	CollectorInterval time.Duration
+	CollectorTimeout  time.Duration
+	CollectorMode     string
+	CollectorBackend  string
+	CollectorEvents   bool
+	CollectorChurn    bool
+	CollectorDuration bool
+	ConnBytes         bool
+	CollectorLabels   stringList
+	CollectorMetrics  stringList
+	ServicesFile      string
+	PortsMappingFile  string
+	AggregateCIDR     string
+	LabelState        bool
+	CollectorTimeouts bool
+	CollectorCounters bool
+	CollectorTopN     int
+	RelabelConfig     string
+	FilterSrcCIDR     stringList
+	FilterDstCIDR     stringList
+	FilterL4Proto     stringList
+	FilterDPort       stringList
+	FilterMinBytes    uint64
+	FilterMinPackets  uint64
+	MaxSeries         int
+	SeriesTTL         time.Duration
+	LabelMark         bool
+	MarkMask          uint64
+	LabelZone         bool
+	CollectorZones    uint16List
+	LabelNAT          bool
+	LabelICMP         bool
+	ExcludeOffloaded  bool
+	LabelConnlabels   bool
+	GeoIPDBs          multiString
+	Sets              multiString
+	KubeServices      bool
+	KubeAPIServer     string
+	KubeInterval      time.Duration
+	SetsInterval      time.Duration
+	DockerContainers  bool
+	DockerSocket      string
+	DockerInterval    time.Duration
+	LabelReply        bool
+	CollectorTuple    string
+	Aggregation       string
+	SPortMode         string
+	LabelScope        bool
+	LabelInterface    bool
+	LabelRDNS         bool
+	RDNSTTL           time.Duration
+	RDNSNegativeTTL   time.Duration
+	RDNSTimeout       time.Duration
+	RDNSConcurrency   int
+	RDNSCacheSize     int
+	ConnlabelFile     string
+	CollectorExpect   bool
+	CollectorStat     bool
+	CollectorLists    bool
+	CollectorNetns    bool
+	NetnsRunDir       string
+	NetnsScanProcs    bool
 	ConfigureAcct     bool
+	ConfigureTstamp   bool
+	ConfigureRestore  bool
+	ConfigureModprobe bool
+	NoWrite           bool
 	ProcfsPath        string
 
-	WebTelemetryPath         string
+	ConstLabels   multiString
+	HostnameLabel bool
+	MetricsPrefix string
+
+	WebTelemetryPath          string
+	WebCollectorsPath         string
 	WebDisableExporterMetrics bool
-	WebMaxRequests           int
-	WebListenAddresses       multiString
+	WebMaxRequests            int
+	WebRateLimit              float64
+	WebRateLimitBurst         int
+	WebFailOnError            bool
+	WebOpenMetrics            bool
+	WebDisableCompression     bool
+	WebConfigFile             string
+	WebBasicAuthUser          string
+	WebBasicAuthPasswordFile  string
+	WebListenAddresses        multiString
+	WebAllowCIDRs             multiString
+	WebCORSOrigins            multiString
+	WebAdminListenAddresses   multiString
+	WebSocketMode             string
+	WebSocketGroup            string
+	WebListenPolicy           string
+	WebReadHeaderTimeout      time.Duration
+	WebReadTimeout            time.Duration
+	WebWriteTimeout           time.Duration
+	WebIdleTimeout            time.Duration
+	WebShutdownTimeout        time.Duration
+	WebEnablePprof            bool
+	WebEnableLifecycle        bool
+
+	DryRun            bool
+	OutputFile        string
+	OutputTextfileDir string
+	PIDFile           string
+
+	TracingEndpoint    string
+	TracingSampleRatio float64
+
+	MemoryLimit int64
+
+	// ConfigFile is the file of --config.file; Relabel and PortsMapping are
+	// its relabel_configs and port_mappings.
+	ConfigFile   string
+	Relabel      []*relabel.Config
+	PortsMapping ports.Mapping
 
 	LogLevel  string
 	LogFormat string
+	LogTarget string
+	// LogRepeatEvery is how often a warning or error repeating identically
+	// is logged, in occurrences (see logging.Logger.SetRepeatEvery).
+	LogRepeatEvery int
 
 	ShowHelp    bool
 	ShowVersion bool
+
+	// Command is the command run (serve, once, dump), and Args its
+	// arguments, parsed again on reload.
+	Command string
+	Args    []string
+}
+
+// ParseFlags parses the CLI flags of command according to AGENTS.md
+// requirements, and the configuration file of --config.file. Invalid flags
+// or files exit with status 2, like the flag package.
+func ParseFlags(command string, args []string) Config {
+	cfg, err := parse(flag.CommandLine, command, args)
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// Parse parses the args of command like ParseFlags, but returns errors
+// instead of exiting: for the check-config command, and to read the
+// configuration file again on reload.
+func Parse(command string, args []string) (Config, error) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return parse(fs, command, args)
 }
 
-// ParseFlags parses CLI flags according to AGENTS.md requirements.
-func ParseFlags() Config {
-	var cfg Config
+// parse defines the flags in fs and parses args, then the configuration
+// file, whose settings apply to the flags not set in args.
+func parse(fs *flag.FlagSet, command string, args []string) (Config, error) {
+	cfg := Config{Command: command, Args: args}
 
 	// Note: We keep flag names identical to the spec; many are compatible
 	// with Prometheus exporter conventions.
 
-	intervalSeconds := flag.Int("collector.interval", 60, "Seconds between collecting info about connections.")
-	flag.BoolVar(&cfg.ConfigureAcct, "configure.nf_conntrack_acct", false, "Set systemctl variable to store packets/bytes counts.")
-	flag.StringVar(&cfg.ProcfsPath, "path.procfs", "/proc", "Procfss mountpoint.")
+	durationVar(fs, &cfg.CollectorInterval, "collector.interval", 60*time.Second, "Time between collecting info about connections (e.g. 30s, 2m; bare integers are seconds).")
+	durationVar(fs, &cfg.CollectorTimeout, "collector.timeout", 0, "Time after which a collection is aborted, keeping the previous values (see conntrack_exporter_collect_timeouts_total). Use 0 for no limit.")
+	fs.StringVar(&cfg.CollectorMode, "collector.mode", "periodic", "When the conntrack table is read. One of: [periodic, scrape] (periodic = every --collector.interval, scrape = on each scrape).")
+	fs.StringVar(&cfg.CollectorBackend, "collector.backend", "procfs", "Source of the conntrack table. One of: [procfs, netlink]")
+	fs.BoolVar(&cfg.CollectorChurn, "collector.churn", false, "Export conntrack_connections_opened_total/closed_total counters by protocol (exact with --collector.events, else from entries appearing/disappearing between snapshots).")
+	fs.BoolVar(&cfg.CollectorDuration, "collector.durations", false, "Export a histogram of connection lifetimes at close time (from nf_conntrack_timestamp, or first-seen snapshot without --collector.events).")
+	fs.BoolVar(&cfg.ConnBytes, "collector.connection-bytes", false, "Export a histogram of the bytes transferred by connections at close time, by l4protocol/l7protocol.")
+	fs.BoolVar(&cfg.CollectorEvents, "collector.events", false, "Subscribe to conntrack events (netlink) to account connections closed between snapshots.")
+	fs.StringVar(&cfg.Aggregation, "collector.aggregation", "", "Aggregation preset selecting the labels of per-connection metrics, instead of --collector.labels. One of: [pair, dst, dport, l7, host].")
+	fs.Var(&cfg.CollectorLabels, "collector.labels", "Comma-separated list of labels of per-connection metrics (e.g. dst,dport,l7protocol). Default: src,dst,l3protocol,l4protocol,l7protocol,dport.")
+	fs.Var(&cfg.CollectorMetrics, "collector.metrics", "Comma-separated list of metric families to export, as glob patterns on names without prefix (e.g. sent_bytes,reply_bytes,total_*), or exclusions prefixed with - (e.g. -*_packets). Default: all.")
+	fs.StringVar(&cfg.AggregateCIDR, "collector.aggregate-cidr", "", "Truncate src/dst addresses to prefixes before using them as labels, e.g. src:/24,dst:/16,src6:/64,dst6:/48.")
+	fs.StringVar(&cfg.ServicesFile, "ports.services-file", ports.DefaultServicesFile, "services(5) file naming ports missing from the built-in l7protocol list. Empty to disable.")
+	fs.StringVar(&cfg.PortsMappingFile, "ports.mapping-file", "", "YAML file mapping ports to l7protocol names (e.g. `9000: minio`), overriding the built-in list. Reloaded on change.")
+	fs.BoolVar(&cfg.LabelState, "collector.label.state", false, "Add the protocol state (ESTABLISHED, TIME_WAIT, ...) as a `state` label to per-connection metrics.")
+	fs.BoolVar(&cfg.CollectorTimeouts, "collector.timeouts", false, "Export min/avg remaining entry timeout per aggregated key.")
+	fs.Var(&cfg.FilterSrcCIDR, "filter.src-cidr", "Only collect entries whose source is in these prefixes; `!` excludes a prefix (e.g. 10.0.0.0/8,!10.1.0.0/16). Repeatable.")
+	fs.Var(&cfg.FilterDstCIDR, "filter.dst-cidr", "Only collect entries whose destination is in these prefixes; `!` excludes a prefix (e.g. !127.0.0.0/8,!fe80::/10). Repeatable.")
+	fs.Var(&cfg.FilterL4Proto, "filter.l4proto", "Only collect entries of these transport protocols (e.g. tcp,udp). Repeatable.")
+	fs.Var(&cfg.FilterDPort, "filter.dport", "Only collect entries with these destination ports or ranges; `!` excludes (e.g. 80,443,1000-2000 or !53,!123). Repeatable.")
+	fs.Uint64Var(&cfg.FilterMinBytes, "filter.min-bytes", 0, "Fold aggregated keys with fewer bytes (both directions) into an `other` key. Use 0 to disable.")
+	fs.Uint64Var(&cfg.FilterMinPackets, "filter.min-packets", 0, "Fold aggregated keys with fewer packets (both directions) into an `other` key. Use 0 to disable.")
+	fs.StringVar(&cfg.RelabelConfig, "collector.relabel-config", "", "YAML file with Prometheus-style relabel rules (replace, keep, drop, lowercase, uppercase) applied to entries before aggregation.")
+	fs.IntVar(&cfg.CollectorTopN, "collector.top-n", 0, "Keep only the N aggregated keys with the most bytes in per-connection metrics and fold the others into an `other` key. Use 0 to disable.")
+	fs.IntVar(&cfg.MaxSeries, "collector.max-series", 0, "Maximum number of series per per-connection metric; keys above it collapse into an `overflow` key. Use 0 to disable.")
+	durationVar(fs, &cfg.SeriesTTL, "collector.series-ttl", 0, "Time after which the series of cumulative per-key counters (--collector.counters, conntrack_closed_*) are deleted when their key is not seen anymore. Use 0 to keep them forever.")
+	fs.BoolVar(&cfg.CollectorCounters, "collector.counters", false, "Export conntrack_*_total counters accumulated from per-connection deltas between snapshots.")
+	fs.BoolVar(&cfg.LabelMark, "collector.label.mark", false, "Add the connection mark as a `mark` label to per-connection metrics.")
+	fs.Uint64Var(&cfg.MarkMask, "collector.mark-mask", 0xffffffff, "Mask applied to the connection mark before it is used as a label (e.g. 0xff00).")
+	fs.BoolVar(&cfg.LabelZone, "collector.label.zone", false, "Add the conntrack zone as a `zone` label to per-connection metrics.")
+	fs.Var(&cfg.CollectorZones, "collector.zones", "Comma-separated list of conntrack zones to collect (e.g. 1,2). Default: all zones.")
+	fs.BoolVar(&cfg.LabelNAT, "collector.label.nat", false, "Add the detected NAT kind (none, snat, dnat, both) as a `nat` label to per-connection metrics.")
+	fs.BoolVar(&cfg.LabelICMP, "collector.label.icmp", false, "Add ICMP type and code as `icmp_type`/`icmp_code` labels to per-connection metrics.")
+	fs.BoolVar(&cfg.ExcludeOffloaded, "collector.exclude-offloaded", false, "Exclude packets/bytes of flowtable-offloaded entries ([OFFLOAD], [HW_OFFLOAD]) from traffic metrics.")
+	fs.BoolVar(&cfg.LabelReply, "collector.label.reply", false, "Add the reply tuple source/destination as `reply_src`/`reply_dst` labels to per-connection metrics.")
+	fs.StringVar(&cfg.CollectorTuple, "collector.tuple", "original", "Tuple used for the src/dst/dport labels. One of: [original, reply] (reply = addresses after NAT).")
+	fs.StringVar(&cfg.SPortMode, "collector.sport-mode", "drop", "Source port handling. One of: [drop, keep, ephemeral-bucket] (keep and ephemeral-bucket add a `sport` label; ephemeral-bucket turns ports >= 32768 into `ephemeral`).")
+	fs.BoolVar(&cfg.LabelScope, "collector.label.scope", false, "Add the address scope of src/dst (rfc1918, ula, link_local, public, ...) as `src_scope`/`dst_scope` labels to per-connection metrics.")
+	fs.BoolVar(&cfg.LabelInterface, "collector.label.interface", false, "Add the interface of the route to dst (from the routing table) as an `interface` label to per-connection metrics.")
+	fs.BoolVar(&cfg.LabelRDNS, "collector.label.rdns", false, "Resolve src/dst addresses with reverse DNS (PTR) lookups and add `src_name`/`dst_name` labels to per-connection metrics.")
+	durationVar(fs, &cfg.RDNSTTL, "collector.rdns.ttl", time.Hour, "Time to cache resolved names.")
+	durationVar(fs, &cfg.RDNSNegativeTTL, "collector.rdns.negative-ttl", 5*time.Minute, "Time to cache failed lookups.")
+	durationVar(fs, &cfg.RDNSTimeout, "collector.rdns.timeout", 2*time.Second, "Timeout of a single reverse DNS lookup.")
+	fs.IntVar(&cfg.RDNSConcurrency, "collector.rdns.concurrency", 8, "Maximum number of reverse DNS lookups in flight.")
+	fs.IntVar(&cfg.RDNSCacheSize, "collector.rdns.cache-size", 10000, "Maximum number of cached addresses.")
+	fs.Var(&cfg.GeoIPDBs, "enrich.geoip-db", "MaxMind database (GeoLite2/GeoIP2 Country, City or ASN mmdb) used to add `dst_country`/`dst_asn` labels for public destinations. Repeatable.")
+	fs.BoolVar(&cfg.KubeServices, "kube.services", false, "Resolve destinations to Kubernetes Services (ClusterIPs and EndpointSlices) and add `service`/`service_namespace` labels.")
+	fs.StringVar(&cfg.KubeAPIServer, "kube.api-server", "", "Kubernetes API server URL, accessed without authentication (e.g. http://127.0.0.1:8001 behind kubectl proxy). Default: in-cluster service account.")
+	fs.Var(&cfg.Sets, "enrich.set", "Firewall set, as ipset:<name> or nft:<family>:<table>:<name>, whose members get its name in a `set` label when they are src or dst. Repeatable.")
+	durationVar(fs, &cfg.SetsInterval, "enrich.set-refresh-interval", time.Minute, "Time between two listings of firewall sets.")
+	durationVar(fs, &cfg.KubeInterval, "kube.refresh-interval", 30*time.Second, "Time to wait before retrying a failed list or watch of Kubernetes Services.")
+	fs.BoolVar(&cfg.DockerContainers, "docker.containers", false, "Resolve src/dst addresses to container names with the Docker Engine API and add `src_container`/`dst_container` labels.")
+	fs.StringVar(&cfg.DockerSocket, "docker.socket", docker.DefaultSocket, "Docker Engine API socket (Podman: /run/podman/podman.sock).")
+	durationVar(fs, &cfg.DockerInterval, "docker.refresh-interval", 30*time.Second, "Time between two refreshes of Docker containers.")
+	fs.BoolVar(&cfg.LabelConnlabels, "collector.label.connlabels", false, "Add the connlabels of the entry as a `connlabels` label to per-connection metrics.")
+	fs.StringVar(&cfg.ConnlabelFile, "collector.connlabel-file", connlabel.DefaultFile, "File mapping connlabel bits to names.")
+	fs.BoolVar(&cfg.CollectorExpect, "collector.expect", false, "Collect the number of conntrack expectations per helper from nf_conntrack_expect.")
+	fs.BoolVar(&cfg.CollectorStat, "collector.stat", false, "Collect per-CPU conntrack statistics (found, invalid, drop, ...) from net/stat/nf_conntrack.")
+	fs.BoolVar(&cfg.CollectorLists, "collector.lists", false, "Collect the sizes of the dying and unconfirmed conntrack lists (over netlink).")
+	fs.BoolVar(&cfg.CollectorNetns, "collector.netns", false, "Collect the conntrack table of every network namespace and add a `netns` label (requires CAP_SYS_ADMIN).")
+	fs.StringVar(&cfg.NetnsRunDir, "collector.netns.run-dir", netns.DefaultRunDir, "Directory with named network namespaces (ip netns).")
+	fs.BoolVar(&cfg.NetnsScanProcs, "collector.netns.procs", false, "Also collect the network namespaces of all processes (/proc/*/ns/net), e.g. containers.")
+	fs.BoolVar(&cfg.ConfigureAcct, "configure.nf_conntrack_acct", false, "Set systemctl variable to store packets/bytes counts.")
+	fs.BoolVar(&cfg.ConfigureTstamp, "configure.nf_conntrack_timestamp", false, "Set sysctl net.netfilter.nf_conntrack_timestamp=1 to record connection start times (needed for age metrics).")
+	fs.BoolVar(&cfg.ConfigureModprobe, "configure.modprobe", false, "Load the nf_conntrack module with modprobe when the conntrack table is missing, retrying with backoff.")
+	fs.BoolVar(&cfg.ConfigureRestore, "configure.restore-on-exit", false, "Restore the sysctls changed by --configure.* to their previous values on shutdown.")
+	fs.BoolVar(&cfg.NoWrite, "no-write", false, "Never write to procfs: fail every sysctl change, and reject --configure.*.")
+	fs.StringVar(&cfg.ProcfsPath, "path.procfs", "/proc", "Procfss mountpoint.")
+
+	fs.Var(&cfg.ConstLabels, "metrics.const-label", "Constant label added to all metrics, as name=value. Repeatable.")
+	fs.StringVar(&cfg.MetricsPrefix, "metrics.prefix", collector.DefaultPrefix, "Prefix of the exporter's metric names (<prefix>_sent_bytes, <prefix>_exporter_*, ...). Empty for none.")
+	fs.BoolVar(&cfg.HostnameLabel, "metrics.hostname", false, "Add a `hostname` label with the host name to all metrics.")
+
+	fs.StringVar(&cfg.WebTelemetryPath, "web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	fs.StringVar(&cfg.WebCollectorsPath, "web.collectors-telemetry-path", "", "Path serving the conntrack metrics instead of --web.telemetry-path, which then only serves the exporter's own metrics (e.g. /metrics/conntrack). Empty to serve all metrics on --web.telemetry-path.")
+	fs.BoolVar(&cfg.WebDisableExporterMetrics, "web.disable-exporter-metrics", false, "Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).")
+	fs.Float64Var(&cfg.WebRateLimit, "web.rate-limit", 0, "Maximum scrape requests per second from each client address, answered with 429 above it. Use 0 to disable.")
+	fs.IntVar(&cfg.WebRateLimitBurst, "web.rate-limit-burst", 5, "Number of scrape requests a client can send at once above --web.rate-limit.")
+	fs.IntVar(&cfg.WebMaxRequests, "web.max-requests", 40, "Maximum number of parallel scrape requests. Use 0 to disable.")
+	fs.BoolVar(&cfg.WebFailOnError, "web.fail-on-collect-error", false, "Fail scrapes with 503 while the last read of the conntrack table failed, instead of serving the last values (see conntrack_up).")
+	fs.BoolVar(&cfg.WebOpenMetrics, "web.openmetrics", true, "Offer the OpenMetrics exposition format (with _created samples for counters) to scrapers that accept it. Use --web.openmetrics=false for the Prometheus text format only.")
+	fs.BoolVar(&cfg.WebDisableCompression, "web.disable-compression", false, "Serve uncompressed responses, even to scrapers accepting gzip.")
+	fs.StringVar(&cfg.WebConfigFile, "web.config.file", "", "Path to a web configuration file (exporter-toolkit format) enabling TLS, client certificate authentication and basic auth.")
+	fs.StringVar(&cfg.WebBasicAuthUser, "web.basic-auth-user", "", "Require HTTP basic auth with this user name (with --web.basic-auth-password-file).")
+	fs.StringVar(&cfg.WebBasicAuthPasswordFile, "web.basic-auth-password-file", "", "Path to a file holding the plain-text password of --web.basic-auth-user.")
+	fs.Var(&cfg.WebListenAddresses, "web.listen-address", "Addresses on which to expose metrics and web interface. Repeatable for multiple addresses. Examples: :9095, [::1]:9095 or unix:///run/conntrack-exporter.sock")
+	fs.BoolVar(&cfg.WebEnableLifecycle, "web.enable-lifecycle", false, "Enable the /-/reload and /-/quit endpoints (POST or PUT). Requires basic auth or --web.admin-listen-address.")
+	fs.BoolVar(&cfg.WebEnablePprof, "web.enable-pprof", false, "Serve Go profiling data (net/http/pprof) under /debug/pprof/. Requires basic auth or --web.admin-listen-address.")
+	fs.StringVar(&cfg.WebSocketMode, "web.socket-mode", "0660", "Permissions (octal) of the Unix domain sockets of --web.listen-address=unix:///path.")
+	fs.StringVar(&cfg.WebSocketGroup, "web.socket-group", "", "Group (name or gid) owning the Unix domain sockets of --web.listen-address (the process group when empty).")
+	fs.StringVar(&cfg.WebListenPolicy, "web.listen-policy", "all", "What to do when a --web.listen-address fails to bind or serve. One of: [all (exit), any (keep serving on the others)]")
+	durationVar(fs, &cfg.WebReadHeaderTimeout, "web.read-header-timeout", 5*time.Second, "Time to read the headers of a request.")
+	durationVar(fs, &cfg.WebReadTimeout, "web.read-timeout", 30*time.Second, "Time to read a whole request. Use 0 for no limit.")
+	durationVar(fs, &cfg.WebWriteTimeout, "web.write-timeout", 0, "Time to handle a request and write the response; keep above the scrape timeout. Use 0 for no limit.")
+	durationVar(fs, &cfg.WebIdleTimeout, "web.idle-timeout", 2*time.Minute, "Time to keep idle keep-alive connections open.")
+	durationVar(fs, &cfg.WebShutdownTimeout, "web.shutdown-timeout", 5*time.Second, "Time in-flight requests get to complete on shutdown before their connections are closed.")
+	fs.Var(&cfg.WebAdminListenAddresses, "web.admin-listen-address", "Addresses on which to serve the operational endpoints (/-/healthy, /-/ready, /debug/pprof/, ...) instead of --web.listen-address. Repeatable.")
+	fs.Var(&cfg.WebCORSOrigins, "web.cors-origin", "Origin of browser applications allowed to read /api/ responses (e.g. https://dashboards.example.com, * for any). Repeatable.")
+	fs.Var(&cfg.WebAllowCIDRs, "web.allow-cidr", "Only serve clients in this prefix (e.g. 10.0.0.0/8), rejecting others with 403. Repeatable.")
+
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "Collect once and print the number of series of each metric family and the label values with the most series, instead of serving metrics.")
+	fs.StringVar(&cfg.OutputFile, "output.file", "", "File the once command writes the metrics to, atomically, instead of stdout (e.g. a .prom file of the node_exporter textfile collector).")
 
-	flag.StringVar(&cfg.WebTelemetryPath, "web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-	flag.BoolVar(&cfg.WebDisableExporterMetrics, "web.disable-exporter-metrics", false, "Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).")
-	flag.IntVar(&cfg.WebMaxRequests, "web.max-requests", 40, "Maximum number of parallel scrape requests. Use 0 to disable.")
-	flag.Var(&cfg.WebListenAddresses, "web.listen-address", "Addresses on which to expose metrics and web interface. Repeatable for multiple addresses. Examples: :9095 or [::1]:9095")
+	fs.StringVar(&cfg.OutputTextfileDir, "output.textfile.directory", "", "Directory in which to also write the metrics to conntrack-exporter.prom every --collector.interval, atomically, for the node_exporter textfile collector. Empty to disable.")
+	fs.StringVar(&cfg.PIDFile, "pid-file", "", "File to write the PID of the exporter to, locked while it runs so that a second instance with the same file fails to start. Empty to disable.")
 
-	flag.StringVar(&cfg.LogLevel, "log.level", "info", "Only log messages with the given severity or above. One of: [debug, info, warn, error]")
-	flag.StringVar(&cfg.LogFormat, "log.format", "logfmt", "Output format of log messages. One of: [logfmt, json]")
+	fs.StringVar(&cfg.TracingEndpoint, "tracing.endpoint", "", "OTLP/HTTP endpoint to export traces of collections and HTTP requests to (e.g. http://localhost:4318). Empty disables tracing.")
+	fs.Float64Var(&cfg.TracingSampleRatio, "tracing.sample-ratio", 1, "Fraction of the traces to sample, between 0 and 1; requests follow the sampling decision of a traced caller.")
+
+	fs.Var((*byteSize)(&cfg.MemoryLimit), "runtime.memory-limit", "Soft memory limit of the exporter (GOMEMLIMIT), e.g. 200MiB; series lose their address labels, then are shed, when it is approached. 0 for none.")
+
+	fs.StringVar(&cfg.ConfigFile, "config.file", "", "YAML configuration file with the settings of flags, relabel rules and port mappings (see README). Flags take precedence over it.")
+
+	fs.StringVar(&cfg.LogLevel, "log.level", "info", "Only log messages with the given severity or above. One of: [debug, info, warn, error], optionally followed by component=level pairs, e.g. info,collector=debug,web=warn. Components: collector, docker, fwset, kube, ports, route, tracing, web.")
+	fs.StringVar(&cfg.LogFormat, "log.format", "logfmt", "Output format of log messages. One of: [logfmt, json]")
+	fs.StringVar(&cfg.LogTarget, "log.target", "stderr", "Where to send log messages. One of: [stderr, syslog, journald]")
+	fs.IntVar(&cfg.LogRepeatEvery, "log.repeat-every", 60, "Log a warning or error repeating identically (e.g. a collection failing every interval) only once every N occurrences, with the number suppressed in between. 1 logs every occurrence.")
 
 	// Help flags (Go's flag package supports -h/-help, but we explicitly provide
 	// -h and --help as requested in AGENTS.md).
-	flag.BoolVar(&cfg.ShowHelp, "h", false, "Show help and exit.")
-	flag.BoolVar(&cfg.ShowHelp, "help", false, "Show help and exit.")
+	fs.BoolVar(&cfg.ShowHelp, "h", false, "Show help and exit.")
+	fs.BoolVar(&cfg.ShowHelp, "help", false, "Show help and exit.")
 
 	// Aliases.
-	flag.BoolVar(&cfg.ShowVersion, "v", false, "Show application version and exit.")
-	flag.BoolVar(&cfg.ShowVersion, "version", false, "Show application version and exit.")
+	fs.BoolVar(&cfg.ShowVersion, "v", false, "Show application version and exit.")
+	fs.BoolVar(&cfg.ShowVersion, "version", false, "Show application version and exit.")
 
-	flag.Parse()
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if cfg.ConfigFile != "" {
+		f, err := LoadFile(cfg.ConfigFile)
+		if err != nil {
+			return cfg, err
+		}
+		if err := f.apply(fs); err != nil {
+			return cfg, fmt.Errorf("%s: %w", cfg.ConfigFile, err)
+		}
+		cfg.Relabel = f.Relabel
+		cfg.PortsMapping = f.PortsMapping
+	}
 
-	cfg.CollectorInterval = time.Duration(*intervalSeconds) * time.Second
 	if len(cfg.WebListenAddresses) == 0 {
 		cfg.WebListenAddresses = append(cfg.WebListenAddresses, ":9095")
 	}
 
-	return cfg
+	return cfg, nil
 }
 
 type multiString []string
@@ -76,3 +344,115 @@ func (m *multiString) Set(value string) error {
 	return nil
 }
 
+// uint16List is a comma-separated list of 16-bit unsigned integers.
+type uint16List []uint16
+
+func (l *uint16List) String() string {
+	if l == nil {
+		return ""
+	}
+	parts := make([]string, len(*l))
+	for i, v := range *l {
+		parts[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *uint16List) Set(value string) error {
+	for _, p := range strings.Split(value, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid value %q: %w", p, err)
+		}
+		*l = append(*l, uint16(n))
+	}
+	return nil
+}
+
+// duration is a time.Duration flag, in the format of time.ParseDuration
+// (e.g. 30s, 2m, 500ms), or a bare integer number of seconds as before.
+type duration time.Duration
+
+// durationVar defines a duration flag.
+func durationVar(fs *flag.FlagSet, p *time.Duration, name string, value time.Duration, usage string) {
+	*p = value
+	fs.Var((*duration)(p), name, usage)
+}
+
+func (d *duration) String() string {
+	if d == nil {
+		return ""
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *duration) Set(value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		n, nerr := strconv.ParseInt(value, 10, 64)
+		if nerr != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		v = time.Duration(n) * time.Second
+	}
+	if v < 0 {
+		return fmt.Errorf("negative duration %q", value)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// byteSize is a number of bytes, with an optional unit in the format of
+// GOMEMLIMIT: B, KiB, MiB, GiB or TiB.
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40}, {"B", 1}}
+
+func (b *byteSize) String() string {
+	if b == nil {
+		return ""
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	num, unit := value, int64(1)
+	for _, u := range byteUnits {
+		if n, ok := strings.CutSuffix(value, u.suffix); ok {
+			num, unit = n, u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * unit)
+	return nil
+}
+
+// stringList is a comma-separated list of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			*l = append(*l, p)
+		}
+	}
+	return nil
+}
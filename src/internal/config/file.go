@@ -0,0 +1,205 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+
+	"conntrack-exporter/internal/ports"
+	"conntrack-exporter/internal/relabel"
+)
+
+// File is a configuration file (--config.file). Its keys are flag names,
+// nested or not, and rules that do not fit flags:
+//
+//	collector:
+//	  interval: 30
+//	  labels: [dst, dport, l7protocol]
+//	filter.dst-cidr: ["!127.0.0.0/8", "!fe80::/10"]
+//	web:
+//	  listen-address: [":9095"]
+//	relabel_configs:
+//	  - source_labels: [dport]
+//	    regex: "80|443"
+//	    action: keep
+//	port_mappings:
+//	  9000: minio
+type File struct {
+	Relabel      []*relabel.Config
+	PortsMapping ports.Mapping
+
+	// settings are the values of flags, by flag name.
+	settings map[string][]string
+}
+
+type rawFile struct {
+	Relabel      []*relabel.Config `yaml:"relabel_configs"`
+	PortsMapping map[string]string `yaml:"port_mappings"`
+	Settings     map[string]value  `yaml:",inline"`
+}
+
+// value is the value of a key: a scalar, kept as written (e.g. 0660), a list,
+// or nested keys.
+type value struct {
+	scalar *string
+	list   []string
+	keys   map[string]value
+}
+
+func (v *value) UnmarshalYAML(unmarshal func(any) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		v.scalar = &s
+		return nil
+	}
+	if err := unmarshal(&v.list); err == nil {
+		return nil
+	}
+	return unmarshal(&v.keys)
+}
+
+// LoadFile reads a configuration file.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw rawFile
+	if err := yaml.UnmarshalStrict(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f := &File{Relabel: raw.Relabel, settings: map[string][]string{}}
+	if err := relabel.Compile(f.Relabel); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, &SettingError{Name: "relabel_configs", Err: err})
+	}
+	if raw.PortsMapping != nil {
+		if f.PortsMapping, err = ports.NewMapping(raw.PortsMapping); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, &SettingError{Name: "port_mappings", Err: err})
+		}
+	}
+	if err := f.flatten("", raw.Settings); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// flatten stores the settings of nested keys under their dotted names.
+func (f *File) flatten(prefix string, keys map[string]value) error {
+	for k, v := range keys {
+		name := prefix + k
+		if v.keys != nil {
+			if err := f.flatten(name+".", v.keys); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := f.settings[name]; ok {
+			return &SettingError{Name: name, Err: errors.New("set twice")}
+		}
+		if v.scalar != nil {
+			f.settings[name] = []string{*v.scalar}
+		} else {
+			f.settings[name] = v.list
+		}
+	}
+	return nil
+}
+
+// commandLineOnly are the flags that cannot be set in the configuration file.
+var commandLineOnly = map[string]bool{"config.file": true, "h": true, "help": true, "v": true, "version": true}
+
+// apply sets the flags of fs from the settings of the file, except the ones
+// set on the command line.
+func (f *File) apply(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	names := make([]string, 0, len(f.settings))
+	for name := range f.settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fl := fs.Lookup(name)
+		if fl == nil || commandLineOnly[name] {
+			return &SettingError{Name: name, Err: errors.New("unknown setting")}
+		}
+		if set[name] {
+			continue
+		}
+		values := f.settings[name]
+		if len(values) != 1 && !repeatable(fl.Value) {
+			return &SettingError{Name: name, Err: fmt.Errorf("expected a single value, got %d", len(values))}
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return &SettingError{Name: name, Err: fmt.Errorf("invalid value %q: %w", v, err)}
+			}
+		}
+	}
+	return nil
+}
+
+// repeatable reports whether a flag takes a list of values.
+func repeatable(v flag.Value) bool {
+	switch v.(type) {
+	case *multiString, *stringList, *uint16List:
+		return true
+	}
+	return false
+}
+
+// SettingError is an invalid setting, named like its flag (or
+// relabel_configs, port_mappings).
+type SettingError struct {
+	Name string
+	Err  error
+}
+
+func (e *SettingError) Error() string { return e.Name + ": " + e.Err.Error() }
+
+func (e *SettingError) Unwrap() error { return e.Err }
+
+var yamlLineRE = regexp.MustCompile(`line (\d+)`)
+
+// Locate returns the line of the configuration file at path where err
+// occurred, and its number: the line of a YAML syntax error, or of the
+// key of a SettingError. It returns 0 when the line is unknown.
+func Locate(path string, err error) (int, string) {
+	data, rerr := os.ReadFile(path)
+	if rerr != nil {
+		return 0, ""
+	}
+	lines := strings.Split(string(data), "\n")
+	if m := yamlLineRE.FindStringSubmatch(err.Error()); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if n >= 1 && n <= len(lines) {
+			return n, lines[n-1]
+		}
+		return 0, ""
+	}
+	var se *SettingError
+	if !errors.As(err, &se) {
+		return 0, ""
+	}
+	// Keys can be nested on any dot (collector: {label.state: true}): look
+	// for the longest suffix of the name used as a key.
+	parts := strings.Split(se.Name, ".")
+	for i := range parts {
+		key := regexp.QuoteMeta(strings.Join(parts[i:], "."))
+		re := regexp.MustCompile(`^\s*["']?` + key + `["']?\s*:`)
+		for n, line := range lines {
+			if re.MatchString(line) {
+				return n + 1, line
+			}
+		}
+	}
+	return 0, ""
+}
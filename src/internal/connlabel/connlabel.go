@@ -0,0 +1,69 @@
+package connlabel
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultFile is the connlabel name mapping used by iptables/nftables.
+const DefaultFile = "/etc/xtables/connlabel.conf"
+
+// Names maps connlabel bit numbers to their names.
+//
+// The file format is the one of `connlabel.conf(5)`: one `<bit> <name>` pair
+// per line, `#` starts a comment.
+//
+//	0	eth0-in
+//	1	eth0-out
+type Names map[int]string
+
+// Load reads a connlabel mapping file.
+func Load(path string) (Names, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := Names{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<bit> <name>\"", path, n)
+		}
+		bit, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil || bit > 127 {
+			return nil, fmt.Errorf("%s:%d: invalid bit %q", path, n, fields[0])
+		}
+		names[int(bit)] = fields[1]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Format renders a set of bits as a comma-separated list of names, in bit
+// order. Bits without a name are rendered as "bit<N>"; an empty set is "none".
+func (n Names) Format(bits []int) string {
+	if len(bits) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(bits))
+	for i, b := range bits {
+		if name, ok := n[b]; ok {
+			parts[i] = name
+		} else {
+			parts[i] = "bit" + strconv.Itoa(b)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,102 @@
+package conntrack
+
+import "strings"
+
+// Expectation is a parsed line from `/proc/net/nf_conntrack_expect`.
+//
+// Expectations are created by conntrack helpers (ftp, sip, tftp, ...) for
+// related connections they expect to see, e.g. the FTP data connection.
+type Expectation struct {
+	// Timeout is the remaining lifetime in seconds; 0 for expectations
+	// without a timer (printed as "-").
+	Timeout uint64
+
+	// Tuple is the expected connection tuple (ports may be 0 = wildcard).
+	Tuple ConntrackTuple
+
+	// Helper is the name of the helper that created the expectation (e.g.
+	// "ftp"), Class its expectation policy name when printed (e.g. "signalling"
+	// for "sip/signalling"). Both are empty if the master has no helper.
+	Helper string
+	Class  string
+
+	Permanent bool
+	Inactive  bool
+	Userspace bool
+}
+
+// ParseExpectLine parses a single line from `/proc/net/nf_conntrack_expect`:
+//
+//	297 l3proto = 2 proto=6 src=10.0.0.1 dst=10.0.0.2 sport=0 dport=41234 ftp
+//	- l3proto = 2 proto=17 src=.. dst=.. sport=0 dport=5060 PERMANENT sip/signalling
+//
+// The flags (PERMANENT,INACTIVE,USERSPACE) and the helper name are optional
+// trailing tokens.
+func ParseExpectLine(line string) (Expectation, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 1 {
+		return Expectation{}, false
+	}
+
+	var x Expectation
+	if n, ok := parseUint64(fields[0]); ok {
+		x.Timeout = n
+	}
+
+	for _, f := range fields[1:] {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			switch k {
+			case "src":
+				x.Tuple.SrcIP = v
+			case "dst":
+				x.Tuple.DstIP = v
+			case "sport":
+				x.Tuple.Sport = v
+			case "dport":
+				x.Tuple.Dport = v
+			}
+			continue
+		}
+
+		// Bare tokens: "l3proto", "=", flags and the helper name.
+		if f == "l3proto" || !isPositional(f) {
+			continue
+		}
+		if isExpectFlags(f) {
+			for _, flag := range strings.Split(f, ",") {
+				switch flag {
+				case "PERMANENT":
+					x.Permanent = true
+				case "INACTIVE":
+					x.Inactive = true
+				case "USERSPACE":
+					x.Userspace = true
+				}
+			}
+			continue
+		}
+		if _, ok := parseUint64(f); ok {
+			// The l3proto number ("l3proto = 2").
+			continue
+		}
+		x.Helper, x.Class, _ = strings.Cut(f, "/")
+	}
+
+	if x.Tuple.SrcIP == "" || x.Tuple.DstIP == "" {
+		return Expectation{}, false
+	}
+	return x, true
+}
+
+// isExpectFlags reports whether tok is a comma-separated list of expectation
+// flags. Helper names are lowercase, flags are uppercase.
+func isExpectFlags(tok string) bool {
+	for _, flag := range strings.Split(tok, ",") {
+		switch flag {
+		case "PERMANENT", "INACTIVE", "USERSPACE":
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,8 @@
 package conntrack
 
 // This package is responsible ONLY for parsing `/proc/net/nf_conntrack` lines
-// into a structured representation that the collector can use.
+// into a structured representation that the collector can use. The same
+// representation is produced by the netlink backend (internal/ctnetlink).
 //
 // IMPORTANT:
 // - Keep this package free from Prometheus dependencies.
@@ -12,31 +13,114 @@ package conntrack
 // In conntrack terminology this is usually the "original" direction and
 // the "reply" direction.
 type DirectionStats struct {
-	Packets uint64
-	Bytes   uint64
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
 }
 
 // ConntrackTuple describes a network tuple inside the conntrack entry.
-// For protocols without ports (e.g. ICMP), Sport/Dport will be empty.
+// For protocols without ports (e.g. ICMP, GRE), Sport/Dport will be empty.
 type ConntrackTuple struct {
-	SrcIP string
-	DstIP string
-	Sport string
-	Dport string
+	SrcIP string `json:"src"`
+	DstIP string `json:"dst"`
+	Sport string `json:"sport,omitempty"`
+	Dport string `json:"dport,omitempty"`
+
+	// ICMP/ICMPv6 only: message type, code and echo identifier.
+	Type string `json:"type,omitempty"`
+	Code string `json:"code,omitempty"`
+	ID   string `json:"id,omitempty"`
+
+	// GRE only: keys (PPTP call ids) in hex, e.g. "0x1a2b".
+	SrcKey string `json:"src_key,omitempty"`
+	DstKey string `json:"dst_key,omitempty"`
 }
 
 // Entry is a parsed representation of a single line from `/proc/net/nf_conntrack`.
 //
 // We store l3/l4 protocol strings as they appear in the file (e.g. "ipv4", "tcp").
 type Entry struct {
-	L3Proto string // e.g. ipv4, ipv6
-	L4Proto string // e.g. tcp, udp, icmp
+	L3Proto string `json:"l3proto"` // e.g. ipv4, ipv6
+	L4Proto string `json:"l4proto"` // e.g. tcp, udp, icmp
+
+	// State is the protocol state as printed by the kernel (e.g. ESTABLISHED,
+	// TIME_WAIT). Empty for stateless protocols.
+	State string `json:"state,omitempty"`
+
+	// Timeout is the remaining lifetime of the entry in seconds.
+	Timeout uint64 `json:"timeout"`
+
+	// Age is the time since the connection was created in seconds
+	// (`delta-time=`). Only set (HasAge) with nf_conntrack_timestamp=1, and
+	// only for connections created after it was enabled.
+	Age    uint64 `json:"age,omitempty"`
+	HasAge bool   `json:"-"`
+
+	Original ConntrackTuple `json:"original"`
+	Reply    ConntrackTuple `json:"reply"`
+
+	OriginalStats DirectionStats `json:"original_stats"`
+	ReplyStats    DirectionStats `json:"reply_stats"`
+
+	// Mark is the connection mark (`mark=`), 0 when absent.
+	Mark uint32 `json:"mark,omitempty"`
+
+	// Assured is set for entries marked [ASSURED] (traffic seen in both
+	// directions, not early-dropped under table pressure).
+	Assured bool `json:"assured,omitempty"`
+	// Unreplied is set for entries marked [UNREPLIED] (no reply seen yet).
+	Unreplied bool `json:"unreplied,omitempty"`
+	// Offload/HWOffload are set for entries offloaded to a flowtable
+	// ([OFFLOAD]) or to hardware ([HW_OFFLOAD]). The kernel stops updating
+	// their counters in software while offloaded.
+	Offload   bool `json:"offload,omitempty"`
+	HWOffload bool `json:"hw_offload,omitempty"`
+
+	// Labels is the connlabel bitmap (`labels=`), nil when absent. Bit N is
+	// bit N%8 of byte N/8 (kernel memory order on little-endian hosts);
+	// base64 in JSON.
+	Labels []byte `json:"labels,omitempty"`
+
+	// Zone is the conntrack zone (`zone=`), 0 (the default zone) when absent.
+	Zone uint16 `json:"zone,omitempty"`
+
+	// Netns is the name of the network namespace the entry was read from.
+	// Only set in multi-namespace mode (collector.NetnsSource).
+	Netns string `json:"netns,omitempty"`
+
+	// ID is the kernel conntrack id. Only the netlink backend provides it;
+	// entries parsed from `/proc/net/nf_conntrack` have ID=0.
+	ID uint32 `json:"id,omitempty"`
+}
+
+// EventType is the kind of change reported by a conntrack event.
+type EventType int
 
-	Original ConntrackTuple
-	Reply    ConntrackTuple
+const (
+	EventNew EventType = iota
+	EventUpdate
+	EventDestroy
+)
 
-	OriginalStats DirectionStats
-	ReplyStats    DirectionStats
+func (t EventType) String() string {
+	switch t {
+	case EventNew:
+		return "new"
+	case EventUpdate:
+		return "update"
+	case EventDestroy:
+		return "destroy"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a single conntrack event (netlink backend only).
+//
+// For EventDestroy, Entry carries the final packets/bytes counters of the
+// connection (when nf_conntrack_acct is enabled).
+type Event struct {
+	Type  EventType
+	Entry Entry
 }
 
 // HasPorts reports whether this entry has L4 ports (sport/dport) in the conntrack file.
@@ -44,3 +128,59 @@ func (e Entry) HasPorts() bool {
 	return e.Original.Dport != "" || e.Original.Sport != ""
 }
 
+// NAT kinds returned by Entry.NAT.
+const (
+	NATNone = "none"
+	NATSrc  = "snat"
+	NATDst  = "dnat"
+	NATBoth = "both"
+)
+
+// NAT detects address/port translation by comparing the original and reply
+// tuples. Without NAT the reply tuple is the original one inverted; SNAT
+// rewrites where replies are sent to, DNAT rewrites where they come from.
+//
+// Entries without a reply tuple are reported as NATNone.
+func (e Entry) NAT() string {
+	if e.Reply.SrcIP == "" || e.Reply.DstIP == "" {
+		return NATNone
+	}
+
+	snat := e.Reply.DstIP != e.Original.SrcIP || e.Reply.Dport != e.Original.Sport
+	dnat := e.Reply.SrcIP != e.Original.DstIP || e.Reply.Sport != e.Original.Dport
+
+	switch {
+	case snat && dnat:
+		return NATBoth
+	case snat:
+		return NATSrc
+	case dnat:
+		return NATDst
+	default:
+		return NATNone
+	}
+}
+
+// IsICMP reports whether the entry is an ICMP or ICMPv6 entry.
+func (e Entry) IsICMP() bool {
+	return e.L4Proto == "icmp" || e.L4Proto == "icmpv6"
+}
+
+// Offloaded reports whether the entry is offloaded (software or hardware).
+func (e Entry) Offloaded() bool {
+	return e.Offload || e.HWOffload
+}
+
+// LabelBits returns the numbers of the connlabel bits set on the entry, in
+// ascending order.
+func (e Entry) LabelBits() []int {
+	var bits []int
+	for i, b := range e.Labels {
+		for j := range 8 {
+			if b&(1<<j) != 0 {
+				bits = append(bits, i*8+j)
+			}
+		}
+	}
+	return bits
+}
@@ -1,139 +1,435 @@
 package conntrack
 
-import (
-	"strconv"
-	"strings"
-)
+import "strings"
 
-// ParseLine parses a single line from `/proc/net/nf_conntrack`.
+// ParseLine parses a single line from `/proc/net/nf_conntrack` (or the legacy
+// `/proc/net/ip_conntrack`, see isLegacy).
 //
 // The format is not a strict key=value-only format. It usually begins with
 // a few positional tokens, then contains repeated key=value tokens:
 //
-//   ipv4 2 tcp 6 431999 ESTABLISHED src=... dst=... sport=... dport=...
-//     packets=... bytes=... src=... dst=... sport=... dport=... packets=... bytes=...
-//     [mark=.. zone=.. use=..]
+//	ipv4 2 tcp 6 431999 ESTABLISHED src=... dst=... sport=... dport=...
+//	  packets=... bytes=... src=... dst=... sport=... dport=... packets=... bytes=...
+//	  [mark=.. zone=.. use=..]
 //
 // We intentionally implement a tolerant parser:
-// - missing packets/bytes (nf_conntrack_acct=0) => counters become 0
-// - protocols without ports (icmp) => sport/dport remain empty
+//   - missing packets/bytes (nf_conntrack_acct=0) => counters become 0
+//   - protocols without ports (icmp) => sport/dport remain empty,
+//     type/code/id are filled instead
+//   - protocols without state (udp, icmp) => State remains empty
+//   - [FLAG] markers are recognized anywhere on the line
 //
 // NOTE: This parser does not attempt to validate IP formats. The collector
 // will treat them as opaque label values.
 func ParseLine(line string) (Entry, bool) {
-	line = strings.TrimSpace(line)
-	if line == "" {
-		return Entry{}, false
+	return parseLine(line)
+}
+
+// ParseLineBytes is ParseLine for a line held in a byte slice, such as
+// bufio.Scanner.Bytes. The line is not converted to a string: only the
+// values kept in the Entry (addresses, ports, ...) are copied, in one
+// allocation per entry (plus the labels bitmap, when present), so the slice
+// may be reused once ParseLineBytes returns. See BenchmarkParseLineBytes.
+func ParseLineBytes(line []byte) (Entry, bool) {
+	return parseLine(line)
+}
+
+// text is a line or token, either as a string or as bytes.
+type text interface {
+	~string | ~[]byte
+}
+
+// tupleCounts counts the occurrences of the repeated tuple keys: the first
+// occurrence belongs to the original tuple, the second to the reply tuple.
+type tupleCounts struct {
+	src, dst, sport, dport int
+	packets, bytes         int
+	typ, code, id          int
+	srckey, dstkey         int
+}
+
+// Tuple keys whose values are kept as strings, see values.
+const (
+	keySrc uint8 = iota
+	keyDst
+	keySport
+	keyDport
+	keyType
+	keyCode
+	keyID
+	keySrcKey
+	keyDstKey
+)
+
+// field returns the tuple field of a key.
+func (t *ConntrackTuple) field(k uint8) *string {
+	switch k {
+	case keySrc:
+		return &t.SrcIP
+	case keyDst:
+		return &t.DstIP
+	case keySport:
+		return &t.Sport
+	case keyDport:
+		return &t.Dport
+	case keyType:
+		return &t.Type
+	case keyCode:
+		return &t.Code
+	case keyID:
+		return &t.ID
+	case keySrcKey:
+		return &t.SrcKey
+	default:
+		return &t.DstKey
 	}
+}
 
-	fields := strings.Fields(line)
-	if len(fields) < 1 {
-		return Entry{}, false
+// values collects the string values of an entry (addresses, ports, ...)
+// while parsing and copies them into a single allocation at the end, instead
+// of one allocation per value. This is the one allocation of parsing an
+// entry; nothing references the line afterwards.
+type values[T text] struct {
+	n     int
+	reply [18]bool  // 9 tuple keys x 2 directions
+	key   [18]uint8 // keyXXX
+	v     [18]T
+}
+
+func (vs *values[T]) add(reply bool, key uint8, v T) {
+	if vs.n < len(vs.v) {
+		vs.reply[vs.n], vs.key[vs.n], vs.v[vs.n] = reply, key, v
+		vs.n++
 	}
+}
 
-	var e Entry
+func (vs *values[T]) flush(e *Entry) {
+	total := 0
+	for _, v := range vs.v[:vs.n] {
+		total += len(v)
+	}
 
-	// Positional tokens: token0 is usually l3 protocol ("ipv4"/"ipv6").
-	e.L3Proto = fields[0]
-	// token2 is usually l4 protocol ("tcp"/"udp"/"icmp"). If unavailable, keep empty.
-	if len(fields) >= 3 {
-		e.L4Proto = fields[2]
+	var b strings.Builder
+	b.Grow(total)
+	for _, v := range vs.v[:vs.n] {
+		for i := 0; i < len(v); i++ {
+			b.WriteByte(v[i])
+		}
 	}
 
-	// Collect occurrences of repeated keys in the order they appear.
+	all, off := b.String(), 0
+	for i, v := range vs.v[:vs.n] {
+		t := &e.Original
+		if vs.reply[i] {
+			t = &e.Reply
+		}
+		*t.field(vs.key[i]) = all[off : off+len(v)]
+		off += len(v)
+	}
+}
+
+// parseLine tokenizes the line in a single pass, without building a slice of
+// fields. The leading tokens are kept aside (they are interpreted once we
+// know whether the line uses the legacy layout), key=value tokens are
+// assigned to the entry as they come.
+func parseLine[T text](line T) (Entry, bool) {
 	var (
-		srcs    []string
-		dsts    []string
-		sports  []string
-		dports  []string
-		packets []uint64
-		bytes   []uint64
+		e     Entry
+		n     tupleCounts
+		vs    values[T]
+		head  [6]T
+		nhead int
 	)
 
-	for _, f := range fields {
-		k, v, ok := strings.Cut(f, "=")
-		if !ok {
-			continue
-		}
-
-		switch k {
-		case "src":
-			srcs = append(srcs, v)
-		case "dst":
-			dsts = append(dsts, v)
-		case "sport":
-			sports = append(sports, v)
-		case "dport":
-			dports = append(dports, v)
-		case "packets":
-			if n, ok := parseUint64(v); ok {
-				packets = append(packets, n)
-			} else {
-				packets = append(packets, 0)
-			}
-		case "bytes":
-			if n, ok := parseUint64(v); ok {
-				bytes = append(bytes, n)
-			} else {
-				bytes = append(bytes, 0)
-			}
+	for i := 0; i < len(line); {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		start := i
+		for i < len(line) && !isSpace(line[i]) {
+			i++
+		}
+		if start == i {
+			break
 		}
+		tok := line[start:i]
+		if nhead < len(head) {
+			head[nhead] = tok
+			nhead++
+		}
+		parseToken(&e, &n, &vs, tok)
+	}
+	if nhead == 0 {
+		return Entry{}, false
 	}
 
-	// Original tuple (first occurrences).
-	if len(srcs) >= 1 {
-		e.Original.SrcIP = srcs[0]
+	// Positional tokens: token0 is usually l3 protocol ("ipv4"/"ipv6").
+	// The legacy `/proc/net/ip_conntrack` (IPv4 only) has no l3 prefix and
+	// starts directly with the l4 protocol, so everything shifts by two.
+	pos := head[:nhead]
+	if isLegacy(pos) {
+		var shifted [8]T
+		copy(shifted[2:], pos)
+		pos = shifted[:nhead+2]
+		e.L3Proto = "ipv4"
+	} else {
+		e.L3Proto = intern(pos[0])
 	}
-	if len(dsts) >= 1 {
-		e.Original.DstIP = dsts[0]
+	// token2 is usually l4 protocol ("tcp"/"udp"/"icmp"). If unavailable, keep empty.
+	if len(pos) >= 3 {
+		e.L4Proto = intern(pos[2])
 	}
-	if len(sports) >= 1 {
-		e.Original.Sport = sports[0]
+	// token4 is the remaining timeout in seconds.
+	if len(pos) >= 5 {
+		if v, ok := parseUint64(pos[4]); ok {
+			e.Timeout = v
+		}
 	}
-	if len(dports) >= 1 {
-		e.Original.Dport = dports[0]
+	// token5 is the protocol state for stateful protocols (tcp, sctp, dccp:
+	// "ESTABLISHED", "TIME_WAIT", ...). Stateless protocols (udp, udplite,
+	// icmp) go straight to src=, gre prints "timeout=N," instead.
+	if len(pos) >= 6 && isPositional(pos[5]) {
+		e.State = intern(pos[5])
 	}
-	if len(packets) >= 1 {
-		e.OriginalStats.Packets = packets[0]
+
+	// We consider an entry valid if it at least has L3 proto and src/dst.
+	if e.L3Proto == "" || n.src == 0 || n.dst == 0 {
+		return Entry{}, false
 	}
-	if len(bytes) >= 1 {
-		e.OriginalStats.Bytes = bytes[0]
+
+	vs.flush(&e)
+	if e.Original.SrcIP == "" || e.Original.DstIP == "" {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// parseToken applies a single [FLAG] or key=value token to the entry.
+// Other tokens are ignored.
+func parseToken[T text](e *Entry, n *tupleCounts, vs *values[T], tok T) {
+	switch string(tok) {
+	case "[ASSURED]":
+		e.Assured = true
+		return
+	case "[UNREPLIED]":
+		e.Unreplied = true
+		return
+	case "[OFFLOAD]":
+		e.Offload = true
+		return
+	case "[HW_OFFLOAD]":
+		e.HWOffload = true
+		return
 	}
 
-	// Reply tuple (second occurrences).
-	if len(srcs) >= 2 {
-		e.Reply.SrcIP = srcs[1]
+	eq := indexByte(tok, '=')
+	if eq < 0 {
+		return
 	}
-	if len(dsts) >= 2 {
-		e.Reply.DstIP = dsts[1]
+	k, v := tok[:eq], tok[eq+1:]
+
+	switch string(k) {
+	case "src":
+		if d, ok := direction(&n.src); ok {
+			vs.add(d, keySrc, v)
+		}
+	case "dst":
+		if d, ok := direction(&n.dst); ok {
+			vs.add(d, keyDst, v)
+		}
+	case "sport":
+		if d, ok := direction(&n.sport); ok {
+			vs.add(d, keySport, v)
+		}
+	case "dport":
+		if d, ok := direction(&n.dport); ok {
+			vs.add(d, keyDport, v)
+		}
+	case "type":
+		if d, ok := direction(&n.typ); ok {
+			vs.add(d, keyType, v)
+		}
+	case "code":
+		if d, ok := direction(&n.code); ok {
+			vs.add(d, keyCode, v)
+		}
+	case "id":
+		if d, ok := direction(&n.id); ok {
+			vs.add(d, keyID, v)
+		}
+	case "srckey":
+		if d, ok := direction(&n.srckey); ok {
+			vs.add(d, keySrcKey, v)
+		}
+	case "dstkey":
+		if d, ok := direction(&n.dstkey); ok {
+			vs.add(d, keyDstKey, v)
+		}
+	case "packets":
+		if s := statsAt(e, &n.packets); s != nil {
+			s.Packets, _ = parseUint64(v)
+		}
+	case "bytes":
+		if s := statsAt(e, &n.bytes); s != nil {
+			s.Bytes, _ = parseUint64(v)
+		}
+	case "mark":
+		if x, ok := parseUint64(v); ok && x <= 0xffffffff {
+			e.Mark = uint32(x)
+		}
+	case "delta-time":
+		if x, ok := parseUint64(v); ok {
+			e.Age, e.HasAge = x, true
+		}
+	case "labels":
+		e.Labels = parseLabels(v)
+	case "zone", "zone-orig":
+		// Direction-specific zones print zone-orig=/zone-reply=; the
+		// original direction identifies the zone for our purposes.
+		if x, ok := parseUint64(v); ok && x <= 0xffff {
+			e.Zone = uint16(x)
+		}
 	}
-	if len(sports) >= 2 {
-		e.Reply.Sport = sports[1]
+}
+
+// direction counts an occurrence of a tuple key and reports the tuple it
+// belongs to: the original tuple (false), then the reply tuple (true).
+// Further occurrences are ignored (ok=false).
+func direction(count *int) (reply, ok bool) {
+	*count++
+	return *count == 2, *count <= 2
+}
+
+// statsAt counts an occurrence of packets=/bytes= and returns the counters it
+// belongs to, like direction.
+func statsAt(e *Entry, count *int) *DirectionStats {
+	*count++
+	switch *count {
+	case 1:
+		return &e.OriginalStats
+	case 2:
+		return &e.ReplyStats
+	default:
+		return nil
 	}
-	if len(dports) >= 2 {
-		e.Reply.Dport = dports[1]
+}
+
+// isLegacy reports whether a line comes from `/proc/net/ip_conntrack`:
+//
+//	tcp      6 431999 ESTABLISHED src=...     (ip_conntrack)
+//	ipv4 2 tcp 6 431999 ESTABLISHED src=...   (nf_conntrack)
+//
+// In the legacy layout the third token is the numeric timeout, while in the
+// nf_conntrack layout it is the l4 protocol name.
+func isLegacy[T text](fields []T) bool {
+	if len(fields) < 3 {
+		return false
 	}
-	if len(packets) >= 2 {
-		e.ReplyStats.Packets = packets[1]
+	_, ok := parseUint64(fields[2])
+	return ok
+}
+
+// isPositional reports whether a token is a bare positional value rather than
+// a key=value pair or a [FLAG] marker.
+func isPositional[T text](tok T) bool {
+	return indexByte(tok, '=') < 0 && (len(tok) == 0 || tok[0] != '[')
+}
+
+// interned holds the frequent positional values (protocols, states).
+// Returning these instead of converting the token saves allocations on
+// every entry.
+var interned = map[string]string{}
+
+func init() {
+	for _, s := range []string{
+		"ipv4", "ipv6", "unknown",
+		"tcp", "udp", "udplite", "icmp", "icmpv6", "sctp", "dccp", "gre",
+		// tcp
+		"NONE", "SYN_SENT", "SYN_RECV", "ESTABLISHED", "FIN_WAIT", "CLOSE_WAIT",
+		"LAST_ACK", "TIME_WAIT", "CLOSE", "SYN_SENT2",
+		// sctp
+		"CLOSED", "COOKIE_WAIT", "COOKIE_ECHOED", "SHUTDOWN_SENT", "SHUTDOWN_RECD",
+		"SHUTDOWN_ACK_SENT", "HEARTBEAT_SENT", "HEARTBEAT_ACKED",
+		// dccp
+		"REQUEST", "RESPOND", "PARTOPEN", "OPEN", "CLOSEREQ", "CLOSING", "TIMEWAIT",
+		"IGNORE", "INVALID",
+	} {
+		interned[s] = s
 	}
-	if len(bytes) >= 2 {
-		e.ReplyStats.Bytes = bytes[1]
+}
+
+func intern[T text](tok T) string {
+	if s, ok := interned[string(tok)]; ok {
+		return s
 	}
+	return string(tok)
+}
 
-	// We consider an entry valid if it at least has L3 proto and src/dst.
-	if e.L3Proto == "" || e.Original.SrcIP == "" || e.Original.DstIP == "" {
-		return Entry{}, false
+// parseLabels decodes the `labels=0x...` bitmap. The kernel prints it byte by
+// byte in memory order, so the decoded bytes need no reordering.
+func parseLabels[T text](v T) []byte {
+	if len(v) >= 2 && v[0] == '0' && v[1] == 'x' {
+		v = v[2:]
+	}
+	out := make([]byte, (len(v)+1)/2)
+	// An odd number of digits is padded with a leading zero.
+	for i, j := len(v)-1, len(out)-1; i >= 0; i, j = i-2, j-1 {
+		lo, ok := unhex(v[i])
+		if !ok {
+			return nil
+		}
+		var hi byte
+		if i > 0 {
+			if hi, ok = unhex(v[i-1]); !ok {
+				return nil
+			}
+		}
+		out[j] = hi<<4 | lo
 	}
+	return out
+}
 
-	return e, true
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
 }
 
-func parseUint64(s string) (uint64, bool) {
-	// conntrack uses base-10 numbers.
-	n, err := strconv.ParseUint(s, 10, 64)
-	if err != nil {
+// parseUint64 parses a base-10 number (conntrack uses base-10 numbers).
+func parseUint64[T text](s T) (uint64, bool) {
+	if len(s) == 0 {
 		return 0, false
 	}
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		d := uint64(c - '0')
+		if n > (1<<64-1-d)/10 {
+			return 0, false // overflow
+		}
+		n = n*10 + d
+	}
 	return n, true
 }
 
+func indexByte[T text](s T, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
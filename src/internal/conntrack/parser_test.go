@@ -0,0 +1,170 @@
+package conntrack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fixtureEntries is the number of lines of the benchmark fixture.
+const fixtureEntries = 500_000
+
+// fixture returns a synthetic nf_conntrack table of fixtureEntries lines,
+// mixing the protocols, flags and optional keys seen on real hosts.
+var fixture = sync.OnceValue(func() []byte {
+	var b bytes.Buffer
+	for i := range fixtureEntries {
+		b.WriteString(fixtureLine(i))
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+})
+
+func fixtureLine(i int) string {
+	src := fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+	dst := fmt.Sprintf("192.168.%d.%d", i>>8&0xff, i&0xff)
+	sport := 1024 + i%60000
+	switch i % 10 {
+	case 0, 1, 2, 3, 4, 5:
+		return fmt.Sprintf("ipv4     2 tcp      6 431999 ESTABLISHED src=%s dst=%s sport=%d dport=443 packets=%d bytes=%d src=%s dst=%s sport=443 dport=%d packets=%d bytes=%d [ASSURED] mark=%d zone=0 delta-time=%d use=1",
+			src, dst, sport, i%1000, i*64, dst, src, sport, i%900, i*128, i%4, i%3600)
+	case 6, 7:
+		return fmt.Sprintf("ipv4     2 udp      17 29 src=%s dst=%s sport=%d dport=53 packets=1 bytes=60 src=%s dst=%s sport=53 dport=%d packets=1 bytes=120 mark=0 zone=0 use=2",
+			src, dst, sport, dst, src, sport)
+	case 8:
+		return fmt.Sprintf("ipv4     2 icmp     1 29 src=%s dst=%s type=8 code=0 id=%d packets=1 bytes=84 [UNREPLIED] src=%s dst=%s type=0 code=0 id=%d packets=0 bytes=0 mark=0 zone=0 use=2",
+			src, dst, i%65536, dst, src, i%65536)
+	default:
+		return fmt.Sprintf("ipv6     10 tcp      6 117 TIME_WAIT src=fd00::%x dst=fd00::1 sport=%d dport=80 packets=10 bytes=900 src=fd00::1 dst=fd00::%x sport=80 dport=%d packets=8 bytes=4000 [ASSURED] mark=0 labels=0x%x zone=0 use=1",
+			i&0xffff, sport, i&0xffff, sport, i%256)
+	}
+}
+
+func TestParseLineBytesMatchesParseLine(t *testing.T) {
+	lines := []string{
+		"",
+		"garbage",
+		"ipv4 2 tcp 6 10 ESTABLISHED",
+		"tcp      6 431999 ESTABLISHED src=10.0.0.1 dst=10.0.0.2 sport=1 dport=2 src=10.0.0.2 dst=10.0.0.1 sport=2 dport=1 [ASSURED] mark=0 use=1",
+	}
+	for i := range 1000 {
+		lines = append(lines, fixtureLine(i))
+	}
+	for _, line := range lines {
+		want, wantOK := ParseLine(line)
+		got, gotOK := ParseLineBytes([]byte(line))
+		if gotOK != wantOK || !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseLineBytes(%q) = %+v, %v; ParseLine = %+v, %v", line, got, gotOK, want, wantOK)
+		}
+	}
+}
+
+// BenchmarkParseLine and BenchmarkParseLineBytes read the fixture like the
+// procfs source, with bufio.Scanner; an op is one line.
+func BenchmarkParseLine(b *testing.B) {
+	data := fixture()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for n < b.N && sc.Scan() {
+			if _, ok := ParseLine(sc.Text()); !ok {
+				b.Fatalf("failed to parse %q", sc.Text())
+			}
+			n++
+		}
+	}
+}
+
+func BenchmarkParseLineBytes(b *testing.B) {
+	data := fixture()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for n < b.N && sc.Scan() {
+			if _, ok := ParseLineBytes(sc.Bytes()); !ok {
+				b.Fatalf("failed to parse %q", sc.Bytes())
+			}
+			n++
+		}
+	}
+}
+
+func TestParseLineProtocols(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Entry
+	}{
+		{
+			name: "sctp",
+			line: "ipv4     2 sctp     132 209 ESTABLISHED src=10.0.0.1 dst=10.0.0.2 sport=5000 dport=38412 packets=3 bytes=300 src=10.0.0.2 dst=10.0.0.1 sport=38412 dport=5000 packets=2 bytes=200 [ASSURED] mark=0 zone=0 use=1",
+			want: Entry{
+				L3Proto: "ipv4", L4Proto: "sctp", State: "ESTABLISHED", Timeout: 209,
+				Original:      ConntrackTuple{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", Sport: "5000", Dport: "38412"},
+				Reply:         ConntrackTuple{SrcIP: "10.0.0.2", DstIP: "10.0.0.1", Sport: "38412", Dport: "5000"},
+				OriginalStats: DirectionStats{Packets: 3, Bytes: 300},
+				ReplyStats:    DirectionStats{Packets: 2, Bytes: 200},
+				Assured:       true,
+			},
+		},
+		{
+			name: "sctp state",
+			line: "ipv4     2 sctp     132 3 COOKIE_WAIT src=10.0.0.1 dst=10.0.0.2 sport=5000 dport=38412 [UNREPLIED] src=10.0.0.2 dst=10.0.0.1 sport=38412 dport=5000 mark=0 zone=0 use=1",
+			want: Entry{
+				L3Proto: "ipv4", L4Proto: "sctp", State: "COOKIE_WAIT", Timeout: 3,
+				Original:  ConntrackTuple{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", Sport: "5000", Dport: "38412"},
+				Reply:     ConntrackTuple{SrcIP: "10.0.0.2", DstIP: "10.0.0.1", Sport: "38412", Dport: "5000"},
+				Unreplied: true,
+			},
+		},
+		{
+			name: "dccp",
+			line: "ipv6     10 dccp     33 43199 PARTOPEN src=fd00::1 dst=fd00::2 sport=40000 dport=5004 src=fd00::2 dst=fd00::1 sport=5004 dport=40000 [ASSURED] mark=0 zone=0 use=1",
+			want: Entry{
+				L3Proto: "ipv6", L4Proto: "dccp", State: "PARTOPEN", Timeout: 43199,
+				Original: ConntrackTuple{SrcIP: "fd00::1", DstIP: "fd00::2", Sport: "40000", Dport: "5004"},
+				Reply:    ConntrackTuple{SrcIP: "fd00::2", DstIP: "fd00::1", Sport: "5004", Dport: "40000"},
+				Assured:  true,
+			},
+		},
+		{
+			name: "gre",
+			line: "ipv4     2 gre      47 178 timeout=180, stream_timeout=180 src=10.0.0.1 dst=10.0.0.2 srckey=0x1a2b dstkey=0x0 src=10.0.0.2 dst=10.0.0.1 srckey=0x0 dstkey=0x1a2b [ASSURED] mark=0 zone=0 use=1",
+			want: Entry{
+				L3Proto: "ipv4", L4Proto: "gre", Timeout: 178,
+				Original: ConntrackTuple{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", SrcKey: "0x1a2b", DstKey: "0x0"},
+				Reply:    ConntrackTuple{SrcIP: "10.0.0.2", DstIP: "10.0.0.1", SrcKey: "0x0", DstKey: "0x1a2b"},
+				Assured:  true,
+			},
+		},
+		{
+			name: "udplite",
+			line: "ipv4     2 udplite  136 29 src=10.0.0.1 dst=10.0.0.2 sport=1234 dport=5678 packets=1 bytes=50 src=10.0.0.2 dst=10.0.0.1 sport=5678 dport=1234 packets=0 bytes=0 mark=0 zone=0 use=2",
+			want: Entry{
+				L3Proto: "ipv4", L4Proto: "udplite", Timeout: 29,
+				Original:      ConntrackTuple{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", Sport: "1234", Dport: "5678"},
+				Reply:         ConntrackTuple{SrcIP: "10.0.0.2", DstIP: "10.0.0.1", Sport: "5678", Dport: "1234"},
+				OriginalStats: DirectionStats{Packets: 1, Bytes: 50},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseLine(tt.line)
+			if !ok {
+				t.Fatalf("ParseLine(%q) failed", tt.line)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLine(%q)\n got %+v\nwant %+v", tt.line, got, tt.want)
+			}
+			if got.L4Proto == "gre" && got.HasPorts() {
+				t.Errorf("gre entry has ports: %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package conntrack
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// CPUStat holds the counters of one row of `/proc/net/stat/nf_conntrack`,
+// keyed by column name (e.g. "found", "insert_failed").
+type CPUStat map[string]uint64
+
+// ParseStat parses `/proc/net/stat/nf_conntrack`:
+//
+//	entries  clashres found new invalid ignore delete ... search_restart
+//	00000031  00000000 00000000 00000000 0000002a 00000000 00000000 ...
+//	00000031  00000000 00000000 00000000 00000013 00000000 00000000 ...
+//
+// The first line names the columns (the set varies by kernel version), every
+// following line holds the hex counters of one CPU, in CPU order.
+func ParseStat(raw []byte) ([]CPUStat, error) {
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty conntrack stat file")
+	}
+	columns := strings.Fields(sc.Text())
+
+	var out []CPUStat
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		row := make(CPUStat, len(columns))
+		for i, f := range fields {
+			if i >= len(columns) {
+				break
+			}
+			n, err := strconv.ParseUint(f, 16, 64)
+			if err != nil {
+				return nil, errors.New("invalid conntrack stat value " + strconv.Quote(f))
+			}
+			row[columns[i]] = n
+		}
+		out = append(out, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,398 @@
+package ctnetlink
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// Conntrack attribute types (linux/netfilter/nfnetlink_conntrack.h).
+const (
+	ctaTupleOrig     = 1
+	ctaTupleReply    = 2
+	ctaStatus        = 3
+	ctaProtoinfo     = 4
+	ctaTimeout       = 7
+	ctaMark          = 8
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+	ctaID            = 12
+	ctaZone          = 18
+	ctaTimestamp     = 20
+	ctaLabels        = 22
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum        = 1
+	ctaProtoSrcPort    = 2
+	ctaProtoDstPort    = 3
+	ctaProtoICMPID     = 4
+	ctaProtoICMPType   = 5
+	ctaProtoICMPCode   = 6
+	ctaProtoICMPv6ID   = 7
+	ctaProtoICMPv6Type = 8
+	ctaProtoICMPv6Code = 9
+
+	ctaProtoinfoTCP       = 1
+	ctaProtoinfoDCCP      = 2
+	ctaProtoinfoSCTP      = 3
+	ctaProtoinfoTCPState  = 1
+	ctaProtoinfoDCCPState = 1
+	ctaProtoinfoSCTPState = 1
+
+	// Status bits (enum ip_conntrack_status).
+	ipsSeenReply = 1 << 1
+	ipsAssured   = 1 << 2
+	ipsOffload   = 1 << 14
+	ipsHWOffload = 1 << 15
+
+	ctaTimestampStart = 1
+
+	ctaCountersPackets   = 1
+	ctaCountersBytes     = 2
+	ctaCounters32Packets = 3
+	ctaCounters32Bytes   = 4
+)
+
+// Source reads the conntrack table over netlink. It satisfies the
+// collector's Source interface.
+type Source struct{}
+
+// Entries dumps the conntrack table and calls fn for every entry.
+//
+// A fresh socket is used for every dump: collections are infrequent and this
+// keeps Source free of state that would need locking.
+func (Source) Entries(ctx context.Context, fn func(conntrack.Entry)) error {
+	c, err := Dial(0)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.DumpConntrack(ctx, fn)
+}
+
+// DumpConntrack dumps all conntrack entries (all L3 families), until ctx is
+// done.
+func (c *Conn) DumpConntrack(ctx context.Context, fn func(conntrack.Entry)) error {
+	return c.dump(ctx, nfnlSubsysCTNetlink, ipctnlMsgCtGet, syscall.AF_UNSPEC, func(m syscall.NetlinkMessage) error {
+		e, ok, err := parseEntry(m.Data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			fn(e)
+		}
+		return nil
+	})
+}
+
+// parseEntry converts a ctnetlink message payload (nfgenmsg + attributes)
+// into a conntrack.Entry, matching what conntrack.ParseLine would produce
+// for the same connection.
+func parseEntry(b []byte) (conntrack.Entry, bool, error) {
+	if len(b) < sizeofNfgenmsg {
+		return conntrack.Entry{}, false, nil
+	}
+
+	var e conntrack.Entry
+	switch b[0] {
+	case syscall.AF_INET:
+		e.L3Proto = "ipv4"
+	case syscall.AF_INET6:
+		e.L3Proto = "ipv6"
+	default:
+		e.L3Proto = "unknown"
+	}
+
+	attrs, err := parseAttrs(b[sizeofNfgenmsg:])
+	if err != nil {
+		return conntrack.Entry{}, false, err
+	}
+
+	for _, a := range attrs {
+		switch a.typ {
+		case ctaTupleOrig:
+			l4, err := parseTuple(a.data, &e.Original)
+			if err != nil {
+				return conntrack.Entry{}, false, err
+			}
+			e.L4Proto = l4
+		case ctaTupleReply:
+			if _, err := parseTuple(a.data, &e.Reply); err != nil {
+				return conntrack.Entry{}, false, err
+			}
+		case ctaStatus:
+			status := be32(a.data)
+			e.Assured = status&ipsAssured != 0
+			e.Unreplied = status&ipsSeenReply == 0
+			// Mirror /proc, which prints either [HW_OFFLOAD] or [OFFLOAD].
+			e.HWOffload = status&ipsHWOffload != 0
+			e.Offload = !e.HWOffload && status&ipsOffload != 0
+		case ctaProtoinfo:
+			state, err := parseProtoinfo(a.data)
+			if err != nil {
+				return conntrack.Entry{}, false, err
+			}
+			e.State = state
+		case ctaTimeout:
+			e.Timeout = uint64(be32(a.data))
+		case ctaMark:
+			e.Mark = be32(a.data)
+		case ctaCountersOrig:
+			if err := parseCounters(a.data, &e.OriginalStats); err != nil {
+				return conntrack.Entry{}, false, err
+			}
+		case ctaCountersReply:
+			if err := parseCounters(a.data, &e.ReplyStats); err != nil {
+				return conntrack.Entry{}, false, err
+			}
+		case ctaID:
+			e.ID = be32(a.data)
+		case ctaZone:
+			e.Zone = be16(a.data)
+		case ctaTimestamp:
+			if err := parseTimestamp(a.data, &e); err != nil {
+				return conntrack.Entry{}, false, err
+			}
+		case ctaLabels:
+			e.Labels = append([]byte(nil), a.data...)
+		}
+	}
+
+	// Same validity rule as conntrack.ParseLine.
+	if e.Original.SrcIP == "" || e.Original.DstIP == "" {
+		return conntrack.Entry{}, false, nil
+	}
+	return e, true, nil
+}
+
+// parseTuple fills t from a CTA_TUPLE_* nest and returns the L4 protocol name.
+func parseTuple(b []byte, t *conntrack.ConntrackTuple) (string, error) {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return "", err
+	}
+
+	var l4 string
+	for _, a := range attrs {
+		switch a.typ {
+		case ctaTupleIP:
+			ips, err := parseAttrs(a.data)
+			if err != nil {
+				return "", err
+			}
+			for _, ip := range ips {
+				switch ip.typ {
+				case ctaIPv4Src, ctaIPv6Src:
+					t.SrcIP = ipString(ip.data)
+				case ctaIPv4Dst, ctaIPv6Dst:
+					t.DstIP = ipString(ip.data)
+				}
+			}
+		case ctaTupleProto:
+			protos, err := parseAttrs(a.data)
+			if err != nil {
+				return "", err
+			}
+			for _, p := range protos {
+				switch p.typ {
+				case ctaProtoNum:
+					if len(p.data) >= 1 {
+						l4 = L4ProtoName(p.data[0])
+					}
+				case ctaProtoSrcPort:
+					// GRE reuses the port attributes for its keys.
+					if l4 == "gre" {
+						t.SrcKey = "0x" + strconv.FormatUint(uint64(be16(p.data)), 16)
+					} else {
+						t.Sport = strconv.Itoa(int(be16(p.data)))
+					}
+				case ctaProtoDstPort:
+					if l4 == "gre" {
+						t.DstKey = "0x" + strconv.FormatUint(uint64(be16(p.data)), 16)
+					} else {
+						t.Dport = strconv.Itoa(int(be16(p.data)))
+					}
+				case ctaProtoICMPID, ctaProtoICMPv6ID:
+					t.ID = strconv.Itoa(int(be16(p.data)))
+				case ctaProtoICMPType, ctaProtoICMPv6Type:
+					if len(p.data) >= 1 {
+						t.Type = strconv.Itoa(int(p.data[0]))
+					}
+				case ctaProtoICMPCode, ctaProtoICMPv6Code:
+					if len(p.data) >= 1 {
+						t.Code = strconv.Itoa(int(p.data[0]))
+					}
+				}
+			}
+		}
+	}
+	return l4, nil
+}
+
+// tcpStates are the TCP state names as printed in `/proc/net/nf_conntrack`
+// (tcp_conntrack_names in the kernel), indexed by enum tcp_conntrack.
+var tcpStates = []string{
+	"NONE",
+	"SYN_SENT",
+	"SYN_RECV",
+	"ESTABLISHED",
+	"FIN_WAIT",
+	"CLOSE_WAIT",
+	"LAST_ACK",
+	"TIME_WAIT",
+	"CLOSE",
+	"SYN_SENT2",
+}
+
+// sctpStates are the SCTP state names (sctp_conntrack_names).
+var sctpStates = []string{
+	"NONE",
+	"CLOSED",
+	"COOKIE_WAIT",
+	"COOKIE_ECHOED",
+	"ESTABLISHED",
+	"SHUTDOWN_SENT",
+	"SHUTDOWN_RECD",
+	"SHUTDOWN_ACK_SENT",
+	"HEARTBEAT_SENT",
+	"HEARTBEAT_ACKED",
+}
+
+// dccpStates are the DCCP state names (dccp_state_names).
+var dccpStates = []string{
+	"NONE",
+	"REQUEST",
+	"RESPOND",
+	"PARTOPEN",
+	"OPEN",
+	"CLOSEREQ",
+	"CLOSING",
+	"TIMEWAIT",
+	"IGNORE",
+	"INVALID",
+}
+
+// parseProtoinfo extracts the protocol state from a CTA_PROTOINFO nest.
+func parseProtoinfo(b []byte) (string, error) {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range attrs {
+		var (
+			stateAttr uint16
+			names     []string
+		)
+		switch a.typ {
+		case ctaProtoinfoTCP:
+			stateAttr, names = ctaProtoinfoTCPState, tcpStates
+		case ctaProtoinfoSCTP:
+			stateAttr, names = ctaProtoinfoSCTPState, sctpStates
+		case ctaProtoinfoDCCP:
+			stateAttr, names = ctaProtoinfoDCCPState, dccpStates
+		default:
+			continue
+		}
+
+		infos, err := parseAttrs(a.data)
+		if err != nil {
+			return "", err
+		}
+		for _, i := range infos {
+			if i.typ == stateAttr && len(i.data) >= 1 {
+				return stateName(names, i.data[0]), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func stateName(names []string, v uint8) string {
+	if int(v) < len(names) {
+		return names[v]
+	}
+	return "UNKNOWN"
+}
+
+func parseCounters(b []byte, s *conntrack.DirectionStats) error {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		switch a.typ {
+		case ctaCountersPackets:
+			s.Packets = be64(a.data)
+		case ctaCountersBytes:
+			s.Bytes = be64(a.data)
+		case ctaCounters32Packets:
+			s.Packets = uint64(be32(a.data))
+		case ctaCounters32Bytes:
+			s.Bytes = uint64(be32(a.data))
+		}
+	}
+	return nil
+}
+
+// parseTimestamp sets the entry age from a CTA_TIMESTAMP nest.
+func parseTimestamp(b []byte, e *conntrack.Entry) error {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		if a.typ != ctaTimestampStart {
+			continue
+		}
+		start := time.Unix(0, int64(be64(a.data)))
+		if age := time.Since(start); age > 0 {
+			e.Age = uint64(age / time.Second)
+		}
+		e.HasAge = true
+	}
+	return nil
+}
+
+func ipString(b []byte) string {
+	addr, ok := netip.AddrFromSlice(b)
+	if !ok {
+		return ""
+	}
+	return addr.String()
+}
+
+// L4ProtoName returns the protocol name as printed in `/proc/net/nf_conntrack`.
+func L4ProtoName(num uint8) string {
+	switch num {
+	case syscall.IPPROTO_ICMP:
+		return "icmp"
+	case syscall.IPPROTO_TCP:
+		return "tcp"
+	case syscall.IPPROTO_UDP:
+		return "udp"
+	case syscall.IPPROTO_DCCP:
+		return "dccp"
+	case syscall.IPPROTO_GRE:
+		return "gre"
+	case syscall.IPPROTO_ICMPV6:
+		return "icmpv6"
+	case syscall.IPPROTO_SCTP:
+		return "sctp"
+	case syscall.IPPROTO_UDPLITE:
+		return "udplite"
+	default:
+		return "unknown"
+	}
+}
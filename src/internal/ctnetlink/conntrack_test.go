@@ -0,0 +1,152 @@
+package ctnetlink
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// nla encodes a netlink attribute; nested attributes are passed as data.
+func nla(typ uint16, data ...[]byte) []byte {
+	var payload []byte
+	for _, d := range data {
+		payload = append(payload, d...)
+	}
+	b := make([]byte, syscall.NLA_HDRLEN, syscall.NLA_HDRLEN+len(payload)+syscall.NLA_ALIGNTO)
+	binary.NativeEndian.PutUint16(b[0:2], uint16(syscall.NLA_HDRLEN+len(payload)))
+	binary.NativeEndian.PutUint16(b[2:4], typ)
+	b = append(b, payload...)
+	for len(b)%syscall.NLA_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func u8(v uint8) []byte { return []byte{v} }
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// tuple encodes a CTA_TUPLE_* nest; sport and dport are the GRE keys for gre.
+func tuple(typ uint16, src, dst string, proto uint8, sport, dport uint16) []byte {
+	s, d := netip.MustParseAddr(src), netip.MustParseAddr(dst)
+	srcAttr, dstAttr := uint16(ctaIPv4Src), uint16(ctaIPv4Dst)
+	if s.Is6() {
+		srcAttr, dstAttr = ctaIPv6Src, ctaIPv6Dst
+	}
+	return nla(typ,
+		nla(ctaTupleIP, nla(srcAttr, s.AsSlice()), nla(dstAttr, d.AsSlice())),
+		nla(ctaTupleProto, nla(ctaProtoNum, u8(proto)), nla(ctaProtoSrcPort, u16(sport)), nla(ctaProtoDstPort, u16(dport))),
+	)
+}
+
+// message encodes a ctnetlink conntrack message payload.
+func message(family uint8, attrs ...[]byte) []byte {
+	b := []byte{family, 0, 0, 0} // nfgenmsg
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	return b
+}
+
+// TestParseEntryProtocols checks that the entries of the protocols with
+// their own layout match those parsed from `/proc/net/nf_conntrack`.
+func TestParseEntryProtocols(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		line string
+	}{
+		{
+			name: "sctp",
+			msg: message(syscall.AF_INET,
+				tuple(ctaTupleOrig, "10.0.0.1", "10.0.0.2", syscall.IPPROTO_SCTP, 5000, 38412),
+				tuple(ctaTupleReply, "10.0.0.2", "10.0.0.1", syscall.IPPROTO_SCTP, 38412, 5000),
+				nla(ctaStatus, u32(ipsSeenReply|ipsAssured)),
+				nla(ctaProtoinfo, nla(ctaProtoinfoSCTP, nla(ctaProtoinfoSCTPState, u8(5)))),
+				nla(ctaTimeout, u32(209)),
+			),
+			line: "ipv4     2 sctp     132 209 SHUTDOWN_SENT src=10.0.0.1 dst=10.0.0.2 sport=5000 dport=38412 src=10.0.0.2 dst=10.0.0.1 sport=38412 dport=5000 [ASSURED] mark=0 use=1",
+		},
+		{
+			name: "dccp",
+			msg: message(syscall.AF_INET6,
+				tuple(ctaTupleOrig, "fd00::1", "fd00::2", syscall.IPPROTO_DCCP, 40000, 5004),
+				tuple(ctaTupleReply, "fd00::2", "fd00::1", syscall.IPPROTO_DCCP, 5004, 40000),
+				nla(ctaStatus, u32(ipsSeenReply|ipsAssured)),
+				nla(ctaProtoinfo, nla(ctaProtoinfoDCCP, nla(ctaProtoinfoDCCPState, u8(3)))),
+				nla(ctaTimeout, u32(43199)),
+			),
+			line: "ipv6     10 dccp     33 43199 PARTOPEN src=fd00::1 dst=fd00::2 sport=40000 dport=5004 src=fd00::2 dst=fd00::1 sport=5004 dport=40000 [ASSURED] mark=0 use=1",
+		},
+		{
+			name: "gre",
+			msg: message(syscall.AF_INET,
+				tuple(ctaTupleOrig, "10.0.0.1", "10.0.0.2", syscall.IPPROTO_GRE, 0x1a2b, 0),
+				tuple(ctaTupleReply, "10.0.0.2", "10.0.0.1", syscall.IPPROTO_GRE, 0, 0x1a2b),
+				nla(ctaStatus, u32(ipsSeenReply|ipsAssured)),
+				nla(ctaTimeout, u32(178)),
+			),
+			line: "ipv4     2 gre      47 178 timeout=180, stream_timeout=180 src=10.0.0.1 dst=10.0.0.2 srckey=0x1a2b dstkey=0x0 src=10.0.0.2 dst=10.0.0.1 srckey=0x0 dstkey=0x1a2b [ASSURED] mark=0 use=1",
+		},
+		{
+			name: "udplite",
+			msg: message(syscall.AF_INET,
+				tuple(ctaTupleOrig, "10.0.0.1", "10.0.0.2", syscall.IPPROTO_UDPLITE, 1234, 5678),
+				tuple(ctaTupleReply, "10.0.0.2", "10.0.0.1", syscall.IPPROTO_UDPLITE, 5678, 1234),
+				nla(ctaStatus, u32(0)),
+				nla(ctaTimeout, u32(29)),
+			),
+			line: "ipv4     2 udplite  136 29 src=10.0.0.1 dst=10.0.0.2 sport=1234 dport=5678 [UNREPLIED] src=10.0.0.2 dst=10.0.0.1 sport=5678 dport=1234 mark=0 use=2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := parseEntry(tt.msg)
+			if err != nil || !ok {
+				t.Fatalf("parseEntry() = %v, %v", ok, err)
+			}
+			want, ok := conntrack.ParseLine(tt.line)
+			if !ok {
+				t.Fatalf("ParseLine(%q) failed", tt.line)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parseEntry()\n got %+v\nwant %+v (%q)", got, want, tt.line)
+			}
+			if got.L4Proto == "gre" && got.HasPorts() {
+				t.Errorf("gre entry has ports: %+v", got)
+			}
+		})
+	}
+}
+
+func TestParseProtoinfo(t *testing.T) {
+	tests := []struct {
+		name string
+		info []byte
+		want string
+	}{
+		{"tcp", nla(ctaProtoinfoTCP, nla(ctaProtoinfoTCPState, u8(3))), "ESTABLISHED"},
+		{"sctp", nla(ctaProtoinfoSCTP, nla(ctaProtoinfoSCTPState, u8(2))), "COOKIE_WAIT"},
+		{"sctp heartbeat", nla(ctaProtoinfoSCTP, nla(ctaProtoinfoSCTPState, u8(9))), "HEARTBEAT_ACKED"},
+		{"dccp", nla(ctaProtoinfoDCCP, nla(ctaProtoinfoDCCPState, u8(7))), "TIMEWAIT"},
+		{"dccp out of range", nla(ctaProtoinfoDCCP, nla(ctaProtoinfoDCCPState, u8(42))), "UNKNOWN"},
+		{"no state", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProtoinfo(tt.info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseProtoinfo() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package ctnetlink
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// Conntrack multicast groups (enum nfnetlink_groups).
+const (
+	nfnlgrpConntrackNew     = 1
+	nfnlgrpConntrackUpdate  = 2
+	nfnlgrpConntrackDestroy = 3
+)
+
+// eventRcvBuf is the receive buffer we ask for on event sockets. Event bursts
+// (e.g. mass expiry) easily overflow the default socket buffer.
+const eventRcvBuf = 8 * 1024 * 1024
+
+// EventSource subscribes to conntrack NEW and DESTROY events. It satisfies the
+// collector's EventSource interface.
+type EventSource struct{}
+
+// Events blocks, calling fn for every NEW/DESTROY event until ctx is done or
+// the socket fails. Buffer overruns are reported through lost and do not
+// stop the subscription.
+func (EventSource) Events(ctx context.Context, fn func(conntrack.Event), lost func()) error {
+	c, err := Dial(1<<(nfnlgrpConntrackNew-1) | 1<<(nfnlgrpConntrackDestroy-1))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := syscall.SetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, eventRcvBuf); err != nil {
+		// Needs CAP_NET_ADMIN; fall back to the (capped) unprivileged option.
+		_ = syscall.SetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, eventRcvBuf)
+	}
+
+	// Wake up periodically so ctx cancellation is noticed.
+	tv := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n, _, err := syscall.Recvfrom(c.fd, c.buf, 0)
+		if err != nil {
+			switch err {
+			case syscall.EAGAIN, syscall.EINTR:
+				continue
+			case syscall.ENOBUFS:
+				if lost != nil {
+					lost()
+				}
+				continue
+			}
+			return os.NewSyscallError("recvfrom", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(c.buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Type>>8 != nfnlSubsysCTNetlink {
+				continue
+			}
+
+			var typ conntrack.EventType
+			switch m.Header.Type & 0xff {
+			case ipctnlMsgCtNew:
+				if m.Header.Flags&(syscall.NLM_F_CREATE|syscall.NLM_F_EXCL) != 0 {
+					typ = conntrack.EventNew
+				} else {
+					typ = conntrack.EventUpdate
+				}
+			case ipctnlMsgCtDelete:
+				typ = conntrack.EventDestroy
+			default:
+				continue
+			}
+
+			e, ok, err := parseEntry(m.Data)
+			if err != nil {
+				return err
+			}
+			if ok {
+				fn(conntrack.Event{Type: typ, Entry: e})
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ctnetlink
+
+import (
+	"context"
+	"errors"
+	"syscall"
+)
+
+// Message types dumping the conntrack entries that are not in the table
+// (enum cntl_msg_types).
+const (
+	ipctnlMsgCtGetDying       = 6
+	ipctnlMsgCtGetUnconfirmed = 7
+)
+
+// ListSource counts the entries of the dying and unconfirmed lists. It
+// satisfies the collector's ListSource interface.
+//
+// Dying entries were removed from the table but are still referenced (e.g.
+// waiting for their DESTROY event to be delivered); unconfirmed entries
+// belong to packets still traversing the stack.
+type ListSource struct{}
+
+// Lists returns the number of entries by list name ("dying",
+// "unconfirmed"). Lists the kernel cannot dump are left out: the
+// unconfirmed list is gone since Linux 6.3.
+func (ListSource) Lists(ctx context.Context) (map[string]uint64, error) {
+	c, err := Dial(0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	out := map[string]uint64{}
+	for _, l := range []struct {
+		name    string
+		msgType uint16
+	}{
+		{"dying", ipctnlMsgCtGetDying},
+		{"unconfirmed", ipctnlMsgCtGetUnconfirmed},
+	} {
+		var n uint64
+		err := c.dump(ctx, nfnlSubsysCTNetlink, l.msgType, syscall.AF_UNSPEC, func(m syscall.NetlinkMessage) error {
+			n++
+			return nil
+		})
+		if errors.Is(err, syscall.EOPNOTSUPP) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[l.name] = n
+	}
+	return out, nil
+}
@@ -0,0 +1,198 @@
+package ctnetlink
+
+// This package talks to the kernel conntrack subsystem over NETLINK_NETFILTER
+// (nfnetlink / ctnetlink). It is an alternative to parsing
+// `/proc/net/nf_conntrack`, which is missing on kernels built with
+// CONFIG_NF_CONNTRACK_PROCFS=n.
+//
+// IMPORTANT:
+// - Keep this package free from Prometheus dependencies.
+// - We intentionally use the standard `syscall` package only; the protocol
+//   subset we need is small and does not justify a netlink library.
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// nfnetlink subsystem identifiers and message types (linux/netfilter/nfnetlink*.h).
+const (
+	nfnlSubsysCTNetlink = 1
+
+	ipctnlMsgCtNew    = 0
+	ipctnlMsgCtGet    = 1
+	ipctnlMsgCtDelete = 2
+
+	nfnetlinkV0 = 0
+
+	// nlaTypeMask strips NLA_F_NESTED / NLA_F_NET_BYTEORDER from attribute types.
+	nlaTypeMask = 0x3fff
+
+	// sizeofNfgenmsg is the size of the nfnetlink header following nlmsghdr.
+	sizeofNfgenmsg = 4
+)
+
+// Conn is a NETLINK_NETFILTER socket.
+//
+// A Conn is not safe for concurrent use.
+type Conn struct {
+	fd  int
+	seq uint32
+	buf []byte
+}
+
+// Dial opens a NETLINK_NETFILTER socket. groups is a bitmask of multicast
+// groups to join (0 for request/response usage only).
+func Dial(groups uint32) (*Conn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	return &Conn{fd: fd, buf: make([]byte, 64*1024)}, nil
+}
+
+// Close closes the underlying socket.
+func (c *Conn) Close() error {
+	return syscall.Close(c.fd)
+}
+
+// dump sends a NLM_F_DUMP request for the given nfnetlink subsystem/message
+// and calls fn for every message of the multi-part reply. It stops with the
+// error of ctx once it is done; the receive timeout of the socket is set
+// from the deadline of ctx.
+func (c *Conn) dump(ctx context.Context, subsys, msgType uint16, family uint8, fn func(m syscall.NetlinkMessage) error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return context.DeadlineExceeded
+		}
+		tv := syscall.NsecToTimeval(left.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	c.seq++
+	seq := c.seq
+
+	req := make([]byte, syscall.SizeofNlMsghdr+sizeofNfgenmsg)
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], subsys<<8|msgType)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(req[8:12], seq)
+	// Port id 0: let the kernel route the reply to this socket.
+	req[16] = family
+	req[17] = nfnetlinkV0
+	// res_id (big endian) stays 0.
+
+	if err := syscall.Sendto(c.fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return os.NewSyscallError("sendto", err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, _, err := syscall.Recvfrom(c.fd, c.buf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				// SO_RCVTIMEO expired.
+				return context.DeadlineExceeded
+			}
+			return os.NewSyscallError("recvfrom", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(c.buf[:n])
+		if err != nil {
+			return fmt.Errorf("parse netlink message: %w", err)
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return nil
+			case syscall.NLMSG_ERROR:
+				return netlinkError(m.Data)
+			}
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// netlinkError decodes the errno carried by NLMSG_ERROR.
+func netlinkError(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("netlink: truncated error message")
+	}
+	errno := int32(binary.NativeEndian.Uint32(data[0:4]))
+	if errno == 0 {
+		return nil
+	}
+	return os.NewSyscallError("netlink", syscall.Errno(-errno))
+}
+
+// attr is a single netlink attribute (type already masked).
+type attr struct {
+	typ  uint16
+	data []byte
+}
+
+// parseAttrs splits a buffer into netlink attributes.
+func parseAttrs(b []byte) ([]attr, error) {
+	var out []attr
+	for len(b) >= syscall.NLA_HDRLEN {
+		l := int(binary.NativeEndian.Uint16(b[0:2]))
+		t := binary.NativeEndian.Uint16(b[2:4]) & nlaTypeMask
+		if l < syscall.NLA_HDRLEN || l > len(b) {
+			return nil, fmt.Errorf("netlink: invalid attribute length %d", l)
+		}
+		out = append(out, attr{typ: t, data: b[syscall.NLA_HDRLEN:l]})
+
+		aligned := (l + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return out, nil
+}
+
+func be16(b []byte) uint16 {
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func be32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func be64(b []byte) uint64 {
+	if len(b) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
@@ -0,0 +1,75 @@
+package ctnetlink
+
+import (
+	"context"
+	"encoding/binary"
+	"syscall"
+
+	"conntrack-exporter/internal/conntrack"
+)
+
+// ipctnlMsgCtGetStatsCPU dumps the per-CPU conntrack statistics.
+const ipctnlMsgCtGetStatsCPU = 4
+
+// statsCPUColumns maps CTA_STATS_* attributes (enum ctattr_stats_cpu) to the
+// column names of `/proc/net/stat/nf_conntrack`, so that both sources
+// produce the same conntrack.CPUStat keys. Attributes the kernel no longer
+// fills (searched, new, ignore, delete_list) are not listed.
+var statsCPUColumns = map[uint16]string{
+	2:  "found",
+	4:  "invalid",
+	6:  "delete",
+	8:  "insert",
+	9:  "insert_failed",
+	10: "drop",
+	11: "early_drop",
+	12: "icmp_error",
+	13: "search_restart",
+	14: "clashres",
+	15: "chaintoolong",
+}
+
+// StatSource reads the per-CPU conntrack statistics over netlink
+// (IPCTNL_MSG_CT_GET_STATS_CPU). It works where
+// `/proc/net/stat/nf_conntrack` is unavailable.
+type StatSource struct{}
+
+// Stats returns the statistics indexed by CPU number. CPUs the kernel did
+// not report are left nil.
+func (StatSource) Stats(ctx context.Context) ([]conntrack.CPUStat, error) {
+	c, err := Dial(0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var out []conntrack.CPUStat
+	err = c.dump(ctx, nfnlSubsysCTNetlink, ipctnlMsgCtGetStatsCPU, syscall.AF_UNSPEC, func(m syscall.NetlinkMessage) error {
+		if len(m.Data) < sizeofNfgenmsg {
+			return nil
+		}
+		// The CPU number is carried in nfgenmsg.res_id.
+		cpu := int(binary.BigEndian.Uint16(m.Data[2:4]))
+
+		attrs, err := parseAttrs(m.Data[sizeofNfgenmsg:])
+		if err != nil {
+			return err
+		}
+		row := conntrack.CPUStat{}
+		for _, a := range attrs {
+			if col, ok := statsCPUColumns[a.typ]; ok {
+				row[col] = uint64(be32(a.data))
+			}
+		}
+
+		for len(out) <= cpu {
+			out = append(out, nil)
+		}
+		out[cpu] = row
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
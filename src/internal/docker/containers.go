@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// DefaultSocket is the Docker Engine API socket.
+const DefaultSocket = "/var/run/docker.sock"
+
+// Containers maps container addresses to container names, from the Docker
+// Engine API (also served by Podman).
+type Containers struct {
+	// Socket is the path of the API unix socket.
+	Socket   string
+	Interval time.Duration
+	Logger   *logging.Logger
+
+	once   sync.Once
+	client *http.Client
+
+	mu     sync.RWMutex
+	byAddr map[netip.Addr]string
+}
+
+// Lookup returns the name of the container owning an address.
+func (c *Containers) Lookup(addr string) (string, bool) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return "", false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	name, ok := c.byAddr[ip]
+	return name, ok
+}
+
+// Run refreshes the mapping every Interval until ctx is done, starting one
+// Interval from now (see Refresh for the initial mapping). A failed refresh
+// keeps the previous mapping.
+func (c *Containers) Run(ctx context.Context) {
+	t := time.NewTicker(c.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := c.Refresh(ctx); err != nil && ctx.Err() == nil {
+			c.Logger.Warn("failed to refresh docker containers", "err", err)
+		}
+	}
+}
+
+type container struct {
+	Names           []string `json:"Names"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Refresh lists running containers and replaces the mapping.
+func (c *Containers) Refresh(ctx context.Context) error {
+	c.once.Do(func() {
+		c.client = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", c.Socket)
+				},
+			},
+		}
+	})
+
+	// The host part is ignored: requests go to the socket.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker/containers/json", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /containers/json: %s", resp.Status)
+	}
+
+	var list []container
+	if err := json.Unmarshal(body, &list); err != nil {
+		return err
+	}
+	byAddr := map[netip.Addr]string{}
+	for _, ct := range list {
+		if len(ct.Names) == 0 {
+			continue
+		}
+		// Names are prefixed with a slash ("/web-1").
+		name := strings.TrimPrefix(ct.Names[0], "/")
+		for _, nw := range ct.NetworkSettings.Networks {
+			for _, addr := range []string{nw.IPAddress, nw.GlobalIPv6Address} {
+				// Empty for host-network containers.
+				if ip, err := netip.ParseAddr(addr); err == nil {
+					byAddr[ip] = name
+				}
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.byAddr = byAddr
+	c.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,111 @@
+package fwset
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// addrSet is a set of prefixes, indexed by prefix length so that a lookup
+// costs one map access per distinct length.
+type addrSet struct {
+	byBits map[int]map[netip.Prefix]struct{}
+}
+
+// newAddrSet builds a set from elements: addresses, prefixes, or
+// `<first>-<last>` ranges. A `,<...>` suffix (ipset hash:ip,port and
+// similar types) is ignored.
+func newAddrSet(elems []string) (*addrSet, error) {
+	s := &addrSet{byBits: map[int]map[netip.Prefix]struct{}{}}
+	for _, e := range elems {
+		e, _, _ = strings.Cut(e, ",")
+		prefixes, err := parseElem(e)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range prefixes {
+			m := s.byBits[p.Bits()]
+			if m == nil {
+				m = map[netip.Prefix]struct{}{}
+				s.byBits[p.Bits()] = m
+			}
+			m[p] = struct{}{}
+		}
+	}
+	return s, nil
+}
+
+func (s *addrSet) contains(addr netip.Addr) bool {
+	for bits, m := range s.byBits {
+		if bits > addr.BitLen() {
+			continue
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		// Prefixes of the other address family never match: their
+		// Addr differs.
+		if _, ok := m[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseElem(e string) ([]netip.Prefix, error) {
+	if first, last, ok := strings.Cut(e, "-"); ok {
+		a, err1 := netip.ParseAddr(first)
+		b, err2 := netip.ParseAddr(last)
+		if err1 != nil || err2 != nil || a.BitLen() != b.BitLen() || b.Less(a) {
+			return nil, fmt.Errorf("invalid range %q", e)
+		}
+		return rangePrefixes(a.Unmap(), b.Unmap()), nil
+	}
+	if strings.Contains(e, "/") {
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, err
+		}
+		return []netip.Prefix{p.Masked()}, nil
+	}
+	a, err := netip.ParseAddr(e)
+	if err != nil {
+		return nil, err
+	}
+	a = a.Unmap()
+	return []netip.Prefix{netip.PrefixFrom(a, a.BitLen())}, nil
+}
+
+// rangePrefixes returns the smallest list of prefixes covering [a, b].
+func rangePrefixes(a, b netip.Addr) []netip.Prefix {
+	var out []netip.Prefix
+	for {
+		// Largest prefix starting at a that does not go past b.
+		bits := a.BitLen()
+		for bits > 0 {
+			p := netip.PrefixFrom(a, bits-1).Masked()
+			if p.Addr() != a || lastAddr(p).Compare(b) > 0 {
+				break
+			}
+			bits--
+		}
+		p := netip.PrefixFrom(a, bits)
+		out = append(out, p)
+		last := lastAddr(p)
+		if last.Compare(b) >= 0 {
+			return out
+		}
+		a = last.Next()
+	}
+}
+
+// lastAddr returns the last address of a prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
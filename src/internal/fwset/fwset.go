@@ -0,0 +1,119 @@
+package fwset
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// Set is a firewall set of addresses: an ipset, or an nftables set.
+type Set struct {
+	// Name is the set name, used as label value.
+	Name string
+	// Family and Table locate nftables sets; empty for ipsets.
+	Family, Table string
+}
+
+// ParseSet parses `ipset:<name>` or `nft:<family>:<table>:<name>` (e.g.
+// nft:inet:filter:blocklist).
+func ParseSet(s string) (Set, error) {
+	kind, rest, _ := strings.Cut(s, ":")
+	parts := strings.Split(rest, ":")
+	switch {
+	case kind == "ipset" && len(parts) == 1 && parts[0] != "":
+		return Set{Name: parts[0]}, nil
+	case kind == "nft" && len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] != "":
+		return Set{Family: parts[0], Table: parts[1], Name: parts[2]}, nil
+	}
+	return Set{}, fmt.Errorf("invalid set %q (expected ipset:<name> or nft:<family>:<table>:<name>)", s)
+}
+
+func (s Set) String() string {
+	if s.Table == "" {
+		return "ipset:" + s.Name
+	}
+	return "nft:" + s.Family + ":" + s.Table + ":" + s.Name
+}
+
+// Sets tracks the members of firewall sets, listed with the ipset(8) and
+// nft(8) commands.
+type Sets struct {
+	Sets     []Set
+	Interval time.Duration
+	Logger   *logging.Logger
+
+	mu      sync.RWMutex
+	members []*addrSet // by index in Sets, nil until listed
+}
+
+// Lookup returns the names of the sets containing any of addrs, in Sets
+// order.
+func (s *Sets) Lookup(addrs ...netip.Addr) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var names []string
+	for i, m := range s.members {
+		if m != nil && slices.ContainsFunc(addrs, func(a netip.Addr) bool { return m.contains(a.Unmap()) }) {
+			names = append(names, s.Sets[i].Name)
+		}
+	}
+	return names
+}
+
+// Run refreshes the members every Interval until ctx is done, starting one
+// Interval from now (see Refresh for the initial members). A set that fails
+// to list keeps its previous members.
+func (s *Sets) Run(ctx context.Context) {
+	t := time.NewTicker(s.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			s.Logger.Warn("failed to refresh firewall sets", "err", err)
+		}
+	}
+}
+
+// Refresh lists the members of all sets. It returns the first error, after
+// trying all sets.
+func (s *Sets) Refresh(ctx context.Context) error {
+	members := make([]*addrSet, len(s.Sets))
+	var firstErr error
+	for i, set := range s.Sets {
+		var (
+			elems []string
+			err   error
+		)
+		if set.Table == "" {
+			elems, err = listIPSet(ctx, set.Name)
+		} else {
+			elems, err = listNFTSet(ctx, set.Family, set.Table, set.Name)
+		}
+		if err == nil {
+			members[i], err = newAddrSet(elems)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", set, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range s.members {
+		if members[i] == nil {
+			members[i] = m
+		}
+	}
+	s.members = members
+	return firstErr
+}
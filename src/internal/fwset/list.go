@@ -0,0 +1,122 @@
+package fwset
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// run runs a command and returns its standard output; the error includes
+// the standard error.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+// listIPSet returns the elements of an ipset, from `ipset save`:
+//
+//	create blocklist hash:net family inet hashsize 1024 maxelem 65536
+//	add blocklist 192.0.2.0/24
+//	add blocklist 198.51.100.7 timeout 300
+//
+// Elements flagged nomatch (exceptions of hash:net sets) are left out.
+func listIPSet(ctx context.Context, name string) ([]string, error) {
+	out, err := run(ctx, "ipset", "save", name)
+	if err != nil {
+		return nil, err
+	}
+	var elems []string
+	for line := range strings.Lines(string(out)) {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "add" || slices.Contains(fields[3:], "nomatch") {
+			continue
+		}
+		elems = append(elems, fields[2])
+	}
+	return elems, nil
+}
+
+// listNFTSet returns the elements of an nftables set, from `nft -j list set`.
+// Elements are addresses, prefixes, ranges, or elements with options
+// (timeouts, counters); for concatenations the first field is used.
+func listNFTSet(ctx context.Context, family, table, name string) ([]string, error) {
+	out, err := run(ctx, "nft", "-j", "list", "set", family, table, name)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Nftables []struct {
+			Set *struct {
+				Elem []json.RawMessage `json:"elem"`
+			} `json:"set"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+	var elems []string
+	for _, obj := range doc.Nftables {
+		if obj.Set == nil {
+			continue
+		}
+		for _, raw := range obj.Set.Elem {
+			e, err := nftElem(raw)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, e)
+		}
+	}
+	return elems, nil
+}
+
+// nftElem converts a JSON set element to the addrSet syntax.
+func nftElem(raw json.RawMessage) (string, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	var obj struct {
+		Prefix *struct {
+			Addr string `json:"addr"`
+			Len  int    `json:"len"`
+		} `json:"prefix"`
+		Range []json.RawMessage `json:"range"`
+		Elem  *struct {
+			Val json.RawMessage `json:"val"`
+		} `json:"elem"`
+		Concat []json.RawMessage `json:"concat"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return "", err
+	}
+	switch {
+	case obj.Prefix != nil:
+		return fmt.Sprintf("%s/%d", obj.Prefix.Addr, obj.Prefix.Len), nil
+	case len(obj.Range) == 2:
+		first, err1 := nftElem(obj.Range[0])
+		last, err2 := nftElem(obj.Range[1])
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("unsupported set element %s", raw)
+		}
+		return first + "-" + last, nil
+	case obj.Elem != nil:
+		return nftElem(obj.Elem.Val)
+	case len(obj.Concat) > 0:
+		return nftElem(obj.Concat[0])
+	}
+	return "", fmt.Errorf("unsupported set element %s", raw)
+}
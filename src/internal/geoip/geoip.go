@@ -0,0 +1,91 @@
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DB looks up the country and the autonomous system of IP addresses in
+// MaxMind databases (GeoLite2/GeoIP2 Country or City, and ASN).
+type DB struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// Open opens the given mmdb files. The kind of each database is taken from
+// its metadata; a later database of the same kind replaces an earlier one.
+func Open(paths []string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		r, err := maxminddb.Open(path)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		typ := r.Metadata.DatabaseType
+		switch {
+		case strings.Contains(typ, "ASN"):
+			db.asn = r
+		case strings.Contains(typ, "Country"), strings.Contains(typ, "City"):
+			db.country = r
+		default:
+			r.Close()
+			db.Close()
+			return nil, fmt.Errorf("%s: unsupported database type %q", path, typ)
+		}
+	}
+	return db, nil
+}
+
+// Close releases the databases.
+func (db *DB) Close() {
+	for _, r := range []*maxminddb.Reader{db.country, db.asn} {
+		if r != nil {
+			r.Close()
+		}
+	}
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type asnRecord struct {
+	Number uint `maxminddb:"autonomous_system_number"`
+}
+
+// Lookup returns the ISO country code and the AS number of a public
+// address. Both are "" for non-public addresses, addresses not found, and
+// missing databases.
+func (db *DB) Lookup(addr netip.Addr) (country, asn string) {
+	if !IsPublic(addr) {
+		return "", ""
+	}
+	ip := addr.AsSlice()
+	if db.country != nil {
+		var rec countryRecord
+		if db.country.Lookup(ip, &rec) == nil {
+			country = rec.Country.ISOCode
+		}
+	}
+	if db.asn != nil {
+		var rec asnRecord
+		if db.asn.Lookup(ip, &rec) == nil && rec.Number != 0 {
+			asn = strconv.FormatUint(uint64(rec.Number), 10)
+		}
+	}
+	return country, asn
+}
+
+// IsPublic reports whether addr is a global unicast address outside of the
+// private ranges (RFC 1918, RFC 4193).
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
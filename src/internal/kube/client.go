@@ -0,0 +1,226 @@
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service account files mounted into pods.
+const (
+	tokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	caFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Client is a minimal Kubernetes API client: read-only list and watch
+// requests.
+type Client struct {
+	// URL of the API server, e.g. https://10.96.0.1:443.
+	URL string
+	// TokenFile is read on each request (bound tokens are rotated); no
+	// authentication when empty (e.g. behind `kubectl proxy`).
+	TokenFile string
+	HTTP      *http.Client
+}
+
+// InCluster returns a client for the API server of the cluster the exporter
+// runs in, authenticated with the pod's service account.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster (KUBERNETES_SERVICE_HOST/PORT unset)")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates", caFile)
+	}
+	return &Client{
+		URL:       "https://" + net.JoinHostPort(host, port),
+		TokenFile: tokenFile,
+		HTTP: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+// errGone is returned by watch when the resource version it starts from is
+// too old: the collection is to be listed again.
+var errGone = errors.New("resource version expired")
+
+// watchTimeout bounds a watch request: the API server ends it, and it is
+// started again from the last resource version.
+const watchTimeout = 5 * time.Minute
+
+// list fetches all objects of a collection (e.g. /api/v1/services), page by
+// page, passing each page to fn. It returns the resource version of the
+// collection, to watch it from. The first page is served from the cache of
+// the API server (resourceVersion=0) rather than from etcd.
+func (c *Client) list(ctx context.Context, path string, fn func(body []byte) error) (string, error) {
+	var cont string
+	for {
+		q := url.Values{"limit": {"500"}}
+		if cont != "" {
+			q.Set("continue", cont)
+		} else {
+			q.Set("resourceVersion", "0")
+		}
+		body, err := c.get(ctx, path+"?"+q.Encode())
+		if err != nil {
+			return "", err
+		}
+		if err := fn(body); err != nil {
+			return "", err
+		}
+		var page struct {
+			Metadata struct {
+				Continue        string `json:"continue"`
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return "", err
+		}
+		if cont = page.Metadata.Continue; cont == "" {
+			return page.Metadata.ResourceVersion, nil
+		}
+	}
+}
+
+// watch watches a collection from resource version rv, passing the type
+// (ADDED, MODIFIED or DELETED) and object of each change to fn, until the API
+// server ends the request or ctx is done. It returns the resource version to
+// watch again from, and errGone when rv is too old.
+func (c *Client) watch(ctx context.Context, path, rv string, fn func(typ string, object json.RawMessage) error) (string, error) {
+	q := url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {rv},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {strconv.Itoa(int(watchTimeout.Seconds()))},
+	}
+	req, err := c.request(ctx, path+"?"+q.Encode())
+	if err != nil {
+		return rv, err
+	}
+	// The watch outlives the timeout of the client, meant for lists.
+	hc := *c.client()
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return rv, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return rv, errGone
+	default:
+		return rv, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return rv, nil
+			}
+			return rv, err
+		}
+		if ev.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(ev.Object, &status); err != nil {
+				return rv, err
+			}
+			if status.Code == http.StatusGone {
+				return rv, errGone
+			}
+			return rv, fmt.Errorf("watch %s: %s", path, status.Message)
+		}
+		var meta struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(ev.Object, &meta); err != nil {
+			return rv, err
+		}
+		if meta.Metadata.ResourceVersion != "" {
+			rv = meta.Metadata.ResourceVersion
+		}
+		if ev.Type == "BOOKMARK" {
+			continue
+		}
+		if err := fn(ev.Type, ev.Object); err != nil {
+			return rv, err
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := c.request(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return body, nil
+}
+
+// request returns an authenticated GET request of path.
+func (c *Client) request(ctx context.Context, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.URL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.TokenFile != "" {
+		token, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return req, nil
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
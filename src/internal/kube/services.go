@@ -0,0 +1,255 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// Service identifies a Kubernetes Service.
+type Service struct {
+	Name, Namespace string
+}
+
+// Services maps addresses to Services: ClusterIPs and external IPs of
+// Services, and endpoint (pod) addresses from EndpointSlices. The latter
+// attribute connections seen after kube-proxy DNAT (e.g. on the pod side, or
+// with --collector.tuple=reply).
+type Services struct {
+	Client *Client
+	// Interval is the time to wait before retrying a failed list or watch.
+	Interval time.Duration
+	Logger   *logging.Logger
+
+	mu     sync.RWMutex
+	byAddr map[netip.Addr]Service
+
+	// Objects of each collection by namespace/name, and the resource
+	// version to watch them from.
+	objMu    sync.Mutex
+	objects  [len(collections)]map[string]object
+	versions [len(collections)]string
+	dirty    bool
+}
+
+// Lookup returns the Service of an address.
+func (s *Services) Lookup(addr string) (Service, bool) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return Service{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	svc, ok := s.byAddr[ip]
+	return svc, ok
+}
+
+// Run watches Services and EndpointSlices from the lists of Refresh until ctx
+// is done, listing them again when their resource version expired. A failed
+// list or watch is retried after Interval, keeping the current mapping.
+func (s *Services) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range collections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.watch(ctx, i)
+		}()
+	}
+	defer wg.Wait()
+
+	t := time.NewTicker(rebuildInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		s.rebuild()
+	}
+}
+
+func (s *Services) watch(ctx context.Context, i int) {
+	s.objMu.Lock()
+	rv := s.versions[i]
+	s.objMu.Unlock()
+	for ctx.Err() == nil {
+		var err error
+		if rv == "" {
+			rv, err = s.list(ctx, i)
+		} else {
+			rv, err = s.Client.watch(ctx, collections[i], rv, func(typ string, object json.RawMessage) error {
+				return s.apply(i, typ, object)
+			})
+			if errors.Is(err, errGone) {
+				s.Logger.Debug("kubernetes resource version expired, listing again", "path", collections[i])
+				rv, err = "", nil
+			}
+		}
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		s.Logger.Warn("failed to watch kubernetes services", "path", collections[i], "err", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// collections are the watched collections, in the order their addresses are
+// mapped: endpoints first, a ClusterIP wins over a (misconfigured) endpoint
+// with the same address.
+var collections = [...]string{
+	"/apis/discovery.k8s.io/v1/endpointslices",
+	"/api/v1/services",
+}
+
+// rebuildInterval batches the changes of a watch into the mapping.
+const rebuildInterval = time.Second
+
+// object is the Service and addresses of a Service or EndpointSlice.
+type object struct {
+	svc   Service
+	addrs []string
+}
+
+// item is a Service or EndpointSlice.
+type item struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ClusterIPs  []string `json:"clusterIPs"`
+		ExternalIPs []string `json:"externalIPs"`
+	} `json:"spec"`
+	Endpoints []struct {
+		Addresses []string `json:"addresses"`
+	} `json:"endpoints"`
+}
+
+type objectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// serviceNameLabel links an EndpointSlice to its Service.
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// key identifies an item in its collection.
+func (it *item) key() string {
+	return it.Metadata.Namespace + "/" + it.Metadata.Name
+}
+
+// object returns the object of an item of collection i, false for an
+// EndpointSlice without Service.
+func (it *item) object(i int) (object, bool) {
+	if collections[i] == "/api/v1/services" {
+		o := object{svc: Service{Name: it.Metadata.Name, Namespace: it.Metadata.Namespace}}
+		o.addrs = append(o.addrs, it.Spec.ClusterIPs...)
+		o.addrs = append(o.addrs, it.Spec.ExternalIPs...)
+		return o, true
+	}
+	name := it.Metadata.Labels[serviceNameLabel]
+	if name == "" {
+		return object{}, false
+	}
+	o := object{svc: Service{Name: name, Namespace: it.Metadata.Namespace}}
+	for _, ep := range it.Endpoints {
+		o.addrs = append(o.addrs, ep.Addresses...)
+	}
+	return o, true
+}
+
+// Refresh lists Services and EndpointSlices and replaces the mapping.
+func (s *Services) Refresh(ctx context.Context) error {
+	for i := range collections {
+		if _, err := s.list(ctx, i); err != nil {
+			return err
+		}
+	}
+	s.rebuild()
+	return nil
+}
+
+// list lists collection i, replacing its objects, and returns its resource
+// version.
+func (s *Services) list(ctx context.Context, i int) (string, error) {
+	objects := map[string]object{}
+	rv, err := s.Client.list(ctx, collections[i], func(body []byte) error {
+		var list struct {
+			Items []item `json:"items"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return err
+		}
+		for _, it := range list.Items {
+			if o, ok := it.object(i); ok {
+				objects[it.key()] = o
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.objMu.Lock()
+	defer s.objMu.Unlock()
+	s.objects[i], s.versions[i], s.dirty = objects, rv, true
+	return rv, nil
+}
+
+// apply applies a watch event to the objects of collection i.
+func (s *Services) apply(i int, typ string, raw json.RawMessage) error {
+	var it item
+	if err := json.Unmarshal(raw, &it); err != nil {
+		return err
+	}
+	o, ok := it.object(i)
+
+	s.objMu.Lock()
+	defer s.objMu.Unlock()
+	if s.objects[i] == nil {
+		s.objects[i] = map[string]object{}
+	}
+	// A modified EndpointSlice may lose its Service label.
+	if typ == "DELETED" || !ok {
+		delete(s.objects[i], it.key())
+	} else {
+		s.objects[i][it.key()] = o
+	}
+	s.dirty = true
+	return nil
+}
+
+// rebuild replaces the mapping when objects changed.
+func (s *Services) rebuild() {
+	s.objMu.Lock()
+	defer s.objMu.Unlock()
+	if !s.dirty {
+		return
+	}
+	s.dirty = false
+
+	byAddr := map[netip.Addr]Service{}
+	for _, objects := range s.objects {
+		for _, o := range objects {
+			for _, addr := range o.addrs {
+				// Headless services have ClusterIP "None".
+				if ip, err := netip.ParseAddr(addr); err == nil {
+					byAddr[ip] = o.svc
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.byAddr = byAddr
+	s.mu.Unlock()
+}
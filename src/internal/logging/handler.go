@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handler is the slog.Handler of New: it resolves the attributes of each
+// record, after the ts, level and msg keys, and passes them to a sink.
+// Groups qualify the keys of their attributes (group.key).
+type handler struct {
+	sink  sink
+	level slog.Leveler
+
+	// attrs are the attributes added with WithAttrs, already qualified;
+	// prefix qualifies the keys of the next ones.
+	attrs  []slog.Attr
+	prefix string
+}
+
+func newHandler(sink sink, level slog.Leveler) *handler {
+	return &handler{sink: sink, level: level}
+}
+
+// sink writes the records of a handler. attrs start with ts, level, source
+// (optional) and msg.
+type sink interface {
+	write(lvl slog.Level, attrs []slog.Attr) error
+}
+
+// streamSink writes one line per record, in logfmt or JSON.
+type streamSink struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format Format
+}
+
+func (s *streamSink) write(_ slog.Level, attrs []slog.Attr) error {
+	line := formatLine(s.format, attrs)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.out.Write(line)
+	return err
+}
+
+func formatLine(format Format, attrs []slog.Attr) []byte {
+	if format == JSON {
+		return jsonLine(attrs)
+	}
+	return logfmtLine(attrs)
+}
+
+func (h *handler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level()
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = appendAttrs(append([]slog.Attr(nil), h.attrs...), h.prefix, attrs)
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+func (h *handler) withLevel(level slog.Leveler) slog.Handler {
+	c := *h
+	c.level = level
+	return &c
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	attrs := []slog.Attr{
+		slog.String("ts", r.Time.UTC().Format(time.RFC3339Nano)),
+		slog.String("level", levelString(levelOf(r.Level))),
+	}
+	// At debug level, records carry their source location.
+	if h.level.Level() <= slog.LevelDebug && r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		attrs = append(attrs, slog.String("source", filepath.Base(f.File)+":"+strconv.Itoa(f.Line)))
+	}
+	attrs = append(attrs, slog.String("msg", r.Message))
+	attrs = append(attrs, h.attrs...)
+	var recordAttrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		recordAttrs = append(recordAttrs, a)
+		return true
+	})
+	attrs = appendAttrs(attrs, h.prefix, recordAttrs)
+	return h.sink.write(r.Level, attrs)
+}
+
+// appendAttrs appends attrs to dst, resolved, with their keys qualified by
+// prefix, and groups flattened.
+func appendAttrs(dst []slog.Attr, prefix string, attrs []slog.Attr) []slog.Attr {
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Value.Kind() == slog.KindGroup {
+			group := prefix
+			if a.Key != "" {
+				group += a.Key + "."
+			}
+			dst = appendAttrs(dst, group, a.Value.Group())
+			continue
+		}
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		a.Key = prefix + a.Key
+		dst = append(dst, a)
+	}
+	return dst
+}
+
+// logfmt-ish, not a full logfmt implementation, but adequate here.
+func logfmtLine(attrs []slog.Attr) []byte {
+	sb := strings.Builder{}
+	for i, a := range attrs {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(a.Key)
+		sb.WriteString("=")
+		sb.WriteString(escapeLogfmt(fmt.Sprint(a.Value.Any())))
+	}
+	sb.WriteString("\n")
+	return []byte(sb.String())
+}
+
+func jsonLine(attrs []slog.Attr) []byte {
+	b := []byte{'{'}
+	for i, a := range attrs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		k, _ := json.Marshal(a.Key)
+		b = append(b, k...)
+		b = append(b, ':')
+		b = append(b, jsonValue(a.Value.Any())...)
+	}
+	return append(b, '}', '\n')
+}
+
+func jsonValue(v any) []byte {
+	switch v := v.(type) {
+	case error:
+		b, _ := json.Marshal(v.Error())
+		return b
+	case time.Duration:
+		b, _ := json.Marshal(v.String())
+		return b
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return b
+}
@@ -0,0 +1,152 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Levels are the levels of a logger and its components (see
+// Logger.Component): Default applies to the components missing from
+// Components.
+type Levels struct {
+	Default    Level
+	Components map[string]Level
+}
+
+// ParseLevels parses a comma-separated list of levels: a level, the default,
+// and component=level pairs, e.g. "info,collector=debug,web=warn". The
+// default is info when missing. When components are given, the components
+// of s must be among them.
+func ParseLevels(s string, components ...string) (Levels, error) {
+	levels := Levels{Default: Info}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			level, err := ParseLevel(item)
+			if err != nil {
+				return Levels{Default: Info}, err
+			}
+			levels.Default = level
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || len(components) > 0 && !slices.Contains(components, name) {
+			return Levels{Default: Info}, fmt.Errorf("unknown log component %q", name)
+		}
+		level, err := ParseLevel(value)
+		if err != nil {
+			return Levels{Default: Info}, fmt.Errorf("%s: %w", name, err)
+		}
+		if levels.Components == nil {
+			levels.Components = make(map[string]Level)
+		}
+		levels.Components[name] = level
+	}
+	return levels, nil
+}
+
+// String returns levels in the form parsed by ParseLevels, components sorted.
+func (levels Levels) String() string {
+	parts := []string{levels.Default.String()}
+	names := make([]string, 0, len(levels.Components))
+	for name := range levels.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+"="+levels.Components[name].String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// levelVar is the level of a logger. The level of a component follows that
+// of its parent, the root logger, until set.
+type levelVar struct {
+	v      slog.LevelVar
+	set    atomic.Bool
+	parent *levelVar
+}
+
+func (lv *levelVar) Level() slog.Level {
+	if lv.parent != nil && !lv.set.Load() {
+		return lv.parent.Level()
+	}
+	return lv.v.Level()
+}
+
+func (lv *levelVar) Set(level slog.Level) {
+	lv.v.Set(level)
+	lv.set.Store(true)
+}
+
+// unset makes lv follow its parent again.
+func (lv *levelVar) unset() { lv.set.Store(false) }
+
+// components are the levels of the root logger and its components, shared
+// by all the loggers derived from it.
+type components struct {
+	root *levelVar
+
+	mu     sync.Mutex
+	byName map[string]*levelVar
+}
+
+func newComponents(level Level) *components {
+	root := &levelVar{}
+	root.Set(level.slogLevel())
+	return &components{root: root, byName: make(map[string]*levelVar)}
+}
+
+// level returns the level of component name, following the root level until
+// set.
+func (c *components) level(name string) *levelVar {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lv, ok := c.byName[name]
+	if !ok {
+		lv = &levelVar{parent: c.root}
+		c.byName[name] = lv
+	}
+	return lv
+}
+
+func (c *components) set(levels Levels) {
+	c.root.Set(levels.Default.slogLevel())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, lv := range c.byName {
+		lv.unset()
+	}
+	for name, level := range levels.Components {
+		lv, ok := c.byName[name]
+		if !ok {
+			lv = &levelVar{parent: c.root}
+			c.byName[name] = lv
+		}
+		lv.Set(level.slogLevel())
+	}
+}
+
+func (c *components) get() Levels {
+	levels := Levels{Default: levelOf(c.root.Level())}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, lv := range c.byName {
+		if lv.set.Load() {
+			if levels.Components == nil {
+				levels.Components = make(map[string]Level)
+			}
+			levels.Components[name] = levelOf(lv.Level())
+		}
+	}
+	return levels
+}
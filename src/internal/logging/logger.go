@@ -1,12 +1,13 @@
 package logging
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"runtime"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -54,22 +55,122 @@ func ParseFormat(s string) (Format, error) {
 	}
 }
 
-// Logger is a tiny structured logger used by this project to avoid pulling in
-// a heavy logging dependency for a small exporter.
+// Logger is the structured logger of this project: a thin layer over
+// log/slog, keeping the level adjustable at runtime (see SetLevel) and the
+// logfmt/JSON output of the exporter (see New). Any slog.Handler can be used
+// instead (see FromHandler), and the logger passed to libraries expecting a
+// *slog.Logger (see Slog). Components of the exporter log through children
+// with levels of their own (see Component and SetLevels), and repeated
+// warnings and errors can be sampled (see SetRepeatEvery).
 //
 // All methods are safe for concurrent use.
 type Logger struct {
-	mu     sync.Mutex
-	out    io.Writer
-	level  Level
-	format Format
+	handler slog.Handler
+	level   *levelVar
+
+	// components and repeats are shared by the loggers derived from the
+	// root one.
+	components *components
+	repeats    *repeats
+}
+
+// newLogger returns a root logger sending its records to the handler
+// returned by h for its level, through the sampling of repeated ones.
+func newLogger(level Level, h func(slog.Leveler) slog.Handler) *Logger {
+	c := newComponents(level)
+	r := &repeats{seen: make(map[string]*repeat)}
+	return &Logger{
+		handler:    &repeatHandler{Handler: h(c.root), repeats: r},
+		level:      c.root,
+		components: c,
+		repeats:    r,
+	}
 }
 
+// New returns a logger writing to out in format. At debug level, messages
+// also carry their source location.
 func New(out io.Writer, level Level, format Format) *Logger {
 	if out == nil {
 		out = os.Stderr
 	}
-	return &Logger{out: out, level: level, format: format}
+	s := &streamSink{out: out, format: format}
+	return newLogger(level, func(lv slog.Leveler) slog.Handler { return newHandler(s, lv) })
+}
+
+// FromHandler returns a logger sending its records to h, filtered by level,
+// e.g. to use a third-party handler.
+func FromHandler(h slog.Handler, level Level) *Logger {
+	return newLogger(level, func(lv slog.Leveler) slog.Handler { return &levelHandler{Handler: h, level: lv} })
+}
+
+// SetLevel changes the minimum level of logged messages: of the components
+// without a level of their own for the root logger, of its component for
+// a logger returned by Component.
+func (l *Logger) SetLevel(level Level) { l.level.Set(level.slogLevel()) }
+
+// Level returns the minimum level of logged messages.
+func (l *Logger) Level() Level { return levelOf(l.level.Level()) }
+
+// SetLevels changes the levels of the root logger and all its components:
+// the components missing from levels follow the default level.
+func (l *Logger) SetLevels(levels Levels) { l.components.set(levels) }
+
+// Levels returns the levels of the root logger and its components.
+func (l *Logger) Levels() Levels { return l.components.get() }
+
+// SetRepeatEvery makes the logger, and those derived from it, log a warning
+// or error repeating identically (same message and attributes) only once
+// every n occurrences, with the number of those suppressed in between
+// (suppressed=N). A message not seen for an hour is logged again at its next
+// occurrence. With n of 1 or less, every message is logged, the default.
+func (l *Logger) SetRepeatEvery(n int) { l.repeats.every.Store(int64(n)) }
+
+// Handler returns the slog handler of the logger.
+func (l *Logger) Handler() slog.Handler { return l.handler }
+
+// Slog returns a *slog.Logger logging like l.
+func (l *Logger) Slog() *slog.Logger { return slog.New(l.handler) }
+
+// With returns a logger adding kv to each message. It shares the level and
+// repeat sampling of l.
+func (l *Logger) With(kv ...any) *Logger {
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(kv...)
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	c := *l
+	c.handler = l.handler.WithAttrs(attrs)
+	return &c
+}
+
+// WithGroup returns a logger qualifying the keys of each message with name
+// (name.key). It shares the level and repeat sampling of l.
+func (l *Logger) WithGroup(name string) *Logger {
+	c := *l
+	c.handler = l.handler.WithGroup(name)
+	return &c
+}
+
+// Component returns a logger for component name (e.g. collector or web),
+// adding component=name to each message (see With), with a level of its
+// own: that of name in SetLevels, the level of the root logger otherwise.
+// Loggers of the same component share their level.
+func (l *Logger) Component(name string) *Logger {
+	c := l.With("component", name)
+	c.level = l.components.level(name)
+	if h, ok := c.handler.(leveledHandler); ok {
+		c.handler = h.withLevel(c.level)
+	}
+	return c
+}
+
+// leveledHandler is a handler of this package, whose level can be replaced
+// (see Logger.Component).
+type leveledHandler interface {
+	withLevel(level slog.Leveler) slog.Handler
 }
 
 func (l *Logger) Debug(msg string, kv ...any) { l.log(Debug, msg, kv...) }
@@ -78,47 +179,67 @@ func (l *Logger) Warn(msg string, kv ...any)  { l.log(Warn, msg, kv...) }
 func (l *Logger) Error(msg string, kv ...any) { l.log(Error, msg, kv...) }
 
 func (l *Logger) log(lvl Level, msg string, kv ...any) {
-	if lvl < l.level {
+	ctx := context.Background()
+	if !l.handler.Enabled(ctx, lvl.slogLevel()) {
 		return
 	}
+	// Skip runtime.Callers, log and Debug/Info/...: the source is the
+	// caller of the latter.
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), lvl.slogLevel(), msg, pcs[0])
+	r.Add(kv...)
+	_ = l.handler.Handle(ctx, r)
+}
 
-	now := time.Now().UTC().Format(time.RFC3339Nano)
-	levelStr := levelString(lvl)
+// levelHandler filters the records of Handler by level.
+type levelHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+func (h *levelHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level() && h.Handler.Enabled(ctx, lvl)
+}
 
-	switch l.format {
-	case JSON:
-		m := map[string]any{
-			"ts":    now,
-			"level": levelStr,
-			"msg":   msg,
-		}
-		addKV(m, kv...)
-		b, _ := json.Marshal(m)
-		_, _ = l.out.Write(append(b, '\n'))
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+func (h *levelHandler) withLevel(level slog.Leveler) slog.Handler {
+	return &levelHandler{Handler: h.Handler, level: level}
+}
+
+func (lvl Level) String() string { return levelString(lvl) }
+
+func (lvl Level) slogLevel() slog.Level {
+	switch lvl {
+	case Debug:
+		return slog.LevelDebug
+	case Warn:
+		return slog.LevelWarn
+	case Error:
+		return slog.LevelError
 	default:
-		// logfmt-ish, not a full logfmt implementation, but adequate here.
-		sb := strings.Builder{}
-		sb.WriteString("ts=")
-		sb.WriteString(escapeLogfmt(now))
-		sb.WriteString(" level=")
-		sb.WriteString(escapeLogfmt(levelStr))
-		sb.WriteString(" msg=")
-		sb.WriteString(escapeLogfmt(msg))
-		for i := 0; i+1 < len(kv); i += 2 {
-			k, ok := kv[i].(string)
-			if !ok {
-				continue
-			}
-			sb.WriteString(" ")
-			sb.WriteString(k)
-			sb.WriteString("=")
-			sb.WriteString(escapeLogfmt(fmt.Sprint(kv[i+1])))
-		}
-		sb.WriteString("\n")
-		_, _ = l.out.Write([]byte(sb.String()))
+		return slog.LevelInfo
+	}
+}
+
+// levelOf returns the Level of a slog level, rounded down.
+func levelOf(lvl slog.Level) Level {
+	switch {
+	case lvl >= slog.LevelError:
+		return Error
+	case lvl >= slog.LevelWarn:
+		return Warn
+	case lvl >= slog.LevelInfo:
+		return Info
+	default:
+		return Debug
 	}
 }
 
@@ -137,16 +258,6 @@ func levelString(lvl Level) string {
 	}
 }
 
-func addKV(m map[string]any, kv ...any) {
-	for i := 0; i+1 < len(kv); i += 2 {
-		k, ok := kv[i].(string)
-		if !ok {
-			continue
-		}
-		m[k] = kv[i+1]
-	}
-}
-
 func escapeLogfmt(s string) string {
 	// Quote if contains spaces or special chars; keep it simple.
 	if s == "" {
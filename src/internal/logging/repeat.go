@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// repeatForget is how long a message must not occur to be logged again at
+// its next occurrence, e.g. once an error is fixed then comes back.
+const repeatForget = time.Hour
+
+// repeatMaxEntries bounds the number of messages whose repeats are tracked.
+const repeatMaxEntries = 1024
+
+// repeats tracks the warnings and errors of a logger and its children, to
+// log each one only once every n identical occurrences (see
+// Logger.SetRepeatEvery).
+type repeats struct {
+	every atomic.Int64
+
+	mu   sync.Mutex
+	seen map[string]*repeat
+}
+
+type repeat struct {
+	suppressed int
+	last       time.Time
+}
+
+// sample reports whether the message with key, occurring at t, is to be
+// logged, and the number of identical ones suppressed since the last logged.
+func (r *repeats) sample(key string, t time.Time) (bool, int) {
+	every := int(r.every.Load())
+	if every <= 1 {
+		return true, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.seen[key]
+	if !ok || t.Sub(e.last) > repeatForget {
+		if len(r.seen) >= repeatMaxEntries {
+			r.prune(t)
+		}
+		r.seen[key] = &repeat{last: t}
+		return true, 0
+	}
+	e.last = t
+	if e.suppressed+1 < every {
+		e.suppressed++
+		return false, 0
+	}
+	n := e.suppressed
+	e.suppressed = 0
+	return true, n
+}
+
+// prune forgets the messages not seen for repeatForget, or all of them when
+// that is not enough.
+func (r *repeats) prune(t time.Time) {
+	for k, e := range r.seen {
+		if t.Sub(e.last) > repeatForget {
+			delete(r.seen, k)
+		}
+	}
+	if len(r.seen) >= repeatMaxEntries {
+		clear(r.seen)
+	}
+}
+
+// repeatHandler passes the records of warn level or above to Handler only
+// once every n identical ones (same level, message and attributes), the
+// logged ones carrying the number of those suppressed in between
+// (suppressed=N).
+type repeatHandler struct {
+	slog.Handler
+	repeats *repeats
+
+	// key identifies the attributes added with WithAttrs and WithGroup.
+	key string
+}
+
+func (h *repeatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.Handler = h.Handler.WithAttrs(attrs)
+	c.key = h.key + attrsKey(attrs)
+	return &c
+}
+
+func (h *repeatHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.Handler = h.Handler.WithGroup(name)
+	c.key = h.key + " " + name + "."
+	return &c
+}
+
+func (h *repeatHandler) withLevel(level slog.Leveler) slog.Handler {
+	c := *h
+	if l, ok := h.Handler.(leveledHandler); ok {
+		c.Handler = l.withLevel(level)
+	}
+	return &c
+}
+
+func (h *repeatHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.Handler.Handle(ctx, r)
+	}
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	key := r.Level.String() + " " + r.Message + h.key + attrsKey(attrs)
+	ok, suppressed := h.repeats.sample(key, r.Time)
+	if !ok {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func attrsKey(attrs []slog.Attr) string {
+	var sb strings.Builder
+	for _, a := range attrs {
+		sb.WriteString(" ")
+		sb.WriteString(a.Key)
+		sb.WriteString("=")
+		sb.WriteString(fmt.Sprint(a.Value.Resolve().Any()))
+	}
+	return sb.String()
+}
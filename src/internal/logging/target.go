@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"strings"
+)
+
+// Target is where log messages go.
+type Target string
+
+const (
+	Stderr   Target = "stderr"
+	Syslog   Target = "syslog"
+	Journald Target = "journald"
+)
+
+func ParseTarget(s string) (Target, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "stderr":
+		return Stderr, nil
+	case "syslog":
+		return Syslog, nil
+	case "journald":
+		return Journald, nil
+	default:
+		return Stderr, fmt.Errorf("unknown log target %q", s)
+	}
+}
+
+// journaldSocket is the native protocol socket of systemd-journald.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// NewTarget returns a logger sending messages to target: stderr (see New),
+// the local syslog daemon, or journald, as tag (the program name). With
+// syslog and journald, messages carry the syslog priority of their level,
+// and no ts or level key, which the daemon records itself; format applies to
+// the rest of the message.
+func NewTarget(target Target, tag string, level Level, format Format) (*Logger, error) {
+	var s sink
+	switch target {
+	case Syslog:
+		w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: %w", err)
+		}
+		s = &syslogSink{w: w, format: format}
+	case Journald:
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+		if err != nil {
+			return nil, fmt.Errorf("journald: %w", err)
+		}
+		s = &journaldSink{conn: conn, tag: tag, format: format}
+	default:
+		return New(nil, level, format), nil
+	}
+	return newLogger(level, func(lv slog.Leveler) slog.Handler { return newHandler(s, lv) }), nil
+}
+
+// priority returns the syslog priority of a level: debug, info, warning or
+// err.
+func priority(lvl slog.Level) syslog.Priority {
+	switch {
+	case lvl >= slog.LevelError:
+		return syslog.LOG_ERR
+	case lvl >= slog.LevelWarn:
+		return syslog.LOG_WARNING
+	case lvl >= slog.LevelInfo:
+		return syslog.LOG_INFO
+	default:
+		return syslog.LOG_DEBUG
+	}
+}
+
+// withoutHeader drops the ts and level keys of attrs.
+func withoutHeader(attrs []slog.Attr) []slog.Attr {
+	return attrs[2:]
+}
+
+// syslogSink sends records to the local syslog daemon.
+type syslogSink struct {
+	w      *syslog.Writer
+	format Format
+}
+
+func (s *syslogSink) write(lvl slog.Level, attrs []slog.Attr) error {
+	line := strings.TrimSuffix(string(formatLine(s.format, withoutHeader(attrs))), "\n")
+	switch priority(lvl) {
+	case syslog.LOG_ERR:
+		return s.w.Err(line)
+	case syslog.LOG_WARNING:
+		return s.w.Warning(line)
+	case syslog.LOG_INFO:
+		return s.w.Info(line)
+	default:
+		return s.w.Debug(line)
+	}
+}
+
+// journaldSink sends records to journald with its native protocol: MESSAGE
+// is the formatted message, and each attribute is also a field of its own
+// (its key in upper case, e.g. ERR), so that journalctl can match on it.
+type journaldSink struct {
+	conn   *net.UnixConn
+	tag    string
+	format Format
+}
+
+func (s *journaldSink) write(lvl slog.Level, attrs []slog.Attr) error {
+	attrs = withoutHeader(attrs)
+	var b bytes.Buffer
+	journaldField(&b, "PRIORITY", fmt.Sprint(int(priority(lvl))))
+	journaldField(&b, "SYSLOG_IDENTIFIER", s.tag)
+	if attrs[0].Key == "source" {
+		file, line, _ := strings.Cut(attrs[0].Value.String(), ":")
+		journaldField(&b, "CODE_FILE", file)
+		journaldField(&b, "CODE_LINE", line)
+		attrs = attrs[1:]
+	}
+	journaldField(&b, "MESSAGE", strings.TrimSuffix(string(formatLine(s.format, attrs)), "\n"))
+	for _, a := range attrs[1:] {
+		if name := journaldName(a.Key); name != "" {
+			journaldField(&b, name, fmt.Sprint(a.Value.Any()))
+		}
+	}
+	_, err := s.conn.Write(b.Bytes())
+	return err
+}
+
+// journaldField appends a field to b, in binary form when value has
+// newlines.
+func journaldField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journaldName returns the journald field name of key: upper case letters,
+// digits and underscores, not starting with one (reserved for trusted
+// fields). It returns "" when nothing is left.
+func journaldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,130 @@
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"conntrack-exporter/internal/procfs"
+)
+
+// DefaultRunDir is where `ip netns` bind-mounts named network namespaces.
+const DefaultRunDir = "/var/run/netns"
+
+// HostName is the name of the exporter's own network namespace.
+const HostName = "host"
+
+// Namespace is a network namespace to collect from.
+type Namespace struct {
+	// Name is the `ip netns` name, HostName for the exporter's own
+	// namespace, or "net:[<inode>]" for unnamed namespaces found via
+	// /proc/<pid>/ns/net.
+	Name string
+	// Path is a file referring to the namespace (bind mount or nsfs link).
+	Path string
+}
+
+// List enumerates network namespaces: the exporter's own namespace, the
+// named namespaces in runDir and, with scanProcs, the namespaces of all
+// processes in fs. Namespaces are deduplicated by inode; the first name seen
+// wins (host, then runDir, then processes).
+func List(fs procfs.FS, runDir string, scanProcs bool) ([]Namespace, error) {
+	var (
+		out  []Namespace
+		seen = map[uint64]bool{}
+	)
+	add := func(name, path string) {
+		ino, err := inode(path)
+		if err != nil || seen[ino] {
+			return
+		}
+		seen[ino] = true
+		if name == "" {
+			name = "net:[" + strconv.FormatUint(ino, 10) + "]"
+		}
+		out = append(out, Namespace{Name: name, Path: path})
+	}
+
+	add(HostName, fs.Path("thread-self/ns/net"))
+	if len(out) == 0 {
+		return nil, errors.New("cannot open own network namespace")
+	}
+
+	if runDir != "" {
+		entries, err := os.ReadDir(runDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			add(e.Name(), filepath.Join(runDir, e.Name()))
+		}
+	}
+
+	if scanProcs {
+		entries, err := os.ReadDir(fs.Root)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if _, err := strconv.Atoi(e.Name()); err != nil {
+				continue
+			}
+			add("", fs.Path(filepath.Join(e.Name(), "ns/net")))
+		}
+	}
+
+	return out, nil
+}
+
+func inode(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+// Do runs fn with the calling goroutine's OS thread switched into the
+// network namespace at path. Sockets created and `/proc/thread-self/net`
+// files read by fn belong to that namespace.
+//
+// fn must not start goroutines that depend on the namespace: they run on
+// other threads. Requires CAP_SYS_ADMIN.
+func Do(fs procfs.FS, path string, fn func() error) error {
+	runtime.LockOSThread()
+
+	orig, err := os.Open(fs.Path("thread-self/ns/net"))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer orig.Close()
+
+	target, err := os.Open(path)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("setns %s: %w", path, err)
+	}
+
+	fnErr := fn()
+
+	if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+		// Leave the thread locked: the runtime terminates it when the
+		// goroutine exits instead of reusing it in the wrong namespace.
+		return fmt.Errorf("restore network namespace: %w", err)
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
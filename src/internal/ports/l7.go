@@ -2,13 +2,14 @@ package ports
 
 import "strconv"
 
-// L7ProtocolFromDPort returns a short L7 protocol name derived from destination port.
+// L7ProtocolFromDPort returns a short L7 protocol name derived from the
+// transport protocol and the destination port.
 //
 // The project requirement:
-// - if port is standard => meaningful name (e.g. 443=https)
+// - if port is standard => meaningful name (e.g. tcp/443=https, udp/443=quic)
 // - otherwise => "unknown"
 // - if protocol doesn't have ports => caller should pass "0" and we return "na"
-func L7ProtocolFromDPort(dport string) string {
+func L7ProtocolFromDPort(l4, dport string) string {
 	if dport == "" {
 		return "unknown"
 	}
@@ -21,6 +22,16 @@ func L7ProtocolFromDPort(dport string) string {
 		return "unknown"
 	}
 
+	switch l4 {
+	case "udp", "udplite":
+		return udpProtocol(p)
+	default:
+		// tcp, and other connection-oriented protocols (sctp, dccp).
+		return tcpProtocol(p)
+	}
+}
+
+func tcpProtocol(p int) string {
 	// Keep this list intentionally small and conservative.
 	switch p {
 	case 20, 21:
@@ -64,3 +75,31 @@ func L7ProtocolFromDPort(dport string) string {
 	return "unknown"
 }
 
+func udpProtocol(p int) string {
+	switch p {
+	case 53:
+		return "dns"
+	case 67, 68:
+		return "dhcp"
+	case 69:
+		return "tftp"
+	case 123:
+		return "ntp"
+	case 161, 162:
+		return "snmp"
+	case 443:
+		return "quic"
+	case 500, 4500:
+		return "ipsec"
+	case 514:
+		return "syslog"
+	case 1194:
+		return "openvpn"
+	case 4789:
+		return "vxlan"
+	case 51820:
+		return "wireguard"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,80 @@
+package ports
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultServicesFile is the system port name database.
+const DefaultServicesFile = "/etc/services"
+
+// Port is a transport protocol and port number. An empty L4 matches any
+// protocol (mapping file entries without protocol).
+type Port struct {
+	L4  string
+	Num int
+}
+
+// Services maps ports to service names, as read from a services(5) file.
+type Services map[Port]string
+
+// LoadServices reads a services(5) file: `<name> <port>/<protocol>
+// [aliases...]` lines, `#` starts a comment. When a port has several
+// entries for the same protocol the first one wins.
+func LoadServices(path string) (Services, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := Services{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		port, ok := parsePort(fields[1])
+		if !ok || port.L4 == "" {
+			continue
+		}
+		if _, seen := s[port]; !seen {
+			s[port] = strings.ToLower(fields[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parsePort parses `<port>` or `<port>/<l4>` (services(5) order).
+func parsePort(s string) (Port, bool) {
+	num, l4, _ := strings.Cut(s, "/")
+	n, err := strconv.Atoi(num)
+	if err != nil || n <= 0 || n > 65535 {
+		return Port{}, false
+	}
+	return Port{L4: strings.ToLower(l4), Num: n}, true
+}
+
+// L7Protocol is L7ProtocolFromDPort, falling back to s for ports missing
+// from the built-in list. A nil Services only uses the built-in list.
+func (s Services) L7Protocol(l4, dport string) string {
+	l7 := L7ProtocolFromDPort(l4, dport)
+	if l7 != "unknown" || len(s) == 0 {
+		return l7
+	}
+	p, err := strconv.Atoi(dport)
+	if err != nil {
+		return l7
+	}
+	if name, ok := s[Port{L4: l4, Num: p}]; ok {
+		return name
+	}
+	return l7
+}
@@ -0,0 +1,124 @@
+package ports
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// Mapping maps ports to user-defined protocol names.
+//
+// The file format is a YAML map of ports to names. A port applies to all
+// transport protocols, `<port>/<l4>` to one of them only and takes
+// precedence:
+//
+//	8443: https
+//	9000: minio
+//	443/udp: quic
+type Mapping map[Port]string
+
+// LoadMapping reads a mapping file.
+func LoadMapping(path string) (Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := yaml.UnmarshalStrict(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m, err := NewMapping(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// NewMapping builds a mapping from names by port, in the format of mapping
+// files (e.g. "9000" or "443/udp").
+func NewMapping(raw map[string]string) (Mapping, error) {
+	m := make(Mapping, len(raw))
+	for key, name := range raw {
+		port, ok := parsePort(key)
+		if !ok {
+			return nil, fmt.Errorf("invalid port %q", key)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty name for port %q", key)
+		}
+		m[port] = name
+	}
+	return m, nil
+}
+
+// Table names destination ports: the user mapping first, then the built-in
+// list (L7ProtocolFromDPort), then Services. A nil Table only uses the
+// built-in list.
+type Table struct {
+	Services Services
+
+	mapping atomic.Pointer[Mapping]
+}
+
+// SetMapping replaces the user mapping; safe for concurrent use with
+// L7Protocol.
+func (t *Table) SetMapping(m Mapping) {
+	t.mapping.Store(&m)
+}
+
+// L7Protocol returns the protocol name of a destination port.
+func (t *Table) L7Protocol(l4, dport string) string {
+	if t == nil {
+		return L7ProtocolFromDPort(l4, dport)
+	}
+	if m := t.mapping.Load(); m != nil && len(*m) > 0 {
+		if p, err := strconv.Atoi(dport); err == nil {
+			if name, ok := (*m)[Port{L4: l4, Num: p}]; ok {
+				return name
+			}
+			if name, ok := (*m)[Port{Num: p}]; ok {
+				return name
+			}
+		}
+	}
+	return t.Services.L7Protocol(l4, dport)
+}
+
+// WatchMapping reloads the mapping file into t when its modification time
+// changes, checking every interval until ctx is done. A file that fails to
+// load keeps the previous mapping.
+func (t *Table) WatchMapping(ctx context.Context, path string, interval time.Duration, log *logging.Logger) {
+	var mtime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		mtime = fi.ModTime()
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil || fi.ModTime().Equal(mtime) {
+			continue
+		}
+		mtime = fi.ModTime()
+		m, err := LoadMapping(path)
+		if err != nil {
+			log.Warn("failed to reload port mapping file", "file", path, "err", err)
+			continue
+		}
+		t.SetMapping(m)
+		log.Info("reloaded port mapping file", "file", path, "ports", len(m))
+	}
+}
@@ -1,6 +1,7 @@
 package procfs
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 )
@@ -15,8 +16,14 @@ import (
 // --path.procfs to a custom directory layout (e.g. `.code`).
 type FS struct {
 	Root string
+	// ReadOnly makes WriteFile fail (--no-write), so that the exporter can
+	// never change kernel state.
+	ReadOnly bool
 }
 
+// ErrReadOnly is returned by WriteFile on a read-only FS.
+var ErrReadOnly = errors.New("procfs is read-only (--no-write)")
+
 func (fs FS) Path(rel string) string {
 	return filepath.Join(fs.Root, rel)
 }
@@ -25,7 +32,15 @@ func (fs FS) ReadFile(rel string) ([]byte, error) {
 	return os.ReadFile(fs.Path(rel))
 }
 
+// Open opens a file for streaming reads. Large files such as
+// `net/nf_conntrack` should be read this way instead of with ReadFile.
+func (fs FS) Open(rel string) (*os.File, error) {
+	return os.Open(fs.Path(rel))
+}
+
 func (fs FS) WriteFile(rel string, data []byte, perm os.FileMode) error {
+	if fs.ReadOnly {
+		return &os.PathError{Op: "write", Path: fs.Path(rel), Err: ErrReadOnly}
+	}
 	return os.WriteFile(fs.Path(rel), data, perm)
 }
-
@@ -0,0 +1,116 @@
+package rdns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver resolves IP addresses to names with PTR lookups.
+//
+// Name never blocks: a cache miss starts a lookup in the background and
+// returns "", the name shows up once the lookup has completed. Lookups are
+// limited to Concurrency at a time; misses above the limit are retried on a
+// later call.
+type Resolver struct {
+	// TTL of resolved names, and of failed lookups (negative caching).
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	// Timeout of a single lookup.
+	Timeout time.Duration
+	// Concurrency is the maximum number of lookups in flight.
+	Concurrency int
+	// CacheSize bounds the number of cached addresses.
+	CacheSize int
+
+	// Lookup is net.DefaultResolver.LookupAddr when nil.
+	Lookup func(ctx context.Context, addr string) ([]string, error)
+
+	once     sync.Once
+	mu       sync.Mutex
+	cache    map[string]entry
+	inFlight map[string]struct{}
+	sem      chan struct{}
+}
+
+type entry struct {
+	name    string
+	expires time.Time
+}
+
+func (r *Resolver) init() {
+	r.cache = map[string]entry{}
+	r.inFlight = map[string]struct{}{}
+	r.sem = make(chan struct{}, max(r.Concurrency, 1))
+	if r.Lookup == nil {
+		r.Lookup = net.DefaultResolver.LookupAddr
+	}
+}
+
+// Name returns the cached name of addr, "" when unknown (yet).
+func (r *Resolver) Name(addr string) string {
+	r.once.Do(r.init)
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.cache[addr]
+	if ok && now.Before(e.expires) {
+		return e.name
+	}
+	if _, ok := r.inFlight[addr]; ok {
+		return e.name
+	}
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		// Too many lookups in flight.
+		return e.name
+	}
+	r.inFlight[addr] = struct{}{}
+	go r.resolve(addr)
+	// A stale name is still better than none while the lookup runs.
+	return e.name
+}
+
+func (r *Resolver) resolve(addr string) {
+	defer func() { <-r.sem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	names, err := r.Lookup(ctx, addr)
+	cancel()
+
+	e := entry{expires: time.Now().Add(r.NegativeTTL)}
+	if err == nil && len(names) > 0 {
+		e = entry{
+			name:    strings.TrimSuffix(names[0], "."),
+			expires: time.Now().Add(r.TTL),
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.inFlight, addr)
+	if _, ok := r.cache[addr]; !ok && len(r.cache) >= r.CacheSize {
+		r.evict(time.Now())
+	}
+	r.cache[addr] = e
+}
+
+// evict makes room in a full cache: expired entries go first, then any entry.
+// Callers must hold r.mu.
+func (r *Resolver) evict(now time.Time) {
+	for addr, e := range r.cache {
+		if e.expires.Before(now) {
+			delete(r.cache, addr)
+		}
+	}
+	for addr := range r.cache {
+		if len(r.cache) < r.CacheSize {
+			break
+		}
+		delete(r.cache, addr)
+	}
+}
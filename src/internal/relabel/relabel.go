@@ -0,0 +1,154 @@
+package relabel
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Action is what a rule does with the labels matching its regex.
+type Action string
+
+const (
+	// Replace sets TargetLabel to Replacement, expanded with the regex
+	// groups, when the regex matches.
+	Replace Action = "replace"
+	// Keep drops the entry unless the regex matches.
+	Keep Action = "keep"
+	// Drop drops the entry when the regex matches.
+	Drop Action = "drop"
+	// Lowercase and Uppercase set TargetLabel to the case-converted
+	// concatenated source labels.
+	Lowercase Action = "lowercase"
+	Uppercase Action = "uppercase"
+)
+
+// Config is a relabeling rule, with the semantics of Prometheus
+// relabel_configs: the values of SourceLabels are joined with Separator and
+// matched against Regex (anchored at both ends).
+type Config struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       Action   `yaml:"action"`
+
+	re *regexp.Regexp
+}
+
+// Labels is a set of labels rules are applied to.
+type Labels interface {
+	Get(name string) string
+	Set(name, value string)
+}
+
+// Load reads a YAML list of rules.
+func Load(path string) ([]*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []*Config
+	if err := yaml.UnmarshalStrict(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := Compile(cfgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfgs, nil
+}
+
+// Compile applies defaults to rules decoded from YAML, and validates them.
+func Compile(cfgs []*Config) error {
+	for i, c := range cfgs {
+		if err := c.init(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// init applies defaults and compiles the regex.
+func (c *Config) init() error {
+	if c.Separator == nil {
+		c.Separator = ptr(";")
+	}
+	if c.Regex == nil {
+		c.Regex = ptr("(.*)")
+	}
+	if c.Replacement == nil {
+		c.Replacement = ptr("$1")
+	}
+	if c.Action == "" {
+		c.Action = Replace
+	}
+
+	re, err := regexp.Compile("^(?:" + *c.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	c.re = re
+
+	switch c.Action {
+	case Replace, Lowercase, Uppercase:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("%s action requires target_label", c.Action)
+		}
+	case Keep, Drop:
+		if len(c.SourceLabels) == 0 {
+			return fmt.Errorf("%s action requires source_labels", c.Action)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", c.Action)
+	}
+	return nil
+}
+
+func ptr(s string) *string { return &s }
+
+// Names returns the label names a rule refers to.
+func (c *Config) Names() []string {
+	names := c.SourceLabels
+	if c.TargetLabel != "" {
+		names = append(names[:len(names):len(names)], c.TargetLabel)
+	}
+	return names
+}
+
+// Process applies the rules in order. It returns false when the labels are
+// to be dropped.
+func Process(cfgs []*Config, ls Labels) bool {
+	for _, c := range cfgs {
+		vals := make([]string, len(c.SourceLabels))
+		for i, name := range c.SourceLabels {
+			vals[i] = ls.Get(name)
+		}
+		val := strings.Join(vals, *c.Separator)
+
+		switch c.Action {
+		case Keep:
+			if !c.re.MatchString(val) {
+				return false
+			}
+		case Drop:
+			if c.re.MatchString(val) {
+				return false
+			}
+		case Replace:
+			m := c.re.FindStringSubmatchIndex(val)
+			if m == nil {
+				continue
+			}
+			ls.Set(c.TargetLabel, string(c.re.ExpandString(nil, *c.Replacement, val, m)))
+		case Lowercase:
+			ls.Set(c.TargetLabel, strings.ToLower(val))
+		case Uppercase:
+			ls.Set(c.TargetLabel, strings.ToUpper(val))
+		}
+	}
+	return true
+}
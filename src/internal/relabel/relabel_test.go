@@ -0,0 +1,142 @@
+package relabel
+
+import (
+	"maps"
+	"testing"
+)
+
+// labels is a map of labels, unset labels read as empty.
+type labels map[string]string
+
+func (l labels) Get(name string) string { return l[name] }
+
+func (l labels) Set(name, value string) { l[name] = value }
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []*Config
+		labels labels
+		want   labels // nil when dropped
+	}{
+		{
+			name:   "defaults copy the joined values",
+			rules:  []*Config{{SourceLabels: []string{"src", "dport"}, TargetLabel: "set"}},
+			labels: labels{"src": "10.0.0.1", "dport": "443"},
+			want:   labels{"src": "10.0.0.1", "dport": "443", "set": "10.0.0.1;443"},
+		},
+		{
+			name:   "replacement with groups",
+			rules:  []*Config{{SourceLabels: []string{"dst"}, Regex: ptr(`10\.(\d+)\..*`), TargetLabel: "service", Replacement: ptr("net-$1")}},
+			labels: labels{"dst": "10.42.0.7"},
+			want:   labels{"dst": "10.42.0.7", "service": "net-42"},
+		},
+		{
+			name:   "anchored regex",
+			rules:  []*Config{{SourceLabels: []string{"dst"}, Regex: ptr(`10\.0\.0\.1`), TargetLabel: "service", Replacement: ptr("dns")}},
+			labels: labels{"dst": "10.0.0.10"},
+			want:   labels{"dst": "10.0.0.10"},
+		},
+		{
+			name:   "replace on no match leaves the target unset",
+			rules:  []*Config{{SourceLabels: []string{"dport"}, Regex: ptr("53"), TargetLabel: "service", Replacement: ptr("dns")}},
+			labels: labels{"dport": "443"},
+			want:   labels{"dport": "443"},
+		},
+		{
+			name:   "replace on no match keeps the target",
+			rules:  []*Config{{SourceLabels: []string{"dport"}, Regex: ptr("53"), TargetLabel: "service", Replacement: ptr("dns")}},
+			labels: labels{"dport": "443", "service": "web"},
+			want:   labels{"dport": "443", "service": "web"},
+		},
+		{
+			name:   "custom separator",
+			rules:  []*Config{{SourceLabels: []string{"l4protocol", "dport"}, Separator: ptr("/"), TargetLabel: "set"}},
+			labels: labels{"l4protocol": "udp", "dport": "53"},
+			want:   labels{"l4protocol": "udp", "dport": "53", "set": "udp/53"},
+		},
+		{
+			name:   "keep match",
+			rules:  []*Config{{SourceLabels: []string{"l4protocol"}, Regex: ptr("tcp|udp"), Action: Keep}},
+			labels: labels{"l4protocol": "udp"},
+			want:   labels{"l4protocol": "udp"},
+		},
+		{
+			name:   "keep no match",
+			rules:  []*Config{{SourceLabels: []string{"l4protocol"}, Regex: ptr("tcp|udp"), Action: Keep}},
+			labels: labels{"l4protocol": "icmp"},
+		},
+		{
+			name:   "keep joined empty values",
+			rules:  []*Config{{SourceLabels: []string{"service", "service_namespace"}, Regex: ptr(";"), Action: Keep}},
+			labels: labels{"dst": "10.0.0.1"},
+			want:   labels{"dst": "10.0.0.1"},
+		},
+		{
+			name:   "drop joined empty values",
+			rules:  []*Config{{SourceLabels: []string{"service", "service_namespace"}, Regex: ptr(";"), Action: Drop}},
+			labels: labels{"dst": "10.0.0.1"},
+		},
+		{
+			name:   "drop empty value with the default regex",
+			rules:  []*Config{{SourceLabels: []string{"service"}, Action: Drop}},
+			labels: labels{"service": "dns"},
+		},
+		{
+			name:   "drop no match",
+			rules:  []*Config{{SourceLabels: []string{"service"}, Regex: ptr(".+"), Action: Drop}},
+			labels: labels{"dst": "10.0.0.1"},
+			want:   labels{"dst": "10.0.0.1"},
+		},
+		{
+			name:   "lowercase",
+			rules:  []*Config{{SourceLabels: []string{"dst_name"}, TargetLabel: "dst_name", Action: Lowercase}},
+			labels: labels{"dst_name": "Host.Example."},
+			want:   labels{"dst_name": "host.example."},
+		},
+		{
+			name: "rules apply in order",
+			rules: []*Config{
+				{SourceLabels: []string{"dport"}, Regex: ptr("53"), TargetLabel: "service", Replacement: ptr("dns")},
+				{SourceLabels: []string{"service"}, Regex: ptr("dns"), Action: Drop},
+			},
+			labels: labels{"dport": "53"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Compile(tt.rules); err != nil {
+				t.Fatal(err)
+			}
+			got := maps.Clone(tt.labels)
+			kept := Process(tt.rules, got)
+			switch {
+			case tt.want == nil && kept:
+				t.Errorf("Process(%v) kept %v, want dropped", tt.labels, got)
+			case tt.want != nil && !kept:
+				t.Errorf("Process(%v) dropped, want %v", tt.labels, tt.want)
+			case tt.want != nil && !maps.Equal(got, tt.want):
+				t.Errorf("Process(%v)\n got %v\nwant %v", tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Config
+	}{
+		{"invalid regex", Config{SourceLabels: []string{"dst"}, Regex: ptr("("), TargetLabel: "service"}},
+		{"replace without target", Config{SourceLabels: []string{"dst"}}},
+		{"keep without sources", Config{Action: Keep}},
+		{"unknown action", Config{SourceLabels: []string{"dst"}, Action: "hashmod"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Compile([]*Config{&tt.rule}); err == nil {
+				t.Errorf("Compile(%+v) succeeded", tt.rule)
+			}
+		})
+	}
+}
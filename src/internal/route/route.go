@@ -0,0 +1,204 @@
+package route
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"conntrack-exporter/internal/logging"
+	"conntrack-exporter/internal/procfs"
+)
+
+// Route flags (include/uapi/linux/route.h).
+const (
+	rtfUp     = 0x0001
+	rtfReject = 0x0200
+	rtfLocal  = 0x80000000
+)
+
+// route is a routing table entry.
+type route struct {
+	prefix netip.Prefix
+	metric uint32
+	iface  string
+}
+
+// Routes maps addresses to the interface of the route to them, from the
+// routing tables in procfs: the IPv4 main table (`net/route`) and the IPv6
+// routes (`net/ipv6_route`). Policy routing rules are not taken into account.
+type Routes struct {
+	FS       procfs.FS
+	Interval time.Duration
+	Logger   *logging.Logger
+
+	mu     sync.RWMutex
+	routes []route
+}
+
+// Lookup returns the interface of the most specific route to addr (lowest
+// metric first among equal prefixes). Loopback addresses are routed to lo.
+func (r *Routes) Lookup(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	if addr.IsLoopback() {
+		return "lo", true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// Routes are sorted by decreasing prefix length, then metric.
+	for _, rt := range r.routes {
+		if rt.prefix.Contains(addr) {
+			return rt.iface, true
+		}
+	}
+	return "", false
+}
+
+// Run refreshes the routes every Interval until ctx is done, starting one
+// Interval from now (see Refresh for the initial routes). A failed refresh
+// keeps the previous routes.
+func (r *Routes) Run(ctx context.Context) {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := r.Refresh(); err != nil {
+			r.Logger.Warn("failed to refresh routes", "err", err)
+		}
+	}
+}
+
+// Refresh reads the routing tables and replaces the routes. A missing IPv6
+// table (IPv6 disabled) is not an error.
+func (r *Routes) Refresh() error {
+	v4, err := r.read("net/route", parseRoute4)
+	if err != nil {
+		return err
+	}
+	v6, err := r.read("net/ipv6_route", parseRoute6)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	routes := append(v4, v6...)
+	slices.SortStableFunc(routes, func(a, b route) int {
+		if r := cmp.Compare(b.prefix.Bits(), a.prefix.Bits()); r != 0 {
+			return r
+		}
+		return cmp.Compare(a.metric, b.metric)
+	})
+	r.mu.Lock()
+	r.routes = routes
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Routes) read(rel string, parse func(fields []string) (route, bool, error)) ([]route, error) {
+	f, err := r.FS.Open(rel)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseTable(f, rel, parse)
+}
+
+func parseTable(rd io.Reader, name string, parse func(fields []string) (route, bool, error)) ([]route, error) {
+	var routes []route
+	sc := bufio.NewScanner(rd)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] == "Iface" {
+			continue
+		}
+		rt, ok, err := parse(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", name, n, err)
+		}
+		if ok {
+			routes = append(routes, rt)
+		}
+	}
+	return routes, sc.Err()
+}
+
+// parseRoute4 parses a `net/route` line:
+//
+//	Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+//
+// Addresses are hex in host byte order (little-endian on supported
+// architectures).
+func parseRoute4(fields []string) (route, bool, error) {
+	if len(fields) < 8 {
+		return route{}, false, fmt.Errorf("expected at least 8 fields, got %d", len(fields))
+	}
+	dst, err1 := hex.DecodeString(fields[1])
+	flags, err2 := strconv.ParseUint(fields[3], 16, 32)
+	metric, err3 := strconv.ParseUint(fields[6], 10, 32)
+	mask, err4 := hex.DecodeString(fields[7])
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return route{}, false, err
+	}
+	if len(dst) != 4 || len(mask) != 4 {
+		return route{}, false, fmt.Errorf("invalid destination %q/%q", fields[1], fields[7])
+	}
+	if flags&rtfUp == 0 || flags&rtfReject != 0 {
+		return route{}, false, nil
+	}
+	bits := 0
+	for m := binary.LittleEndian.Uint32(mask); m != 0; m >>= 1 {
+		bits += int(m & 1)
+	}
+	addr := netip.AddrFrom4([4]byte{dst[3], dst[2], dst[1], dst[0]})
+	return route{
+		prefix: netip.PrefixFrom(addr, bits).Masked(),
+		metric: uint32(metric),
+		iface:  fields[0],
+	}, true, nil
+}
+
+// parseRoute6 parses a `net/ipv6_route` line:
+//
+//	dst dst_len src src_len next_hop metric refcnt use flags iface
+//
+// with addresses as 32 hex digits and numbers in hex.
+func parseRoute6(fields []string) (route, bool, error) {
+	if len(fields) < 10 {
+		return route{}, false, fmt.Errorf("expected at least 10 fields, got %d", len(fields))
+	}
+	dst, err1 := hex.DecodeString(fields[0])
+	bits, err2 := strconv.ParseUint(fields[1], 16, 8)
+	metric, err3 := strconv.ParseUint(fields[5], 16, 32)
+	flags, err4 := strconv.ParseUint(fields[8], 16, 32)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return route{}, false, err
+	}
+	if len(dst) != 16 || bits > 128 {
+		return route{}, false, fmt.Errorf("invalid destination %q/%s", fields[0], fields[1])
+	}
+	// Unreachable routes show up as reject routes on lo; local addresses
+	// are left to the route of their subnet, as for IPv4.
+	if flags&rtfUp == 0 || flags&(rtfReject|rtfLocal) != 0 {
+		return route{}, false, nil
+	}
+	addr := netip.AddrFrom16([16]byte(dst))
+	return route{
+		prefix: netip.PrefixFrom(addr, int(bits)).Masked(),
+		metric: uint32(metric),
+		iface:  fields[9],
+	}, true, nil
+}
@@ -44,20 +44,25 @@ func ReadNfConntrackAcct(fs procfs.FS) (int, error) {
 // a warning and continue running (exporter can still work, but bytes/packets
 // may be missing from conntrack entries).
 func ConfigureNfConntrackAcct(fs procfs.FS) error {
+	return WriteNfConntrackAcct(fs, 1)
+}
+
+// WriteNfConntrackAcct sets net.netfilter.nf_conntrack_acct to v, e.g. to
+// restore the value found at startup.
+func WriteNfConntrackAcct(fs procfs.FS, v int) error {
 	// The sysctl proc file expects a newline-terminated value.
-	if err := fs.WriteFile(nfConntrackAcctRelPath, []byte("1\n"), 0o644); err != nil {
+	if err := fs.WriteFile(nfConntrackAcctRelPath, []byte(strconv.Itoa(v)+"\n"), 0o644); err != nil {
 		return err
 	}
 
 	// Verify after writing (best-effort).
-	v, err := ReadNfConntrackAcct(fs)
+	cur, err := ReadNfConntrackAcct(fs)
 	if err != nil {
 		return err
 	}
-	if v != 1 {
-		return fmt.Errorf("failed to set %s to 1 (current=%d)", fs.Path(nfConntrackAcctRelPath), v)
+	if cur != v {
+		return fmt.Errorf("failed to set %s to %d (current=%d)", fs.Path(nfConntrackAcctRelPath), v, cur)
 	}
 
 	return nil
 }
-
@@ -0,0 +1,47 @@
+package sysctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"conntrack-exporter/internal/procfs"
+)
+
+// Table size sysctls (procfs-relative paths):
+//
+//	net.netfilter.nf_conntrack_count: current number of entries (read-only)
+//	net.netfilter.nf_conntrack_max:   maximum number of entries
+const (
+	nfConntrackCountRelPath = "sys/net/netfilter/nf_conntrack_count"
+	nfConntrackMaxRelPath   = "sys/net/netfilter/nf_conntrack_max"
+)
+
+// ReadNfConntrackCount returns the current number of conntrack entries.
+func ReadNfConntrackCount(fs procfs.FS) (uint64, error) {
+	return readUint(fs, nfConntrackCountRelPath)
+}
+
+// ReadNfConntrackMax returns the maximum number of conntrack entries.
+func ReadNfConntrackMax(fs procfs.FS) (uint64, error) {
+	return readUint(fs, nfConntrackMaxRelPath)
+}
+
+func readUint(fs procfs.FS, rel string) (uint64, error) {
+	b, err := fs.ReadFile(rel)
+	if err != nil {
+		return 0, err
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, fmt.Errorf("%s is empty", fs.Path(rel))
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q: %w", fs.Path(rel), s, err)
+	}
+
+	return v, nil
+}
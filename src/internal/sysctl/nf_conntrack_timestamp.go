@@ -0,0 +1,44 @@
+package sysctl
+
+import (
+	"fmt"
+	"strconv"
+
+	"conntrack-exporter/internal/procfs"
+)
+
+// nfConntrackTimestampRelPath is the procfs-relative path to
+// net.netfilter.nf_conntrack_timestamp.
+//
+// When set to 1, the kernel records the start time of new connections and
+// prints their age (`delta-time=`) in `/proc/net/nf_conntrack`.
+const nfConntrackTimestampRelPath = "sys/net/netfilter/nf_conntrack_timestamp"
+
+// ReadNfConntrackTimestamp returns the current value of net.netfilter.nf_conntrack_timestamp.
+func ReadNfConntrackTimestamp(fs procfs.FS) (uint64, error) {
+	return readUint(fs, nfConntrackTimestampRelPath)
+}
+
+// ConfigureNfConntrackTimestamp attempts to set net.netfilter.nf_conntrack_timestamp=1.
+//
+// Only connections created after the change get a timestamp.
+func ConfigureNfConntrackTimestamp(fs procfs.FS) error {
+	return WriteNfConntrackTimestamp(fs, 1)
+}
+
+// WriteNfConntrackTimestamp sets net.netfilter.nf_conntrack_timestamp to v.
+func WriteNfConntrackTimestamp(fs procfs.FS, v uint64) error {
+	if err := fs.WriteFile(nfConntrackTimestampRelPath, []byte(strconv.FormatUint(v, 10)+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	cur, err := ReadNfConntrackTimestamp(fs)
+	if err != nil {
+		return err
+	}
+	if cur != v {
+		return fmt.Errorf("failed to set %s to %d (current=%d)", fs.Path(nfConntrackTimestampRelPath), v, cur)
+	}
+
+	return nil
+}
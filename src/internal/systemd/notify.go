@@ -0,0 +1,48 @@
+// Package systemd implements the sd_notify(3) protocol, used by services
+// of Type=notify to report readiness and feed the watchdog.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends state (e.g. "READY=1") to the service manager. It does
+// nothing and returns nil when not run by systemd (NOTIFY_SOCKET unset).
+func Notify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// Abstract socket names start with a NUL byte, written as @.
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Enabled reports whether the process is run by systemd with a notification
+// socket (Type=notify).
+func Enabled() bool { return os.Getenv("NOTIFY_SOCKET") != "" }
+
+// WatchdogInterval returns the watchdog timeout of the service
+// (WatchdogSec=), or 0 when the watchdog is disabled or meant for another
+// process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec == 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,69 @@
+// Package tracing sets up OpenTelemetry tracing of the exporter, exported
+// with OTLP over HTTP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// Setup installs the global tracer provider: spans are exported to
+// endpoint, an OTLP/HTTP URL (e.g. http://localhost:4318, /v1/traces when
+// it has no path), and sampleRatio
+// of the traces are sampled (following the sampling decision of the caller
+// for traced requests). The standard OTEL_* environment variables apply
+// (e.g. OTEL_EXPORTER_OTLP_HEADERS, OTEL_RESOURCE_ATTRIBUTES).
+//
+// The returned function flushes pending spans and stops the exporter.
+func Setup(ctx context.Context, endpoint string, sampleRatio float64, version string, log *logging.Logger) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q, must be an http:// or https:// URL", endpoint)
+	}
+	// Collectors receive traces on /v1/traces; keep a custom path (e.g.
+	// behind a proxy).
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "conntrack-exporter"),
+			attribute.String("service.version", version),
+		),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Warn("tracing error", "err", err)
+	}))
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"conntrack-exporter/internal/logging"
+)
+
+// registerAdmin registers the operational endpoints:
+//
+//	/-/healthy      200 while the process serves HTTP
+//	/-/ready        200 while Ready passes, 503 otherwise
+//	/-/loglevel     GET the log levels, PUT new ones (body or level parameter;
+//	                admin listener or basic auth only)
+//	/-/reload       POST or PUT: Reload
+//	/-/quit         POST or PUT: Quit
+//	/debug/pprof/   profiles (EnablePprof; admin listener or basic auth only)
+func (s *Server) registerAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK\n"))
+	})
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		if s.Ready != nil {
+			if err := s.Ready(); err != nil {
+				http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		_, _ = w.Write([]byte("OK\n"))
+	})
+	if s.Logger != nil {
+		// Without an admin listener, the endpoint is on the metrics
+		// listeners: only authenticated clients may change the level.
+		mux.Handle("/-/loglevel", logLevelHandler(s.Logger, s.LogComponents, len(s.AdminListenAddrs) > 0 || s.WebConfig.BasicAuth()))
+	}
+	if s.Reload != nil {
+		mux.Handle("/-/reload", lifecycleHandler(func(w http.ResponseWriter) {
+			if err := s.Reload(); err != nil {
+				http.Error(w, "failed to reload configuration: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			_, _ = io.WriteString(w, "OK\n")
+		}))
+	}
+	if s.Quit != nil {
+		mux.Handle("/-/quit", lifecycleHandler(func(w http.ResponseWriter) {
+			if s.Logger != nil {
+				s.Logger.Info("quit requested")
+			}
+			_, _ = io.WriteString(w, "Requesting termination... Goodbye!\n")
+			s.Quit()
+		}))
+	}
+	if s.EnablePprof && (len(s.AdminListenAddrs) > 0 || s.WebConfig.BasicAuth()) {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+}
+
+// logLevelHandler gets and, when canChange, changes the levels of log and
+// of components at runtime (see logging.ParseLevels).
+func logLevelHandler(log *logging.Logger, components []string, canChange bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPost:
+			if !canChange {
+				http.Error(w, "changing the log level requires --web.admin-listen-address or basic auth", http.StatusForbidden)
+				return
+			}
+			value := r.URL.Query().Get("level")
+			if value == "" {
+				body, err := io.ReadAll(io.LimitReader(r.Body, 256))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				value = strings.TrimSpace(string(body))
+			}
+			levels, err := logging.ParseLevels(value, components...)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if old := log.Levels(); old.String() != levels.String() {
+				log.SetLevels(levels)
+				// At warn level, so that it shows whatever the new level.
+				log.Warn("log level changed", "from", old.String(), "to", levels.String())
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = io.WriteString(w, log.Levels().String()+"\n")
+	})
+}
+
+// lifecycleHandler calls do on POST or PUT requests.
+func lifecycleHandler(do func(http.ResponseWriter)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		do(w)
+	})
+}
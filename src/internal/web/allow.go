@@ -0,0 +1,76 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AllowList rejects requests from clients outside a set of prefixes. Clients
+// of Unix domain sockets are always allowed: file permissions control them.
+type AllowList struct {
+	prefixes []netip.Prefix
+	rejected prometheus.Counter
+}
+
+// NewAllowList parses prefixes (e.g. 10.0.0.0/8, 2001:db8::/32); a bare
+// address allows that address only.
+func NewAllowList(cidrs []string) (*AllowList, error) {
+	l := &AllowList{}
+	for _, s := range cidrs {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		l.prefixes = append(l.prefixes, p.Masked())
+	}
+	l.rejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_http_requests_rejected_total",
+		Help: "Number of HTTP requests rejected because the client is not in --web.allow-cidr.",
+	})
+	return l, nil
+}
+
+// MustRegister registers all metrics into the provided registry.
+func (l *AllowList) MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(l.rejected)
+}
+
+// Allowed reports whether the client at remoteAddr (host:port, as in
+// http.Request) is allowed.
+func (l *AllowList) Allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range l.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *AllowList) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		if (local == nil || local.Network() != "unix") && !l.Allowed(r.RemoteAddr) {
+			l.rejected.Inc()
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
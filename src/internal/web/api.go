@@ -0,0 +1,189 @@
+package web
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"conntrack-exporter/internal/collector"
+)
+
+// flowSorts are the sort keys of flows, by name of the sort parameter.
+var flowSorts = map[string]func(collector.Flow) uint64{
+	"bytes":         func(f collector.Flow) uint64 { return f.SentBytes + f.ReplyBytes },
+	"packets":       func(f collector.Flow) uint64 { return f.SentPackets + f.ReplyPackets },
+	"connections":   func(f collector.Flow) uint64 { return f.Connections },
+	"sent_bytes":    func(f collector.Flow) uint64 { return f.SentBytes },
+	"sent_packets":  func(f collector.Flow) uint64 { return f.SentPackets },
+	"reply_bytes":   func(f collector.Flow) uint64 { return f.ReplyBytes },
+	"reply_packets": func(f collector.Flow) uint64 { return f.ReplyPackets },
+}
+
+// flowQuery is a query of the flows of the last snapshot:
+//
+//	l4proto, dport, src_cidr, dst_cidr  filters, as for scrapes (see scope)
+//	sort=bytes                          bytes|packets|connections|sent_bytes|... (descending)
+//	limit=100                           number of flows (0: all)
+type flowQuery struct {
+	scope *scope
+	sort  string
+	limit int
+}
+
+func parseFlowQuery(r *http.Request) (flowQuery, error) {
+	q := r.URL.Query()
+	fq := flowQuery{sort: "bytes", limit: 100}
+	sc, err := parseScope(q, nil)
+	if err != nil {
+		return fq, err
+	}
+	fq.scope = sc
+	if s := q.Get("sort"); s != "" {
+		if _, ok := flowSorts[s]; !ok {
+			return fq, fmt.Errorf("invalid sort %q", s)
+		}
+		fq.sort = s
+	}
+	if s := q.Get("limit"); s != "" {
+		if fq.limit, err = strconv.Atoi(s); err != nil || fq.limit < 0 {
+			return fq, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	return fq, nil
+}
+
+// apply filters, sorts and limits flows. It returns the number of flows that
+// passed the filters, before the limit.
+func (fq flowQuery) apply(flows []collector.Flow) ([]collector.Flow, int) {
+	if fq.scope != nil {
+		flows = slices.DeleteFunc(flows, func(f collector.Flow) bool {
+			for name, value := range f.Labels {
+				if !fq.scope.matchLabel(name, value) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	by := flowSorts[fq.sort]
+	slices.SortFunc(flows, func(a, b collector.Flow) int {
+		return cmp.Compare(by(b), by(a))
+	})
+	total := len(flows)
+	if fq.limit > 0 && len(flows) > fq.limit {
+		flows = flows[:fq.limit]
+	}
+	return flows, total
+}
+
+// flowsResponse is the response of the connections API.
+type flowsResponse struct {
+	// Time is when the snapshot was taken (null before the first one).
+	Time  *time.Time       `json:"time"`
+	Total int              `json:"total"`
+	Flows []collector.Flow `json:"flows"`
+}
+
+// apiHandler serves the flows of the last snapshot on /api/v1/connections,
+// as JSON or CSV: the format parameter (json|csv) when set, else the
+// preferred type of the Accept header (JSON by default).
+func apiHandler(flowsOf func() ([]collector.Flow, time.Time)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fq, err := parseFlowQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "json", "csv":
+		case "":
+			format = "json"
+			if negotiate(r.Header.Get("Accept"), "application/json", "text/csv") == "text/csv" {
+				format = "csv"
+			}
+		default:
+			http.Error(w, fmt.Sprintf("invalid format %q", format), http.StatusBadRequest)
+			return
+		}
+
+		flows, at := flowsOf()
+		resp := flowsResponse{Flows: []collector.Flow{}}
+		if !at.IsZero() {
+			resp.Time = &at
+			w.Header().Set("Last-Modified", at.UTC().Format(http.TimeFormat))
+		}
+		if flows != nil {
+			resp.Flows, resp.Total = fq.apply(flows)
+		}
+		w.Header().Add("Vary", "Accept")
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			_ = writeFlowsCSV(w, resp.Flows)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// writeFlowsCSV writes flows as CSV: a header line with the label names
+// (sorted) and the values, then a line per flow.
+func writeFlowsCSV(w io.Writer, flows []collector.Flow) error {
+	var names []string
+	for _, f := range flows {
+		for name := range f.Labels {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write(append(slices.Clone(names), "connections", "sent_packets", "sent_bytes", "reply_packets", "reply_bytes"))
+	for _, f := range flows {
+		record := make([]string, 0, len(names)+5)
+		for _, name := range names {
+			record = append(record, f.Labels[name])
+		}
+		for _, v := range []uint64{f.Connections, f.SentPackets, f.SentBytes, f.ReplyPackets, f.ReplyBytes} {
+			record = append(record, strconv.FormatUint(v, 10))
+		}
+		_ = cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// negotiate returns the offer preferred by an Accept header (the first offer
+// when none is acceptable, or the header is empty).
+func negotiate(accept string, offers ...string) string {
+	best, bestQ := offers[0], 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		for _, offer := range offers {
+			if mediaType == offer && q > bestQ {
+				best, bestQ = offer, q
+			}
+		}
+	}
+	return best
+}
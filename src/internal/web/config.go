@@ -0,0 +1,181 @@
+package web
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	toolkit "github.com/prometheus/exporter-toolkit/web"
+	"go.yaml.in/yaml/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is a web configuration file, in the format of the Prometheus
+// exporter-toolkit (https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md),
+// which serves the listeners (see toolkit.Serve):
+//
+//	tls_server_config:
+//	  cert_file: server.crt
+//	  key_file: server.key
+//	  client_auth_type: RequireAndVerifyClientCert
+//	  client_ca_file: ca.crt
+//	http_server_config:
+//	  headers:
+//	    Strict-Transport-Security: max-age=31536000
+//	basic_auth_users:
+//	  prometheus: $2y$10$... # bcrypt hash
+//
+// The toolkit reads the file again for each connection and request, so that
+// changes (e.g. renewed certificates) apply without a restart.
+type Config struct {
+	path string
+	doc  yaml.MapSlice
+
+	// users are the basic auth users added with AddBasicAuthUser, by name,
+	// with their bcrypt hash.
+	users map[string]string
+}
+
+// LoadConfig reads and validates a web configuration file, including the
+// certificates it refers to.
+func LoadConfig(path string) (*Config, error) {
+	if err := toolkit.Validate(path); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{path: path}
+	if err := yaml.Unmarshal(data, &c.doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// AddBasicAuthUser adds a basic auth user, with its password read from
+// passwordFile (plain text, surrounding whitespace ignored).
+func (c *Config) AddBasicAuthUser(user, passwordFile string) error {
+	if _, ok := lookup(section(c.doc, "basic_auth_users"), user); ok || c.users[user] != "" {
+		return fmt.Errorf("basic auth user %q is already defined in the web config file", user)
+	}
+	data, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return err
+	}
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return fmt.Errorf("%s: empty password", passwordFile)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("%s: %w", passwordFile, err)
+	}
+	if c.users == nil {
+		c.users = map[string]string{}
+	}
+	c.users[user] = string(hash)
+	return nil
+}
+
+// BasicAuth reports whether clients must authenticate with basic auth.
+func (c *Config) BasicAuth() bool {
+	return c != nil && (len(section(c.doc, "basic_auth_users")) > 0 || len(c.users) > 0)
+}
+
+// TLS reports whether the listeners serve HTTPS.
+func (c *Config) TLS() bool {
+	if c == nil {
+		return false
+	}
+	t := section(c.doc, "tls_server_config")
+	for _, key := range []string{"cert", "cert_file"} {
+		if v, ok := lookup(t, key); ok && v != "" && v != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// file returns the file to pass to the toolkit, and a function removing it
+// once the listeners are closed. With users added by AddBasicAuthUser, this
+// is a private copy of the configuration file with the users added, its
+// relative paths made absolute; changes of the configuration file then
+// apply after a restart only.
+func (c *Config) file() (string, func(), error) {
+	if c == nil {
+		return "", func() {}, nil
+	}
+	if len(c.users) == 0 {
+		return c.path, func() {}, nil
+	}
+
+	doc := make(yaml.MapSlice, 0, len(c.doc)+1)
+	for _, item := range c.doc {
+		if item.Key != "basic_auth_users" {
+			doc = append(doc, item)
+		}
+	}
+	if t := section(c.doc, "tls_server_config"); t != nil && c.path != "" {
+		dir := filepath.Dir(c.path)
+		abs := make(yaml.MapSlice, 0, len(t))
+		for _, item := range t {
+			switch item.Key {
+			case "cert_file", "key_file", "client_ca_file":
+				if p, ok := item.Value.(string); ok && p != "" && !filepath.IsAbs(p) {
+					item.Value = filepath.Join(dir, p)
+				}
+			}
+			abs = append(abs, item)
+		}
+		for i := range doc {
+			if doc[i].Key == "tls_server_config" {
+				doc[i].Value = abs
+			}
+		}
+	}
+	users := append(yaml.MapSlice(nil), section(c.doc, "basic_auth_users")...)
+	for user, hash := range c.users {
+		users = append(users, yaml.MapItem{Key: user, Value: hash})
+	}
+	doc = append(doc, yaml.MapItem{Key: "basic_auth_users", Value: users})
+
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := os.CreateTemp("", "conntrack-exporter-web-*.yml")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() { _ = os.Remove(f.Name()) }
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = toolkit.Validate(f.Name())
+	}
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	return f.Name(), remove, nil
+}
+
+// section returns the mapping of key in doc, nil when missing.
+func section(doc yaml.MapSlice, key string) yaml.MapSlice {
+	v, _ := lookup(doc, key)
+	s, _ := v.(yaml.MapSlice)
+	return s
+}
+
+func lookup(doc yaml.MapSlice, key string) (any, bool) {
+	for _, item := range doc {
+		if k, ok := item.Key.(string); ok && k == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,285 @@
+package web
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testCA issues the certificates of the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate and key, in PEM, for a server (127.0.0.1) or
+// a client named name.
+func (ca *testCA) issue(t *testing.T, name string, client bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	} else {
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeServerFiles writes the CA and a server certificate to dir.
+func writeServerFiles(t *testing.T, dir string, ca *testCA) {
+	t.Helper()
+	cert, key := ca.issue(t, "server", false)
+	writeFile(t, dir, "ca.crt", ca.pem)
+	writeFile(t, dir, "server.crt", cert)
+	writeFile(t, dir, "server.key", key)
+}
+
+// serve starts a Server with webConfig on a free port and returns its
+// address.
+func serve(t *testing.T, webConfig *Config) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	srv := &Server{ListenAddrs: []string{addr}, WebConfig: webConfig}
+	go func() { done <- srv.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start() = %v", err)
+		}
+	})
+	for range 100 {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			return addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server did not listen on %s", addr)
+	return ""
+}
+
+func client(ca *testCA, certs ...tls.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs},
+	}}
+}
+
+func get(t *testing.T, c *http.Client, url, user, password string) (int, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// TestLoadConfigSchema checks that the settings of the toolkit format are
+// accepted, and unknown ones rejected.
+func TestLoadConfigSchema(t *testing.T) {
+	dir := t.TempDir()
+	writeServerFiles(t, dir, newTestCA(t))
+
+	path := writeFile(t, dir, "web.yml", []byte(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: VerifyClientCertIfGiven
+  client_ca_file: ca.crt
+  client_allowed_sans: [client]
+  cipher_suites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256]
+  curve_preferences: [CurveP256]
+  prefer_server_cipher_suites: true
+  min_version: TLS12
+  max_version: TLS13
+http_server_config:
+  http2: false
+  headers:
+    X-Frame-Options: deny
+rate_limit:
+  burst: 10
+  interval: 1s
+`))
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+	if !c.TLS() || c.BasicAuth() {
+		t.Errorf("TLS() = %v, BasicAuth() = %v, want true, false", c.TLS(), c.BasicAuth())
+	}
+
+	path = writeFile(t, dir, "unknown.yml", []byte("tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  unknown: 1\n"))
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("LoadConfig() accepted an unknown setting")
+	}
+}
+
+func TestServeTLSBasicAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	writeServerFiles(t, dir, ca)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Relative paths are relative to the file, also once the user of
+	// --web.basic-auth-user is added.
+	path := writeFile(t, dir, "web.yml", []byte("tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\nbasic_auth_users:\n  prometheus: "+string(hash)+"\n"))
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddBasicAuthUser("extra", writeFile(t, dir, "password", []byte("other\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddBasicAuthUser("prometheus", filepath.Join(dir, "password")); err == nil {
+		t.Error("AddBasicAuthUser() accepted a user of the file")
+	}
+	url := "https://" + serve(t, c) + "/-/healthy"
+
+	tests := []struct {
+		user, password string
+		want           int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"extra", "other", http.StatusOK},
+		{"unknown", "secret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		got, err := get(t, client(ca), url, tt.user, tt.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("GET as %q/%q = %d, want %d", tt.user, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestServeMTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	writeServerFiles(t, dir, ca)
+	path := writeFile(t, dir, "web.yml", []byte(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+`))
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "https://" + serve(t, c) + "/-/healthy"
+
+	if _, err := get(t, client(ca), url, "", ""); err == nil {
+		t.Error("GET without a client certificate succeeded")
+	}
+	certPEM, keyPEM := ca.issue(t, "client", true)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := get(t, client(ca, cert), url, "", ""); err != nil || got != http.StatusOK {
+		t.Errorf("GET with a client certificate = %d, %v, want 200", got, err)
+	}
+	other := newTestCA(t)
+	certPEM, keyPEM = other.issue(t, "client", true)
+	if cert, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(t, client(ca, cert), url, "", ""); err == nil {
+		t.Error("GET with a certificate of another CA succeeded")
+	}
+}
@@ -0,0 +1,44 @@
+package web
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsHandler adds CORS headers to the responses of the API (/api/ paths)
+// to requests from origins ("*": any origin), so that browser applications
+// can read them, and answers their preflight requests. It runs after the
+// basic auth of the web configuration file, if any, which rejects preflight
+// requests: they carry no credentials.
+func corsHandler(origins []string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(slices.Contains(origins, "*") || slices.Contains(origins, origin)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// Listed origins may send credentials (basic auth), for which
+		// browsers require the origin to be echoed; any origin may not.
+		if slices.Contains(origins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Accept")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "Last-Modified")
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rateLimiter limits the request rate of each client address, with a token
+// bucket per address.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	clients   map[netip.Addr]*bucket
+	lastSweep time.Time
+
+	limited prometheus.Counter
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clients: map[netip.Addr]*bucket{},
+		limited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_http_requests_rate_limited_total",
+			Help: "Number of HTTP requests rejected by --web.rate-limit.",
+		}),
+	}
+}
+
+// allow takes a token from the bucket of addr. When it is empty, it returns
+// false and how long until the next token.
+func (l *rateLimiter) allow(addr netip.Addr, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the clients whose bucket refilled, so that the map does not grow
+	// with every address that ever scraped.
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) > full {
+		for a, b := range l.clients {
+			if now.Sub(b.last) > full {
+				delete(l.clients, a)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.clients[addr]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.clients[addr] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// handler serves 429 Too Many Requests instead of h to clients above the
+// rate. Clients of Unix domain sockets share one bucket.
+func (l *rateLimiter) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var addr netip.Addr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			addr, _ = netip.ParseAddr(host)
+			addr = addr.Unmap().WithZone("")
+		}
+		if ok, wait := l.allow(addr, time.Now()); !ok {
+			l.limited.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,191 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+
+	"conntrack-exporter/internal/collector"
+)
+
+// scope is the slice of the metrics selected by the query parameters of a
+// scrape:
+//
+//	collect[]=conntrack&collect[]=table  only these collectors (see Server.Collectors)
+//	l4proto=tcp                          series whose l4protocol label is tcp
+//	dport=443                            series whose dport label is 443
+//	src_cidr=10.0.0.0/8                  series whose src label is in the prefix
+//	dst_cidr=10.0.0.0/8                  series whose dst label is in the prefix
+//
+// Parameters are repeatable: a series passes a parameter when it matches one
+// of its values, and must pass all parameters. Series without the label of a
+// parameter (e.g. totals for l4proto) are not filtered by it.
+type scope struct {
+	collect    []string
+	l4, dports []string
+	src, dst   []netip.Prefix
+}
+
+// parseScope returns the scope of the query parameters, nil when there are
+// none.
+func parseScope(q url.Values, collectors map[string]prometheus.Gatherer) (*scope, error) {
+	sc := &scope{collect: q["collect[]"], l4: q["l4proto"], dports: q["dport"]}
+	for _, name := range sc.collect {
+		if _, ok := collectors[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	var err error
+	if sc.src, err = parsePrefixes(q["src_cidr"]); err != nil {
+		return nil, err
+	}
+	if sc.dst, err = parsePrefixes(q["dst_cidr"]); err != nil {
+		return nil, err
+	}
+	if len(sc.collect)+len(sc.l4)+len(sc.dports)+len(sc.src)+len(sc.dst) == 0 {
+		return nil, nil
+	}
+	return sc, nil
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var ps []netip.Prefix
+	for _, v := range values {
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", v)
+		}
+		ps = append(ps, p.Masked())
+	}
+	return ps, nil
+}
+
+// gatherer returns the gatherer of the scope: exporter metrics from base,
+// then the selected collectors (all when collect[] is not set), filtered.
+func (sc *scope) gatherer(base prometheus.Gatherer, collectors map[string]prometheus.Gatherer) prometheus.Gatherer {
+	gs := prometheus.Gatherers{base}
+	for _, name := range sortedNames(collectors) {
+		if len(sc.collect) == 0 || slices.Contains(sc.collect, name) {
+			gs = append(gs, collectors[name])
+		}
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := gs.Gather()
+		out := mfs[:0]
+		for _, mf := range mfs {
+			ms := mf.Metric[:0]
+			for _, m := range mf.Metric {
+				if sc.match(m) {
+					ms = append(ms, m)
+				}
+			}
+			if mf.Metric = ms; len(ms) > 0 {
+				out = append(out, mf)
+			}
+		}
+		return out, err
+	})
+}
+
+func (sc *scope) match(m *dto.Metric) bool {
+	for _, lp := range m.GetLabel() {
+		if !sc.matchLabel(lp.GetName(), lp.GetValue()) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchLabel reports whether a label passes the filter parameters.
+func (sc *scope) matchLabel(name, value string) bool {
+	switch name {
+	case "l4protocol":
+		return len(sc.l4) == 0 || slices.Contains(sc.l4, value)
+	case "dport":
+		return len(sc.dports) == 0 || slices.Contains(sc.dports, value)
+	case "src":
+		return len(sc.src) == 0 || inPrefixes(sc.src, value)
+	case "dst":
+		return len(sc.dst) == 0 || inPrefixes(sc.dst, value)
+	}
+	return true
+}
+
+// inPrefixes reports whether an address, or a prefix (see
+// --collector.aggregate-cidr), is in one of ps. Other values (e.g. overflow)
+// are not.
+func inPrefixes(ps []netip.Prefix, v string) bool {
+	p, err := netip.ParsePrefix(v)
+	if err != nil {
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return false
+		}
+		p = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	for _, f := range ps {
+		if p.Bits() >= f.Bits() && f.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeHandler serves the metrics of base and collectors: scrapes with query
+// parameters from the gatherer of their scope, the others with a handler of
+// all metrics. Both share the in-flight limit of opts.
+func scopeHandler(base prometheus.Gatherer, collectors map[string]prometheus.Gatherer, opts promhttp.HandlerOpts) http.Handler {
+	var inFlight chan struct{}
+	if opts.MaxRequestsInFlight > 0 {
+		inFlight = make(chan struct{}, opts.MaxRequestsInFlight)
+	}
+	opts.MaxRequestsInFlight = 0
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, err := parseScope(r.URL.Query(), collectors)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if inFlight != nil {
+			select {
+			case inFlight <- struct{}{}:
+				defer func() { <-inFlight }()
+			default:
+				http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", cap(inFlight)), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		// Refreshes in scrape mode stop once the client is gone.
+		cs := make(map[string]prometheus.Gatherer, len(collectors))
+		for name, g := range collectors {
+			cs[name] = collector.ContextGatherer(r.Context(), g)
+		}
+		var g prometheus.Gatherer
+		if sc != nil {
+			g = sc.gatherer(base, cs)
+		} else {
+			gatherers := prometheus.Gatherers{base}
+			for _, name := range sortedNames(cs) {
+				gatherers = append(gatherers, cs[name])
+			}
+			g = gatherers
+		}
+		promhttp.HandlerFor(g, opts).ServeHTTP(w, r)
+	})
+}
+
+func sortedNames(m map[string]prometheus.Gatherer) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
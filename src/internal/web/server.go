@@ -2,25 +2,114 @@ package web
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	toolkit "github.com/prometheus/exporter-toolkit/web"
 
+	"conntrack-exporter/internal/collector"
 	"conntrack-exporter/internal/logging"
 )
 
 // Server exposes Prometheus metrics via HTTP.
 type Server struct {
 	Logger *logging.Logger
+	// LogComponents are the components whose level /-/loglevel can set (see
+	// logging.ParseLevels).
+	LogComponents []string
 
 	Registry       *prometheus.Registry
+	// Registerer registers the promhttp_ metrics (Registry when nil).
+	Registerer     prometheus.Registerer
+	// Collectors are the metrics of the collectors by name, served with
+	// Registry; scrapes can select some of them, and filter series, with
+	// query parameters (see scope).
+	Collectors     map[string]prometheus.Gatherer
+	// Flows returns the aggregated keys of the last snapshot, served as JSON
+	// on /api/v1/connections and as an HTML page on /top (not served when
+	// nil).
+	Flows          func() ([]collector.Flow, time.Time)
+	// ExporterRegisterer registers the exporter_ metrics of the server, with
+	// the metric prefix (not registered when nil).
+	ExporterRegisterer prometheus.Registerer
 	TelemetryPath  string
+	// CollectorsPath, when set, serves the metrics of Collectors instead of
+	// TelemetryPath, which then only serves the exporter's own metrics (Go
+	// runtime, process, self-monitoring).
+	CollectorsPath string
+	// ListenAddrs are TCP host:port addresses, or unix:///path for a Unix
+	// domain socket.
 	ListenAddrs    []string
+	// AdminListenAddrs serve the operational endpoints (see registerAdmin)
+	// instead of ListenAddrs, which then only serve metrics and data.
+	AdminListenAddrs []string
+	// SocketMode and SocketGroup (name or gid) set the permissions of Unix
+	// domain sockets (0660 and the process group when zero/empty).
+	SocketMode     os.FileMode
+	SocketGroup    string
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are the
+	// timeouts of the HTTP servers (see http.Server; ReadHeaderTimeout
+	// defaults to 5s, the others to no limit when zero).
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long a graceful shutdown waits for in-flight
+	// requests before closing their connections (5s when zero).
+	ShutdownTimeout time.Duration
+	// TolerateListenErrors keeps serving on the other listen addresses when
+	// one fails to bind or serve, instead of failing Start (which still
+	// fails once no listener is left).
+	TolerateListenErrors bool
+	// Listening is called once the listeners are bound (optional).
+	Listening func()
 	MaxRequests    int
+	// RateLimit limits the scrapes of each client address to this many
+	// requests per second, with bursts of RateLimitBurst (no limit when
+	// zero).
+	RateLimit      float64
+	RateLimitBurst int
 	DisableExpMetrics bool
+	// OpenMetrics offers the OpenMetrics text format (with _created samples
+	// for counters) to clients asking for it.
+	OpenMetrics    bool
+	// DisableCompression always serves uncompressed responses, even to
+	// clients accepting gzip.
+	DisableCompression bool
+	// WebConfig enables TLS and basic auth, served by the exporter-toolkit
+	// (see LoadConfig; plain HTTP without auth when nil).
+	WebConfig      *Config
+	// AllowList rejects clients outside its prefixes with 403 Forbidden, on
+	// all listeners (optional).
+	AllowList      *AllowList
+	// CORSOrigins are the origins of browser applications allowed to read the
+	// API ("*": any origin, see corsHandler).
+	CORSOrigins    []string
+	// EnablePprof serves the net/http/pprof profiles under /debug/pprof/,
+	// with an admin listener or basic auth only.
+	EnablePprof    bool
+	// Tracing serves each request in a trace span (see traceHandler).
+	Tracing        bool
+	// Reload and Quit, when set, are called on POST or PUT of /-/reload and
+	// /-/quit (the response of /-/reload has the error of Reload).
+	Reload         func() error
+	Quit           func()
+	// Ready fails the /-/ready endpoint with 503 Service Unavailable while it
+	// returns an error (optional).
+	Ready          func() error
+	// Check fails scrapes with 503 Service Unavailable while it returns an
+	// error (optional).
+	Check          func() error
 }
 
 // Start launches HTTP servers for all configured listen addresses.
@@ -32,71 +121,289 @@ func (s *Server) Start(ctx context.Context) error {
 	if s.TelemetryPath == "" {
 		s.TelemetryPath = "/metrics"
 	}
+	if s.ReadHeaderTimeout == 0 {
+		s.ReadHeaderTimeout = 5 * time.Second
+	}
+	webConfigFile, removeWebConfig, err := s.WebConfig.file()
+	if err != nil {
+		return err
+	}
+	defer removeWebConfig()
+	toolkitFlags := &toolkit.FlagConfig{WebConfigFile: &webConfigFile}
+	// The toolkit logs the listeners like Start: only keep its warnings and
+	// errors.
+	toolkitLog := slog.New(slog.DiscardHandler)
+	if s.Logger != nil {
+		toolkitLog = logging.FromHandler(s.Logger.Handler(), logging.Warn).Slog()
+	}
 
-	handlerOpts := promhttp.HandlerOpts{}
+	handlerOpts := promhttp.HandlerOpts{
+		EnableOpenMetrics:                   s.OpenMetrics,
+		EnableOpenMetricsTextCreatedSamples: s.OpenMetrics,
+		DisableCompression:                  s.DisableCompression,
+	}
 	if s.MaxRequests > 0 {
 		handlerOpts.MaxRequestsInFlight = s.MaxRequests
 	}
 
-	baseHandler := promhttp.HandlerFor(s.Registry, handlerOpts)
+	// With CollectorsPath, the collectors move out of TelemetryPath.
+	collectors := s.Collectors
+	if s.CollectorsPath != "" {
+		collectors = nil
+	}
+	var baseHandler http.Handler = scopeHandler(s.Registry, collectors, handlerOpts)
+	if s.Check != nil && s.CollectorsPath == "" {
+		baseHandler = checkHandler(s.Check, baseHandler)
+	}
 	var metricsHandler http.Handler = baseHandler
 
 	// promhttp_ metrics are only registered if we wrap with InstrumentMetricHandler.
 	if !s.DisableExpMetrics {
-		metricsHandler = promhttp.InstrumentMetricHandler(s.Registry, baseHandler)
+		var reg prometheus.Registerer = s.Registry
+		if s.Registerer != nil {
+			reg = s.Registerer
+		}
+		metricsHandler = promhttp.InstrumentMetricHandler(reg, baseHandler)
+	}
+
+	var collectorsHandler http.Handler
+	if s.CollectorsPath != "" {
+		collectorsHandler = scopeHandler(prometheus.Gatherers{}, s.Collectors, handlerOpts)
+		if s.Check != nil {
+			collectorsHandler = checkHandler(s.Check, collectorsHandler)
+		}
+	}
+	if s.RateLimit > 0 {
+		limiter := newRateLimiter(s.RateLimit, s.RateLimitBurst)
+		if s.ExporterRegisterer != nil {
+			s.ExporterRegisterer.MustRegister(limiter.limited)
+		}
+		metricsHandler = limiter.handler(metricsHandler)
+		if collectorsHandler != nil {
+			collectorsHandler = limiter.handler(collectorsHandler)
+		}
 	}
 
 	mux := http.NewServeMux()
 	mux.Handle(s.TelemetryPath, metricsHandler)
+	if collectorsHandler != nil {
+		mux.Handle(s.CollectorsPath, collectorsHandler)
+	}
+	if s.Flows != nil {
+		mux.Handle("/api/v1/connections", apiHandler(s.Flows))
+		mux.Handle("/top", topHandler(s.Flows))
+	}
+	adminMux := mux
+	if len(s.AdminListenAddrs) > 0 {
+		adminMux = http.NewServeMux()
+	}
+	s.registerAdmin(adminMux)
 
-	errCh := make(chan error, len(s.ListenAddrs))
-	servers := make([]*http.Server, 0, len(s.ListenAddrs))
-
+	type listener struct {
+		addr    string
+		handler http.Handler
+		admin   bool
+	}
+	var listeners []listener
+	handler, adminHandler := s.wrap(mux), s.wrap(adminMux)
 	for _, addr := range s.ListenAddrs {
+		listeners = append(listeners, listener{addr, handler, false})
+	}
+	for _, addr := range s.AdminListenAddrs {
+		listeners = append(listeners, listener{addr, adminHandler, true})
+	}
+
+	listenerUp := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "exporter_listener_up",
+		Help: "Whether the HTTP listener on addr is serving (1), or failed to bind or serve (0).",
+	}, []string{"addr"})
+	if s.ExporterRegisterer != nil {
+		s.ExporterRegisterer.MustRegister(listenerUp)
+	}
+
+	type result struct {
+		addr string
+		err  error
+	}
+	errCh := make(chan result, len(listeners))
+	servers := make([]*http.Server, 0, len(listeners))
+
+	for _, l := range listeners {
+		addr := l.addr
 		srv := &http.Server{
 			Addr:              addr,
-			Handler:           mux,
-			ReadHeaderTimeout: 5 * time.Second,
+			Handler:           l.handler,
+			ReadHeaderTimeout: s.ReadHeaderTimeout,
+			ReadTimeout:       s.ReadTimeout,
+			WriteTimeout:      s.WriteTimeout,
+			IdleTimeout:       s.IdleTimeout,
 		}
-		servers = append(servers, srv)
-
-		ln, err := net.Listen("tcp", addr)
+		ln, err := s.listen(addr)
 		if err != nil {
-			return err
+			err = &ListenError{Addr: addr, Err: err}
+			listenerUp.WithLabelValues(addr).Set(0)
+			if !s.TolerateListenErrors {
+				s.shutdown(servers)
+				return err
+			}
+			if s.Logger != nil {
+				s.Logger.Error("failed to listen", "addr", addr, "err", err)
+			}
+			continue
 		}
+		servers = append(servers, srv)
+		listenerUp.WithLabelValues(addr).Set(1)
 
 		if s.Logger != nil {
-			s.Logger.Info("http server started", "addr", addr, "path", s.TelemetryPath)
+			if l.admin {
+				s.Logger.Info("admin http server started", "addr", addr, "tls", s.WebConfig.TLS())
+			} else {
+				s.Logger.Info("http server started", "addr", addr, "path", s.TelemetryPath, "tls", s.WebConfig.TLS())
+			}
 		}
 
 		go func(srv *http.Server, ln net.Listener) {
-			err := srv.Serve(ln)
-			if err == nil || err == http.ErrServerClosed {
-				errCh <- nil
-				return
+			err := toolkit.Serve(ln, srv, toolkitFlags, toolkitLog)
+			if err == http.ErrServerClosed {
+				err = nil
 			}
-			errCh <- err
+			errCh <- result{srv.Addr, err}
 		}(srv, ln)
 	}
+	if len(servers) == 0 {
+		return &ListenError{Err: errors.New("no listen address could be bound")}
+	}
+	if s.Listening != nil {
+		s.Listening()
+	}
 
-	// Wait for shutdown or first error.
-	select {
-	case <-ctx.Done():
-	case err := <-errCh:
-		if err != nil {
-			return err
+	// Wait for shutdown, or for errors.
+	for running := len(servers); ; {
+		select {
+		case <-ctx.Done():
+			s.shutdown(servers)
+			return nil
+		case r := <-errCh:
+			if r.err == nil {
+				// If one server exits cleanly unexpectedly, continue and wait for ctx.
+				continue
+			}
+			listenerUp.WithLabelValues(r.addr).Set(0)
+			running--
+			if !s.TolerateListenErrors || running == 0 {
+				s.shutdown(servers)
+				return r.err
+			}
+			if s.Logger != nil {
+				s.Logger.Error("http server error", "addr", r.addr, "err", r.err)
+			}
 		}
-		// If one server exits cleanly unexpectedly, continue and wait for ctx.
-		<-ctx.Done()
 	}
+}
+
+// ListenError is a listen address that could not be bound, returned by
+// Start. Addr is empty when no address of several could be bound.
+type ListenError struct {
+	Addr string
+	Err  error
+}
+
+func (e *ListenError) Error() string { return e.Err.Error() }
+
+func (e *ListenError) Unwrap() error { return e.Err }
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// wrap adds the client checks to the handler of a listener. The toolkit
+// adds the authentication and headers of WebConfig in front of them.
+func (s *Server) wrap(h http.Handler) http.Handler {
+	if len(s.CORSOrigins) > 0 {
+		h = corsHandler(s.CORSOrigins, h)
+	}
+	if s.AllowList != nil {
+		h = s.AllowList.handler(h)
+	}
+	if s.Tracing {
+		h = traceHandler(h)
+	}
+	return h
+}
+
+// shutdown gracefully stops servers: they stop accepting connections, and
+// in-flight requests (e.g. scrapes) get ShutdownTimeout to complete before
+// their connections are closed.
+func (s *Server) shutdown(servers []*http.Server) {
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	for _, srv := range servers {
-		_ = srv.Shutdown(shutdownCtx)
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			if s.Logger != nil {
+				s.Logger.Warn("in-flight requests did not complete before the shutdown timeout", "addr", srv.Addr, "timeout", timeout)
+			}
+			_ = srv.Close()
+		}
 	}
+}
+
+// listen listens on a TCP address, or a Unix domain socket for unix://
+// addresses.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	// Remove the socket left by a previous run that did not shut down
+	// cleanly; anything else is left alone, and Listen fails.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode := s.SocketMode
+	if mode == 0 {
+		mode = 0o660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if s.SocketGroup != "" {
+		gid, err := lookupGroup(s.SocketGroup)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("socket group: %w", err)
+		}
+	}
+	return ln, nil
+}
 
-	return nil
+// lookupGroup returns the gid of a group name or number.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
 }
 
+// checkHandler serves 503 Service Unavailable instead of h while check fails.
+func checkHandler(check func() error, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, "collection failed: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"conntrack-exporter/internal/collector"
+)
+
+var topTemplate = template.Must(template.New("top").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>conntrack top</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { padding: 2px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num { text-align: right; font-family: monospace; }
+th a { color: inherit; }
+th.sorted { background: #eee; }
+</style>
+</head>
+<body>
+<h1>conntrack top</h1>
+<p>{{if .Time}}Snapshot of {{.Time.Format "2006-01-02 15:04:05 MST"}}: {{.Total}} keys{{if lt (len .Flows) .Total}}, top {{len .Flows}}{{end}}.{{else}}No snapshot yet.{{end}}
+Refreshed every {{.Refresh}}s.</p>
+<table>
+<tr>{{range .Labels}}<th>{{.}}</th>{{end}}{{range .Columns}}<th{{if .Sorted}} class="sorted"{{end}}><a href="{{.URL}}">{{.Name}}</a></th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .Labels}}<td>{{.}}</td>{{end}}{{range .Values}}<td class="num">{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// topColumns are the value columns of the page, by sort key (see flowSorts).
+var topColumns = []string{"connections", "sent_packets", "sent_bytes", "reply_packets", "reply_bytes", "packets", "bytes"}
+
+type topColumn struct {
+	Name   string
+	URL    string
+	Sorted bool
+}
+
+type topRow struct {
+	Labels []string
+	Values []uint64
+}
+
+type topPage struct {
+	Time    *time.Time
+	Total   int
+	Refresh int
+	Labels  []string
+	Columns []topColumn
+	Rows    []topRow
+	Flows   []collector.Flow
+}
+
+// topHandler serves an HTML page of the top flows of the last snapshot, on
+// /top. It takes the parameters of the connections API (see flowQuery, with
+// a limit of 50 by default), and refresh (seconds between reloads of the
+// page, 10 by default).
+func topHandler(flowsOf func() ([]collector.Flow, time.Time)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if !q.Has("limit") {
+			q.Set("limit", "50")
+			r.URL.RawQuery = q.Encode()
+		}
+		fq, err := parseFlowQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := topPage{Refresh: 10}
+		if s := q.Get("refresh"); s != "" {
+			if page.Refresh, err = strconv.Atoi(s); err != nil || page.Refresh < 1 {
+				http.Error(w, "invalid refresh "+strconv.Quote(s), http.StatusBadRequest)
+				return
+			}
+		}
+
+		flows, at := flowsOf()
+		if !at.IsZero() {
+			page.Time = &at
+		}
+		page.Flows, page.Total = fq.apply(flows)
+		for _, f := range page.Flows {
+			for name := range f.Labels {
+				if !slices.Contains(page.Labels, name) {
+					page.Labels = append(page.Labels, name)
+				}
+			}
+		}
+		slices.Sort(page.Labels)
+		for _, name := range topColumns {
+			page.Columns = append(page.Columns, topColumn{Name: name, URL: "?" + withParam(q, "sort", name), Sorted: name == fq.sort})
+		}
+		for _, f := range page.Flows {
+			row := topRow{}
+			for _, name := range page.Labels {
+				row.Labels = append(row.Labels, f.Labels[name])
+			}
+			for _, name := range topColumns {
+				row.Values = append(row.Values, flowSorts[name](f))
+			}
+			page.Rows = append(page.Rows, row)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = topTemplate.Execute(w, page)
+	})
+}
+
+// withParam returns the encoding of q with a parameter replaced.
+func withParam(q url.Values, name, value string) string {
+	c := url.Values{}
+	for k, v := range q {
+		c[k] = v
+	}
+	c.Set(name, value)
+	return c.Encode()
+}